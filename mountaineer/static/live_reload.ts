@@ -7,6 +7,7 @@ import { useEffect } from "react";
 interface CustomProcess {
   env: {
     LIVE_RELOAD_PORT?: string;
+    LIVE_RELOAD_HOST?: string;
     NODE_ENV?: string;
     SSR_RENDERING?: string;
   };
@@ -119,7 +120,7 @@ const mountLiveReload = ({ host, port }: { host?: string; port?: number }) => {
     return;
   }
 
-  if (!host) host = "localhost";
+  if (!host) host = process.env.LIVE_RELOAD_HOST || "localhost";
   if (!port) port = Number(process.env.LIVE_RELOAD_PORT) || 5015;
 
   useEffect(() => {
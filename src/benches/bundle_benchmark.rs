@@ -0,0 +1,42 @@
+use criterion::{black_box, criterion_group, criterion_main, Criterion};
+use std::fs;
+
+use src_go::bundle_all;
+
+fn bundle_options(working_dir: &str, charset: &str) -> String {
+    format!(
+        r#"{{"entrypoints": ["i18n_messages.js"], "outdir": "dist/{charset}", "production": true, "hashNames": false, "charset": "{charset}", "absWorkingDir": "{working_dir}"}}"#
+    )
+}
+
+fn criterion_benchmark(c: &mut Criterion) {
+    // Assume we're being called from the project root, where the Cargo.toml is located
+    let working_dir = tempfile::tempdir().unwrap();
+    fs::copy(
+        "src/benches/fixtures/i18n_messages.js",
+        working_dir.path().join("i18n_messages.js"),
+    )
+    .expect("Error copying fixture");
+    let working_path = working_dir.path().to_str().unwrap();
+
+    let mut group = c.benchmark_group("bundle_i18n");
+    for charset in ["ascii", "utf8"] {
+        let options = bundle_options(working_path, charset);
+        group.bench_function(charset, |b| {
+            b.iter(|| bundle_all(black_box(&options)).unwrap())
+        });
+
+        // Escaped text is what the charset costs, so report the size alongside the timing
+        let output = working_dir
+            .path()
+            .join(format!("dist/{charset}/i18n_messages.js"));
+        println!(
+            "{charset} output: {} bytes",
+            fs::metadata(output).unwrap().len()
+        );
+    }
+    group.finish();
+}
+
+criterion_group!(benches, criterion_benchmark);
+criterion_main!(benches);
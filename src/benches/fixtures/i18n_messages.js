@@ -0,0 +1,56 @@
+// Translation catalog shaped like a typical i18n bundle: mostly non-ASCII
+// string literals, which the ascii charset has to escape
+export const messages = {
+  en: {
+    greeting: "Welcome back",
+    cart: "Your cart is empty",
+    checkout: "Proceed to checkout",
+    reaction: "Thanks for your order! 🎉",
+  },
+  ja: {
+    greeting: "おかえりなさい",
+    cart: "カートは空です",
+    checkout: "購入手続きへ進む",
+    reaction: "ご注文ありがとうございます！🎉",
+  },
+  zh: {
+    greeting: "欢迎回来",
+    cart: "您的购物车是空的",
+    checkout: "前往结账",
+    reaction: "感谢您的订单！🎉",
+  },
+  ko: {
+    greeting: "다시 오신 것을 환영합니다",
+    cart: "장바구니가 비어 있습니다",
+    checkout: "결제 진행",
+    reaction: "주문해 주셔서 감사합니다! 🎉",
+  },
+  ru: {
+    greeting: "С возвращением",
+    cart: "Ваша корзина пуста",
+    checkout: "Перейти к оформлению заказа",
+    reaction: "Спасибо за заказ! 🎉",
+  },
+  ar: {
+    greeting: "مرحبًا بعودتك",
+    cart: "سلة التسوق فارغة",
+    checkout: "المتابعة إلى الدفع",
+    reaction: "شكرًا لطلبك! 🎉",
+  },
+  hi: {
+    greeting: "वापसी पर स्वागत है",
+    cart: "आपकी कार्ट खाली है",
+    checkout: "चेकआउट के लिए आगे बढ़ें",
+    reaction: "आपके ऑर्डर के लिए धन्यवाद! 🎉",
+  },
+  el: {
+    greeting: "Καλώς ήρθατε ξανά",
+    cart: "Το καλάθι σας είναι άδειο",
+    checkout: "Συνέχεια στην πληρωμή",
+    reaction: "Ευχαριστούμε για την παραγγελία σας! 🎉",
+  },
+};
+
+export function translate(locale, key) {
+  return (messages[locale] || messages.en)[key] || messages.en[key];
+}
@@ -0,0 +1,52 @@
+use criterion::{black_box, criterion_group, criterion_main, Criterion};
+
+use src_go::{
+    clear_transform_cache, get_transform_cache_stats, set_transform_cache_size, transform_many,
+    transform_with_source_map,
+};
+
+const SNIPPET_COUNT: usize = 200;
+
+fn criterion_benchmark(c: &mut Criterion) {
+    let sources: Vec<String> = (0..SNIPPET_COUNT)
+        .map(|index| {
+            format!(
+                "const handler{index} = (event: Event): void => {{ console.log(event.type, {index}); }};"
+            )
+        })
+        .collect();
+    let sources: Vec<&str> = sources.iter().map(|source| source.as_str()).collect();
+    let loaders = vec!["ts"; SNIPPET_COUNT];
+
+    let mut group = c.benchmark_group("transform_snippets");
+
+    group.bench_function("individual_calls", |b| {
+        b.iter(|| {
+            for source in &sources {
+                transform_with_source_map(black_box(source), "snippet.ts", "ts", "").unwrap();
+            }
+        })
+    });
+
+    group.bench_function("transform_many", |b| {
+        b.iter(|| transform_many(black_box(&sources), &loaders).unwrap())
+    });
+
+    // Re-transforming unchanged snippets, like a dev loop does, with the cache enabled
+    set_transform_cache_size(SNIPPET_COUNT as i32);
+    group.bench_function("individual_calls_cached", |b| {
+        b.iter(|| {
+            for source in &sources {
+                transform_with_source_map(black_box(source), "snippet.ts", "ts", "").unwrap();
+            }
+        })
+    });
+    println!("transform cache: {}", get_transform_cache_stats());
+    clear_transform_cache();
+    set_transform_cache_size(0);
+
+    group.finish();
+}
+
+criterion_group!(benches, criterion_benchmark);
+criterion_main!(benches);
@@ -161,7 +161,9 @@ fn mountaineer(_py: Python, m: &PyModule) -> PyResult<()> {
                 &param.node_modules_path,
                 &param.environment,
                 param.live_reload_port,
+                "",
                 param.is_server,
+                "",
             );
             match context_result {
                 Ok(context_id) => {
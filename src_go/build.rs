@@ -34,7 +34,7 @@ fn main() {
             out_path.join("libgo.a").to_str().unwrap(),
             "-ldflags",
             "-s -w", // Strips debug information, can minimize the payload somewhat
-            "./go/js_build.go",
+            "./go",
         ])
         .status()
         .expect("Failed to execute go build");
@@ -55,7 +55,7 @@ fn main() {
         .expect("Couldn't write bindings!");
 
     // Inform Cargo about the dependencies and how to link the library.
-    println!("cargo:rerun-if-changed=go/list_struct.go");
+    println!("cargo:rerun-if-changed=go");
     println!("cargo:rustc-link-search=native={}", out_dir);
     println!("cargo:rustc-link-lib=static=go");
 
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// ImportAttributesPlugin picks the loader from an import's type attribute
+// rather than the file extension, so the same file can be imported both
+// ways:
+//   - `with { type: "json" }` parses the file as JSON. esbuild does this
+//     itself; it's listed here for completeness.
+//   - `with { type: "css" }` follows CSS module scripts: the default export
+//     is a CSSStyleSheet with the file's rules, for adoptedStyleSheets. The
+//     stylesheet is used as written, without bundling its @imports, and is
+//     null where CSSStyleSheet doesn't exist, like during SSR.
+//
+// Any other type is left to esbuild, which rejects it.
+func ImportAttributesPlugin() api.Plugin {
+	return api.Plugin{
+		Name: "import-attributes",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: "file"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					if args.With["type"] != "css" {
+						return api.OnLoadResult{}, nil
+					}
+
+					stylesheet, err := os.ReadFile(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					encodedStylesheet, err := json.Marshal(string(stylesheet))
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+
+					contents := fmt.Sprintf(`const sheet = typeof CSSStyleSheet === "undefined" ? null : new CSSStyleSheet();
+if (sheet) sheet.replaceSync(%s);
+export default sheet;
+`, encodedStylesheet)
+					return api.OnLoadResult{Contents: &contents, Loader: api.LoaderJS}, nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Browserslist browser names, including its aliases, to the esbuild engine
+// with the same versioning. Mobile Chrome and Firefox track desktop.
+var browserslistEngines = map[string]string{
+	"chrome":         "chrome",
+	"and_chr":        "chrome",
+	"chromeandroid":  "chrome",
+	"edge":           "edge",
+	"firefox":        "firefox",
+	"ff":             "firefox",
+	"and_ff":         "firefox",
+	"firefoxandroid": "firefox",
+	"safari":         "safari",
+	"ios_saf":        "ios",
+	"ios":            "ios",
+	"opera":          "opera",
+	"node":           "node",
+	"ie":             "ie",
+	"explorer":       "ie",
+}
+
+// "chrome >= 100", "safari 15.4", or "ios_saf 15.2-15.3"
+var browserslistQueryPattern = regexp.MustCompile(`^([a-z_]+)\s*(>=|>)?\s*(\d+(?:\.\d+)*)(?:-\d+(?:\.\d+)*)?$`)
+
+// BrowserslistTarget translates browserslist queries into an esbuild target
+// string like "chrome100,safari15.4", keeping the oldest version of each
+// engine. Only queries naming a browser and version can be mapped, since
+// the rest ("> 0.5%", "last 2 versions", "defaults") need caniuse's usage
+// data; those fail. "not" queries only narrow the list, so they're skipped,
+// which leaves the target at least as compatible as the query asks. A
+// range like "15.2-15.3" counts from its start, and "> 99" is read as
+// ">= 99".
+func BrowserslistTarget(queries []string) (string, error) {
+	minimumVersions := map[string]string{}
+	for _, query := range queries {
+		query = strings.ToLower(strings.TrimSpace(query))
+		if query == "" || strings.HasPrefix(query, "not ") {
+			continue
+		}
+		match := browserslistQueryPattern.FindStringSubmatch(query)
+		if match == nil {
+			return "", fmt.Errorf("Unsupported browserslist query %q: only queries like \"chrome >= 100\" can be mapped to a target", query)
+		}
+		engine, exists := browserslistEngines[match[1]]
+		if !exists {
+			return "", fmt.Errorf("Unsupported browserslist browser %q in %q", match[1], query)
+		}
+		version := match[3]
+		if current, exists := minimumVersions[engine]; !exists || compareVersions(version, current) < 0 {
+			minimumVersions[engine] = version
+		}
+	}
+	if len(minimumVersions) == 0 {
+		return "", fmt.Errorf("The browserslist config doesn't list any browsers")
+	}
+
+	targets := make([]string, 0, len(minimumVersions))
+	for engine, version := range minimumVersions {
+		targets = append(targets, engine+version)
+	}
+	sort.Strings(targets)
+	return strings.Join(targets, ","), nil
+}
+
+// LoadBrowserslist reads the queries for environment from configPath, or if
+// that's empty, from the first of package.json's "browserslist" field,
+// .browserslistrc, or browserslist found in dir. As in browserslist itself,
+// a section for the environment replaces the default queries.
+func LoadBrowserslist(configPath string, dir string, environment string) ([]string, error) {
+	if configPath != "" {
+		if filepath.Base(configPath) == "package.json" {
+			queries, found, err := readPackageBrowserslist(configPath, environment)
+			if err == nil && !found {
+				err = fmt.Errorf("No \"browserslist\" field in %s", configPath)
+			}
+			return queries, err
+		}
+		return readBrowserslistrc(configPath, environment)
+	}
+
+	queries, found, err := readPackageBrowserslist(filepath.Join(dir, "package.json"), environment)
+	if err != nil || found {
+		return queries, err
+	}
+	for _, name := range []string{".browserslistrc", "browserslist"} {
+		rcPath := filepath.Join(dir, name)
+		if _, err := os.Stat(rcPath); err == nil {
+			return readBrowserslistrc(rcPath, environment)
+		}
+	}
+	return nil, fmt.Errorf("No browserslist config found in %s", dir)
+}
+
+// readPackageBrowserslist reads package.json's "browserslist" field, a query
+// string, an array of them, or an object of environment to queries with a
+// "defaults" fallback. A missing package.json or field isn't an error.
+func readPackageBrowserslist(packagePath string, environment string) ([]string, bool, error) {
+	contents, err := os.ReadFile(packagePath)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	var manifest struct {
+		Browserslist json.RawMessage `json:"browserslist"`
+	}
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		return nil, false, fmt.Errorf("Invalid package.json %s: %s", packagePath, err)
+	}
+	if manifest.Browserslist == nil {
+		return nil, false, nil
+	}
+
+	var query string
+	if err := json.Unmarshal(manifest.Browserslist, &query); err == nil {
+		return strings.Split(query, ","), true, nil
+	}
+	var queries []string
+	if err := json.Unmarshal(manifest.Browserslist, &queries); err == nil {
+		return queries, true, nil
+	}
+	var queriesByEnvironment map[string][]string
+	if err := json.Unmarshal(manifest.Browserslist, &queriesByEnvironment); err != nil {
+		return nil, false, fmt.Errorf("Invalid \"browserslist\" field in %s: expected a string, an array, or an object of arrays", packagePath)
+	}
+	if queries, exists := queriesByEnvironment[environment]; exists {
+		return queries, true, nil
+	}
+	return queriesByEnvironment["defaults"], true, nil
+}
+
+// readBrowserslistrc reads a .browserslistrc: one or more comma-separated
+// queries per line, # comments, and [env] sections that may name several
+// environments, like "[production staging]".
+func readBrowserslistrc(rcPath string, environment string) ([]string, error) {
+	file, err := os.Open(rcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	defaults := []string{}
+	var environmentQueries []string
+	inEnvironment, inSection := false, false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if index := strings.Index(line, "#"); index != -1 {
+			line = line[:index]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = true
+			inEnvironment = containsString(strings.Fields(line[1:len(line)-1]), environment)
+			if inEnvironment && environmentQueries == nil {
+				environmentQueries = []string{}
+			}
+			continue
+		}
+		queries := strings.Split(line, ",")
+		switch {
+		case !inSection:
+			defaults = append(defaults, queries...)
+		case inEnvironment:
+			environmentQueries = append(environmentQueries, queries...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if environmentQueries != nil {
+		return environmentQueries, nil
+	}
+	return defaults, nil
+}
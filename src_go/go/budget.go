@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// FindBudgetOverruns checks output sizes against byte budgets and describes
+// every output that's over. maxBytes applies to each output (0 for no
+// limit), and entryBudgets, keyed by entrypoint path, to the output of that
+// entrypoint only, taking precedence over maxBytes. Sourcemaps are never
+// counted. With gzipSizes, sizes are measured after gzip compression, which
+// is closer to what's sent over the wire.
+func FindBudgetOverruns(
+	outputFiles []api.OutputFile,
+	metafile Metafile,
+	workingDir string,
+	maxBytes int,
+	entryBudgets map[string]int,
+	gzipSizes bool,
+	pool *ioPool,
+) ([]string, error) {
+	// Metafile entry points are relative to the working directory
+	budgetsByEntry := make(map[string]int, len(entryBudgets))
+	for entryPoint, budget := range entryBudgets {
+		if !filepath.IsAbs(entryPoint) {
+			entryPoint = filepath.Join(workingDir, entryPoint)
+		}
+		budgetsByEntry[filepath.Clean(entryPoint)] = budget
+	}
+
+	type budgetedOutput struct {
+		path     string
+		contents []byte
+		budget   int
+	}
+	budgeted := []budgetedOutput{}
+	for _, outputFile := range SortOutputFiles(outputFiles) {
+		if strings.HasSuffix(outputFile.Path, ".map") {
+			continue
+		}
+
+		budget := maxBytes
+		relativePath, err := filepath.Rel(workingDir, outputFile.Path)
+		if err != nil {
+			return nil, err
+		}
+		if output, exists := metafile.Outputs[filepath.ToSlash(relativePath)]; exists && output.EntryPoint != "" {
+			if entryBudget, exists := budgetsByEntry[filepath.Join(workingDir, output.EntryPoint)]; exists {
+				budget = entryBudget
+			}
+		}
+		if budget <= 0 {
+			continue
+		}
+		budgeted = append(budgeted, budgetedOutput{outputFile.Path, outputFile.Contents, budget})
+	}
+
+	// Compressing is the slow part, so it's spread across the pool
+	sizes := make([]int, len(budgeted))
+	sizeLabel := "bytes"
+	if gzipSizes {
+		sizeLabel = "bytes gzipped"
+	}
+	err := pool.each(len(budgeted), func(index int) error {
+		var err error
+		sizes[index] = len(budgeted[index].contents)
+		if gzipSizes {
+			sizes[index], err = gzipSize(budgeted[index].contents)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	overruns := []string{}
+	for index, output := range budgeted {
+		if sizes[index] > output.budget {
+			overruns = append(overruns, fmt.Sprintf("%s is %d %s, over its budget of %d", output.path, sizes[index], sizeLabel, output.budget))
+		}
+	}
+	return overruns, nil
+}
+
+func gzipSize(contents []byte) (int, error) {
+	var buffer bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := writer.Write(contents); err != nil {
+		return 0, err
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return buffer.Len(), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindGitDir walks up from dir to the nearest repository and returns its git
+// directory. Worktrees and submodules have a .git file pointing elsewhere
+// instead of a directory, which is followed. Returns "" outside a repository.
+func FindGitDir(dir string) (string, error) {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		info, err := os.Stat(gitPath)
+		if err == nil {
+			if info.IsDir() {
+				return gitPath, nil
+			}
+
+			contents, err := os.ReadFile(gitPath)
+			if err != nil {
+				return "", err
+			}
+			gitDir, found := strings.CutPrefix(strings.TrimSpace(string(contents)), "gitdir: ")
+			if !found {
+				return "", fmt.Errorf("Invalid .git file: %s", gitPath)
+			}
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return gitDir, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// ReadGitSHA returns the commit HEAD points to, reading the refs directly so
+// building doesn't depend on a git binary. Branch refs are looked up as loose
+// files first and then in packed-refs, where git moves them on gc.
+func ReadGitSHA(gitDir string) (string, error) {
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	ref, isSymbolic := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !isSymbolic {
+		// Detached HEAD holds the SHA itself
+		return ref, nil
+	}
+
+	// Worktrees keep their own HEAD but share refs with the main repository
+	commonDir := gitDir
+	if contents, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(contents))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+	}
+
+	for _, refsDir := range []string{gitDir, commonDir} {
+		sha, err := os.ReadFile(filepath.Join(refsDir, filepath.FromSlash(ref)))
+		if err == nil {
+			return strings.TrimSpace(string(sha)), nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	packedRefs, err := os.Open(filepath.Join(commonDir, "packed-refs"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("Git ref %s not found", ref)
+		}
+		return "", err
+	}
+	defer packedRefs.Close()
+
+	// Lines are "<sha> <ref>", with comments and "^<sha>" peeled tag lines
+	// mixed in
+	scanner := bufio.NewScanner(packedRefs)
+	for scanner.Scan() {
+		sha, packedRef, found := strings.Cut(scanner.Text(), " ")
+		if found && packedRef == ref {
+			return sha, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("Git ref %s not found", ref)
+}
@@ -0,0 +1,975 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// BundleOptions configures a one-off production build of every entrypoint
+// into a shared output directory. It's passed over the boundary as JSON.
+type BundleOptions struct {
+	Entrypoints     []string `json:"entrypoints"`
+	Outdir          string   `json:"outdir"`
+	NodeModulesPath string   `json:"nodeModulesPath"`
+	Environment     string   `json:"environment"`
+	// Write a single entrypoint to exactly this path instead of into Outdir,
+	// like "dist/worker.js". Splitting needs a directory to put chunks in,
+	// so it's off, and the naming templates and HashNames don't apply.
+	Outfile string `json:"outfile"`
+	// Split code shared between entrypoints into chunks. On by default for
+	// ESM, the only format that supports it.
+	Splitting *bool `json:"splitting"`
+	// Apply the production preset to any of the pointer options below that
+	// weren't set explicitly. See ApplyProductionPreset.
+	Production bool  `json:"production"`
+	Minify     *bool `json:"minify"`
+	// Minify CSS outputs independently of Minify, which then only applies
+	// to JS. See RestyleCSSOutputs for how this differs from Minify.
+	MinifyCss *bool `json:"minifyCss"`
+	// Strip console.* calls regardless of Environment
+	DropConsole *bool `json:"dropConsole"`
+	// "ascii" escapes every non-ASCII character in strings, identifiers, and
+	// regular expressions, "utf8" keeps them as-is. See ParseCharset. UTF-8
+	// output is much smaller for localized text, but has to be served as
+	// UTF-8, either from a page that is or with a charset in the
+	// Content-Type, or browsers will decode it as Latin-1.
+	Charset string `json:"charset"`
+	// Regular expression for property names to rename, like "_$" for
+	// private-by-convention properties. Off when empty.
+	MangleProps string `json:"mangleProps"`
+	// Property renames from a previous build's BundleResult.MangleCache, so
+	// properties keep their minified names across builds instead of
+	// shifting whenever the code changes. Needs MangleProps.
+	MangleCache map[string]interface{} `json:"mangleCache"`
+	// Include a content hash in entrypoint filenames. Ignored if EntryNames
+	// is set.
+	HashNames *bool `json:"hashNames"`
+	// esbuild template for entrypoint output paths, like "pages/[name]"
+	EntryNames string `json:"entryNames"`
+	// esbuild template for split chunks, including those created for dynamic
+	// import() calls, like "chunks/[name]-[hash]". Dynamic chunks take [name]
+	// from the imported file; shared chunks are always named "chunk". esbuild
+	// doesn't read magic comments like webpackChunkName, so the template is
+	// the only way to influence these names.
+	ChunkNames string `json:"chunkNames"`
+	// esbuild template for assets emitted by the "file" loader, like
+	// "assets/[dir]/[name]-[hash]"
+	AssetNames string `json:"assetNames"`
+	// How to handle entrypoints that would be written to the same output
+	// path, or with EmitHtml the same HTML page: "error" (the default) or
+	// "warn". esbuild still fails the build
+	// itself if the colliding outputs end up with different contents.
+	OutputCollisions string `json:"outputCollisions"`
+	// Force tree-shaking on or off. esbuild enables it by default when
+	// bundling, so this is mostly useful to disable it while debugging.
+	TreeShaking *bool `json:"treeShaking"`
+	// Write a NOTICES.txt of bundled third-party licenses to Outdir
+	EmitNotices bool `json:"emitNotices"`
+	// After writing, delete files in Outdir left over from earlier builds
+	// under a different content hash. See RemoveStaleOutputs.
+	CleanStale bool `json:"cleanStale"`
+	// Strip console.* calls, but only when Environment is "production" so
+	// the same options can be shared with development builds
+	DropConsoleInProd bool `json:"dropConsoleInProd"`
+	// Path to a tsconfig.json that overrides the per-directory lookup
+	Tsconfig string `json:"tsconfig"`
+	// compilerOptions.paths entries to merge over the tsconfig's, like
+	// {"@api/*": ["./mocks/api/*"]}. Applies to Tsconfig, or the working
+	// directory's tsconfig.json if that's unset. See TsconfigWithPaths.
+	TsconfigPaths map[string][]string `json:"tsconfigPaths"`
+	// Directory that relative entrypoints, the outdir, and the metafile's
+	// paths resolve against. Defaults to the tsconfig's directory when one is
+	// given so "extends" chains and a relative "baseUrl" resolve, otherwise
+	// the current working directory.
+	AbsWorkingDir string `json:"absWorkingDir"`
+	// Leave the original sources out of the emitted sourcemaps. This roughly
+	// halves their size and avoids publishing source in production.
+	ExcludeSourcesContent *bool `json:"excludeSourcesContent"`
+	// Ignore /* @__PURE__ */ comments and package.json "sideEffects" when
+	// tree-shaking. Use this when a dependency annotates code that actually
+	// has side effects; bundles get larger since less code can be dropped.
+	IgnoreAnnotations bool `json:"ignoreAnnotations"`
+	// [from, to] pairs that rewrite imports of one module to another, like
+	// ["lodash", "lodash-es"]
+	Aliases [][2]string `json:"aliases"`
+	// Loader for plain .svg imports, "file" by default so they resolve to a
+	// URL. Importing with a "?react" suffix always produces a component.
+	SvgLoader string `json:"svgLoader"`
+	// How to load .wasm imports: "file", "binary", or "copy". See
+	// ParseWasmLoader. Unset, .wasm has no loader, like any other unknown
+	// extension.
+	WasmLoader string `json:"wasmLoader"`
+	// Project esbuild.config.json to merge beneath these options
+	ConfigFile string `json:"configFile"`
+	// Extension to loader name, like {".png": "file"}. Extensions can span
+	// several dots, so {".worker.js": "copy"} emits pre-built worker scripts
+	// verbatim under a hashed name while other .js files are still bundled.
+	Loaders map[string]string `json:"loaders"`
+	// Loader name for extensions without a loader, like "text" or "copy".
+	// Unset, importing one fails the build. See DefaultLoaderPlugin.
+	DefaultLoader string `json:"defaultLoader"`
+	// Extra defines, merged over the built-in NODE_ENV and SSR_RENDERING.
+	// Values can reference other defines as ${NAME}, see ExpandDefines.
+	Defines map[string]string `json:"defines"`
+	// Modules to leave as imports instead of bundling
+	Externals []string `json:"externals"`
+	// Packages to split into chunks of their own, apart from app code, so
+	// they stay cached across app changes, like ["react", "react-dom"]. Needs
+	// code splitting. See VendorEntrypoint for how close this gets to a
+	// real vendor bundle.
+	VendorPackages []string `json:"vendorPackages"`
+	// Modules to replace with an empty module, like dev-only tooling in a
+	// production build. See EmptyModulesPlugin.
+	EmptyModules []string `json:"emptyModules"`
+	// esbuild target string, like "es2020" or "chrome100,safari15"
+	Target string `json:"target"`
+	// Derive Target from the project's browserslist config instead. This
+	// replaces any target in ConfigFile. See LoadBrowserslist for where it's
+	// looked up and BrowserslistTarget for which queries are understood.
+	UseBrowserslist bool `json:"useBrowserslist"`
+	// Browserslist config to read, a .browserslistrc or package.json,
+	// relative to the working directory. Found automatically if empty.
+	BrowserslistPath string `json:"browserslistPath"`
+	// esbuild syntax features to mark as supported or not regardless of
+	// Target, like {"top-level-await": false} for a runtime that lacks it
+	Supported map[string]bool `json:"supported"`
+	// Inject polyfills for runtime APIs, like structuredClone, that some
+	// engine in Target lacks. See NeededPolyfills.
+	Polyfills bool `json:"polyfills"`
+	// Compile-time booleans, injected as defines. Setting any flag also turns
+	// on MinifySyntax, which is what removes the branches they disable.
+	FeatureFlags map[string]bool `json:"featureFlags"`
+	// Report import cycles between inputs. See FindImportCycles.
+	DetectCycles bool `json:"detectCycles"`
+	// Warn about ESM imports of CommonJS dependencies. See FindInteropRisks.
+	CheckInterop bool `json:"checkInterop"`
+	// esbuild message ID to log level, like {"this-is-undefined-in-esm":
+	// "silent"}. Silenced warnings are left out of the result; raising one
+	// to "error" fails the build.
+	LogOverrides map[string]string `json:"logOverrides"`
+	// Include a manifest of entrypoint outputs in the result, for pairing
+	// library builds with a separate declaration step. See BuildManifest.
+	EmitManifest bool `json:"emitManifest"`
+	// Add a sha384 Subresource Integrity value for every output to the
+	// manifest. Needs EmitManifest.
+	Integrity bool `json:"integrity"`
+	// Write precache-manifest.json to the outdir, listing every output with
+	// a content revision for a service worker to precache. See
+	// PrecacheManifest.
+	EmitPrecacheManifest bool `json:"emitPrecacheManifest"`
+	// Fail the build if any output (other than sourcemaps) is larger than
+	// this many bytes. 0 means no limit.
+	MaxOutputBytes int `json:"maxOutputBytes"`
+	// Per-entrypoint byte budgets, keyed by entrypoint path, that override
+	// MaxOutputBytes for that entrypoint's output
+	EntryBudgets map[string]int `json:"entryBudgets"`
+	// Measure budgets against gzipped sizes instead of raw sizes
+	BudgetGzip bool `json:"budgetGzip"`
+	// Most outputs written or gzipped for budgets at once. GOMAXPROCS if 0.
+	IOConcurrency int `json:"ioConcurrency"`
+	// JSX mode, "transform" (the default) or "automatic", and the package
+	// providing jsx-runtime for the automatic mode ("react" if empty)
+	JSX             string `json:"jsx"`
+	JSXImportSource string `json:"jsxImportSource"`
+	// Use the automatic runtime's development build, which records each
+	// element's source location for React's warnings. See ContextSpec.JSXDev.
+	JSXDev bool `json:"jsxDev"`
+	// Treat JSX elements as having side effects, so tree-shaking keeps ones
+	// whose result is unused. By default esbuild marks them pure, which
+	// drops elements rendered only for what their component does.
+	JSXSideEffects bool `json:"jsxSideEffects"`
+	// Format build errors with esbuild's colorized terminal output, see
+	// FormatColorBuildErrors, instead of plain text
+	ColorErrors bool `json:"colorErrors"`
+	// Most errors to format when the build fails, followed by a count of
+	// the rest. 0 formats every error.
+	MaxErrors int `json:"maxErrors"`
+	// Report metafile and manifest paths as absolute paths instead of
+	// relative to the working directory
+	AbsMetafilePaths bool `json:"absMetafilePaths"`
+	// Packages to load through BundleAllWithLoader's callback instead of
+	// from disk, and the namespace they're loaded into
+	VirtualModules   []string `json:"virtualModules"`
+	VirtualNamespace string   `json:"virtualNamespace"`
+	// Extra plugins from the Go side, like the virtual module loader
+	Plugins []api.Plugin `json:"-"`
+	// Entrypoints built from contents in memory, set by
+	// BundleAllWithEntrypoints
+	VirtualEntrypoints []VirtualEntrypoint `json:"-"`
+	// Post-processing step for CSS outputs, set by BundleAllWithCSSProcessor
+	CSSProcessor CSSProcessor `json:"-"`
+	// Metafile of the previous build, set by BundleAllIncremental, to only
+	// rebuild the entrypoints whose inputs changed since. See
+	// PlanIncrementalBuild.
+	PriorMetafile string `json:"-"`
+	// Output format: "esm" (the default), "cjs", or "iife". Code splitting
+	// is only supported for ESM, so the other formats emit one file per
+	// entrypoint. CJS also targets node, so require() and __dirname are left
+	// as-is for the runtime.
+	Format string `json:"format"`
+	// Global an iife bundle assigns its exports to, like "AcmeChat" or
+	// "Acme.chat", for drop-in <script> widgets. See ParseGlobalName.
+	GlobalName string `json:"globalName"`
+	// Exported function, like "init", that an iife bundle calls through
+	// GlobalName as soon as it loads, so the <script> tag alone starts the
+	// widget. Needs GlobalName.
+	AutoInit string `json:"autoInit"`
+	// Text to put at the start and end of each output, keyed by output type,
+	// like {"js": "/* (c) Acme */"}. Line endings are normalized to LF, see
+	// ParseBannerFooter.
+	Banner map[string]string `json:"banner"`
+	Footer map[string]string `json:"footer"`
+	// Define process.env.BUILD_TIME as the time of the build in RFC 3339
+	DefineBuildTime bool `json:"defineBuildTime"`
+	// Define process.env.GIT_SHA as the commit checked out in the working
+	// directory's repository. Outside a repository it's left undefined with
+	// a warning. Either define can still be overridden through Defines.
+	DefineGitSHA bool `json:"defineGitSha"`
+	// Write an HTML page per entrypoint that loads its outputs. See
+	// RenderEntryHTML.
+	EmitHtml bool `json:"emitHtml"`
+	// Page to inject the tags into, the minimal default if empty
+	HtmlTemplate string `json:"htmlTemplate"`
+	// Scripts to inline into each HTML page ahead of the entry's own script.
+	// Their CSP hashes are returned as ScriptHashes.
+	InlineScripts []string `json:"inlineScripts"`
+	// Prefix devtools put in front of each sourcemap source, for apps served
+	// under a subpath. See ValidateSourceRoot.
+	SourceRoot string `json:"sourceRoot"`
+	// How to handle "node:" imports: "external" or "empty", see
+	// NodeBuiltinsPlugin. Unset, esbuild resolves them as usual, which fails
+	// for the browser platform.
+	NodeBuiltins string `json:"nodeBuiltins"`
+}
+
+// BundleResult is serialized back to the host once the build succeeds.
+type BundleResult struct {
+	Outputs  []string        `json:"outputs"`
+	Metafile json.RawMessage `json:"metafile"`
+	Notices  string          `json:"notices,omitempty"`
+	// Path of the precache manifest, when EmitPrecacheManifest is set
+	PrecacheManifest string `json:"precacheManifest,omitempty"`
+	// Hash of every output's path and contents, for cache-busting the app
+	// shell as a whole
+	BuildHash string   `json:"buildHash"`
+	Warnings  []string `json:"warnings"`
+	// Only populated when DetectCycles is set
+	Cycles [][]string `json:"cycles,omitempty"`
+	// Only populated when EmitManifest is set
+	Manifest *BuildManifest `json:"manifest,omitempty"`
+	// CSP hash sources for InlineScripts, in the same order
+	ScriptHashes []string `json:"scriptHashes,omitempty"`
+	// Names of the polyfills injected for the target, when Polyfills is set
+	Polyfills []string `json:"polyfills,omitempty"`
+	// Stale outputs deleted from the outdir, when CleanStale is set
+	Removed []string `json:"removed,omitempty"`
+	// Entrypoints whose outputs were carried over from the previous build
+	// instead of rebuilt, for incremental builds
+	Reused []string `json:"reused,omitempty"`
+	// Every property rename so far, including MangleCache's, when
+	// MangleProps is set. Persist it and pass it back as MangleCache.
+	MangleCache map[string]interface{} `json:"mangleCache,omitempty"`
+	Timing      BuildTiming            `json:"timing"`
+}
+
+// BuildTiming splits a build's wall time, in milliseconds, so a slow build
+// can be told apart from a slow disk.
+type BuildTiming struct {
+	// From receiving the options to returning the result
+	TotalMs float64 `json:"totalMs"`
+	// Inside esbuild: resolving, parsing, linking, and printing
+	BuildMs float64 `json:"buildMs"`
+	// Writing the outputs, to the outdir or BundleAllToZip's archive
+	WriteMs float64 `json:"writeMs"`
+	// Most outputs that were being written or compressed at once, never
+	// more than IOConcurrency
+	IOWorkers int `json:"ioWorkers"`
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+//export BundleAll
+func BundleAll(rawOptions *C.char) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Unlike the build contexts, BundleAll builds all client entrypoints in a
+	 * single pass so shared dependencies can be split into common chunks.
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+
+	result, err := bundleAll(options, writeBundleOutputs)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+// ApplyProductionPreset fills in production defaults when options.Production
+// is set. Each of these only applies if the option wasn't given explicitly,
+// so individual overrides always win:
+//   - minify: true (whitespace, identifiers, and syntax)
+//   - dropConsole: true
+//   - excludeSourcesContent: true
+//   - hashNames: true
+//   - treeShaking: true
+//   - charset: "utf8"
+func ApplyProductionPreset(options BundleOptions) BundleOptions {
+	if !options.Production {
+		return options
+	}
+
+	enabled := true
+	for _, option := range []**bool{
+		&options.Minify,
+		&options.DropConsole,
+		&options.ExcludeSourcesContent,
+		&options.HashNames,
+		&options.TreeShaking,
+	} {
+		if *option == nil {
+			*option = &enabled
+		}
+	}
+	if options.Charset == "" {
+		options.Charset = "utf8"
+	}
+	return options
+}
+
+func isEnabled(option *bool) bool {
+	return option != nil && *option
+}
+
+// OutputEmitter receives the build's output files once esbuild succeeds,
+// along with the absolute output directory they were built for and the pool
+// bounding the build's I/O. The default, writeBundleOutputs, writes them to
+// disk in parallel.
+type OutputEmitter func(outputFiles []api.OutputFile, outdir string, pool *ioPool) error
+
+func writeBundleOutputs(outputFiles []api.OutputFile, outdir string, pool *ioPool) error {
+	return pool.each(len(outputFiles), func(index int) error {
+		outputFile := outputFiles[index]
+		// Split chunks and assets can be nested below the output directory
+		if err := os.MkdirAll(filepath.Dir(outputFile.Path), 0755); err != nil {
+			return err
+		}
+		return writeFileAtomic(outputFile.Path, outputFile.Contents)
+	})
+}
+
+func bundleAll(options BundleOptions, emit OutputEmitter) (BundleResult, error) {
+	start := time.Now()
+	options, warnings, err := ResolveBundleOptions(options)
+	if err != nil {
+		return BundleResult{}, err
+	}
+
+	if problems := ValidateBundleOptions(options); len(problems) > 0 {
+		return BundleResult{}, fmt.Errorf("%s", strings.Join(problems, "\n"))
+	}
+	pool := newIOPool(options.IOConcurrency)
+
+	buildOptions := api.BuildOptions{
+		EntryPoints: options.Entrypoints,
+		Bundle:      true,
+		Outdir:      options.Outdir,
+		Format:      api.FormatESModule,
+		Splitting:   true,
+		Sourcemap:   api.SourceMapExternal,
+		Metafile:    true,
+		Loader: map[string]api.Loader{
+			".tsx": api.LoaderTSX,
+			".jsx": api.LoaderJSX,
+			".mjs": api.LoaderJS,
+			".cjs": api.LoaderJS,
+		},
+		ResolveExtensions: resolveExtensions,
+		Define: map[string]string{
+			"process.env.NODE_ENV":      fmt.Sprintf("\"%s\"", options.Environment),
+			"process.env.SSR_RENDERING": "false",
+		},
+		NodePaths:         []string{options.NodeModulesPath},
+		MinifyWhitespace:  isEnabled(options.Minify),
+		MinifyIdentifiers: isEnabled(options.Minify),
+		MinifySyntax:      isEnabled(options.Minify),
+		IgnoreAnnotations: options.IgnoreAnnotations,
+	}
+
+	if options.Outfile != "" {
+		buildOptions.Outdir = ""
+		buildOptions.Outfile = options.Outfile
+	} else if options.EntryNames != "" {
+		buildOptions.EntryNames = options.EntryNames
+	} else if isEnabled(options.HashNames) {
+		buildOptions.EntryNames = "[dir]/[name]-[hash]"
+	}
+
+	buildOptions.ChunkNames = options.ChunkNames
+	buildOptions.AssetNames = options.AssetNames
+
+	if options.TreeShaking != nil {
+		if *options.TreeShaking {
+			buildOptions.TreeShaking = api.TreeShakingTrue
+		} else {
+			buildOptions.TreeShaking = api.TreeShakingFalse
+		}
+	}
+
+	// Defines can reference the built-in ones too, like ${process.env.NODE_ENV}
+	defines, err := ExpandDefines(options.Defines, buildOptions.Define)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	for key, value := range defines {
+		buildOptions.Define[key] = value
+	}
+	buildOptions.External = options.Externals
+
+	if len(options.FeatureFlags) > 0 {
+		for flag, enabled := range options.FeatureFlags {
+			buildOptions.Define[flag] = fmt.Sprintf("%t", enabled)
+		}
+		buildOptions.MinifySyntax = true
+		if options.TreeShaking != nil && !*options.TreeShaking {
+			warnings = append(warnings, "Feature flags are set but treeShaking is disabled, so code only used by disabled branches will still be bundled")
+		}
+	}
+
+	format, err := ParseFormat(options.Format)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.Format = format
+	if format != api.FormatESModule || options.Outfile != "" || (options.Splitting != nil && !*options.Splitting) {
+		buildOptions.Splitting = false
+	}
+	if format == api.FormatCommonJS {
+		buildOptions.Platform = api.PlatformNode
+	}
+	buildOptions.GlobalName = options.GlobalName
+	buildOptions.Banner, err = ParseBannerFooter("banner", options.Banner)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.Footer, err = ParseBannerFooter("footer", options.Footer)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	if options.AutoInit != "" {
+		// After any footer of the host's, so the call runs last
+		autoInit := fmt.Sprintf("%s.%s();", options.GlobalName, options.AutoInit)
+		if buildOptions.Footer == nil {
+			buildOptions.Footer = map[string]string{}
+		}
+		if footer := buildOptions.Footer["js"]; footer != "" {
+			autoInit = footer + "\n" + autoInit
+		}
+		buildOptions.Footer["js"] = autoInit
+	}
+
+	jsx, err := ParseJSX(options.JSX)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.JSX = jsx
+	buildOptions.JSXImportSource = options.JSXImportSource
+	buildOptions.JSXDev = options.JSXDev
+	buildOptions.JSXSideEffects = options.JSXSideEffects
+
+	logOverrides, err := ParseLogOverrides(options.LogOverrides)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.LogOverride = logOverrides
+
+	target, engines, err := ParseTarget(options.Target)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.Target = target
+	buildOptions.Engines = engines
+	buildOptions.Supported = options.Supported
+	buildOptions.MangleProps = options.MangleProps
+	buildOptions.MangleCache = options.MangleCache
+	if options.MangleProps != "" && buildOptions.MangleCache == nil {
+		// esbuild only reports the renames when given a cache to update
+		buildOptions.MangleCache = map[string]interface{}{}
+	}
+
+	charset, err := ParseCharset(options.Charset)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.Charset = charset
+
+	polyfillNames := []string{}
+	if options.Polyfills {
+		polyfillNames = NeededPolyfills(target, engines)
+		for _, name := range polyfillNames {
+			buildOptions.Inject = append(buildOptions.Inject, "polyfill:"+name)
+		}
+	}
+
+	svgLoaderName := options.SvgLoader
+	if svgLoaderName == "" {
+		svgLoaderName = "file"
+	}
+	svgLoader, err := ParseLoader(svgLoaderName)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.Loader[".svg"] = svgLoader
+	if options.WasmLoader != "" {
+		wasmLoader, err := ParseWasmLoader(options.WasmLoader)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.Loader[".wasm"] = wasmLoader
+	}
+	for extension, loaderName := range options.Loaders {
+		loader, err := ParseLoader(loaderName)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.Loader[extension] = loader
+	}
+	// Runs first so stripped modules are never resolved any other way
+	if len(options.EmptyModules) > 0 {
+		buildOptions.Plugins = append(buildOptions.Plugins, EmptyModulesPlugin(options.EmptyModules))
+	}
+	buildOptions.Plugins = append(buildOptions.Plugins, SvgComponentPlugin(), ImportAttributesPlugin())
+	if options.DefaultLoader != "" {
+		defaultLoader, err := ParseLoader(options.DefaultLoader)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.Plugins = append(buildOptions.Plugins, DefaultLoaderPlugin(defaultLoader, buildOptions.Loader))
+	}
+	if options.NodeBuiltins != "" {
+		nodeBuiltinsPlugin, err := NodeBuiltinsPlugin(options.NodeBuiltins)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.Plugins = append(buildOptions.Plugins, nodeBuiltinsPlugin)
+	}
+	if len(polyfillNames) > 0 {
+		buildOptions.Plugins = append(buildOptions.Plugins, PolyfillsPlugin())
+	}
+	buildOptions.Plugins = append(buildOptions.Plugins, options.Plugins...)
+
+	aliases, err := ValidateAliases(options.Aliases)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.Alias = aliases
+
+	if options.Tsconfig != "" {
+		tsconfigPath, err := filepath.Abs(options.Tsconfig)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.Tsconfig = tsconfigPath
+	}
+
+	workingDir, err := resolveWorkingDir(options.AbsWorkingDir, buildOptions.Tsconfig)
+	if err != nil {
+		return BundleResult{}, err
+	}
+	buildOptions.AbsWorkingDir = workingDir
+
+	if len(options.VendorPackages) > 0 {
+		options.VirtualEntrypoints = append(options.VirtualEntrypoints, VendorEntrypoint(options.VendorPackages))
+	}
+	if len(options.VirtualEntrypoints) > 0 {
+		buildOptions.EntryPointsAdvanced = virtualEntryPoints(options.VirtualEntrypoints)
+		buildOptions.Plugins = append(buildOptions.Plugins, VirtualEntrypointsPlugin(options.VirtualEntrypoints, workingDir))
+	}
+
+	if len(options.TsconfigPaths) > 0 {
+		tsconfigPath := buildOptions.Tsconfig
+		if tsconfigPath == "" {
+			if _, err := os.Stat(filepath.Join(workingDir, "tsconfig.json")); err == nil {
+				tsconfigPath = filepath.Join(workingDir, "tsconfig.json")
+			}
+		}
+		tsconfigRaw, err := TsconfigWithPaths(tsconfigPath, workingDir, options.TsconfigPaths)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		// esbuild takes one or the other, and the raw config extends the file
+		buildOptions.Tsconfig = ""
+		buildOptions.TsconfigRaw = tsconfigRaw
+	}
+
+	for _, entrypoint := range options.Entrypoints {
+		if err := checkEntrypointExists(entrypoint, workingDir); err != nil {
+			return BundleResult{}, err
+		}
+	}
+	for _, entrypoint := range options.VirtualEntrypoints {
+		if err := checkResolveDirExists(entrypoint, workingDir); err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	buildInfo := map[string]string{}
+	if options.DefineBuildTime {
+		buildInfo["process.env.BUILD_TIME"] = fmt.Sprintf("\"%s\"", time.Now().UTC().Format(time.RFC3339))
+	}
+	if options.DefineGitSHA {
+		gitDir, err := FindGitDir(workingDir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		if gitDir == "" {
+			warnings = append(warnings, fmt.Sprintf("No git repository found at %s, so process.env.GIT_SHA is left undefined", workingDir))
+		} else {
+			sha, err := ReadGitSHA(gitDir)
+			if err != nil {
+				return BundleResult{}, err
+			}
+			buildInfo["process.env.GIT_SHA"] = fmt.Sprintf("\"%s\"", sha)
+		}
+	}
+	for key, value := range buildInfo {
+		if _, exists := options.Defines[key]; !exists {
+			buildOptions.Define[key] = value
+		}
+	}
+
+	// esbuild resolves a relative outdir against the working directory too.
+	// An outfile's directory stands in for it.
+	outdir := options.Outdir
+	if options.Outfile != "" {
+		outdir = filepath.Dir(options.Outfile)
+	}
+	if !filepath.IsAbs(outdir) {
+		outdir = filepath.Join(workingDir, outdir)
+	}
+
+	var incremental IncrementalPlan
+	if options.PriorMetafile != "" {
+		incremental, err = PlanIncrementalBuild(options.PriorMetafile, options.Entrypoints, workingDir, outdir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.EntryPoints = incremental.Rebuild
+	}
+
+	collisions := FindOutputCollisions(options.Entrypoints, workingDir, buildOptions.EntryNames)
+	if options.EmitHtml {
+		for pagePath, claimants := range FindHTMLPageCollisions(options.Entrypoints, workingDir, buildOptions.EntryNames) {
+			collisions[pagePath] = claimants
+		}
+	}
+	if len(collisions) > 0 {
+		messages := FormatOutputCollisions(collisions)
+		switch options.OutputCollisions {
+		case "", "error":
+			return BundleResult{}, fmt.Errorf("Output path collision:\n%s", strings.Join(messages, "\n"))
+		case "warn":
+			warnings = append(warnings, messages...)
+		default:
+			return BundleResult{}, fmt.Errorf("Invalid outputCollisions %q: expected \"error\" or \"warn\"", options.OutputCollisions)
+		}
+	}
+
+	htmlTemplate := ""
+	if options.EmitHtml {
+		htmlTemplate, err = readHTMLTemplate(options.HtmlTemplate)
+		if err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	if options.SourceRoot != "" {
+		if err := ValidateSourceRoot(options.SourceRoot); err != nil {
+			return BundleResult{}, err
+		}
+		buildOptions.SourceRoot = options.SourceRoot
+	}
+
+	if isEnabled(options.ExcludeSourcesContent) {
+		buildOptions.SourcesContent = api.SourcesContentExclude
+	}
+
+	if isEnabled(options.DropConsole) || (options.DropConsoleInProd && options.Environment == "production") {
+		buildOptions.Drop |= api.DropConsole
+	}
+
+	buildStart := time.Now()
+	result := api.BuildResult{Metafile: `{"inputs":{},"outputs":{}}`}
+	// Nothing to build when every entrypoint is reused
+	if options.PriorMetafile == "" || len(incremental.Rebuild) > 0 {
+		result = api.Build(buildOptions)
+	}
+	buildDuration := time.Since(buildStart)
+	if len(result.Errors) > 0 {
+		errors, omitted := TruncateBuildErrors(result.Errors, options.MaxErrors)
+		errors = ExplainMissingPeerErrors(ExplainTopLevelAwaitErrors(errors), workingDir)
+		if options.ColorErrors {
+			return BundleResult{}, fmt.Errorf("%s%s", FormatColorBuildErrors("Error bundling:\n\n", errors), omittedErrorsNote(omitted))
+		}
+		return BundleResult{}, fmt.Errorf("%s%s", FormatBuildErrors("Error bundling:\n\n", errors), omittedErrorsNote(omitted))
+	}
+	warnings = append(warnings, FormatBuildWarnings(result.Warnings)...)
+
+	// Processed first, so restyling applies to what the processor generated
+	if options.CSSProcessor != nil {
+		if err := ProcessCSSOutputs(result.OutputFiles, options.CSSProcessor); err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	if options.MinifyCss != nil && *options.MinifyCss != isEnabled(options.Minify) {
+		if err := RestyleCSSOutputs(result.OutputFiles, *options.MinifyCss, buildOptions.Charset, buildOptions.SourcesContent, buildOptions.SourceRoot); err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	// Added after processing, since the reused outputs already were
+	if options.PriorMetafile != "" {
+		reusedOutputs, err := incremental.ReadOutputs(workingDir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		result.OutputFiles = append(result.OutputFiles, reusedOutputs...)
+		result.Metafile, err = incremental.MergeMetafile(result.Metafile)
+		if err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	metafile, err := ParseMetafile(result.Metafile)
+	if err != nil {
+		return BundleResult{}, err
+	}
+
+	// Check budgets before anything is written, so an oversized build never
+	// replaces the previous one
+	if options.MaxOutputBytes > 0 || len(options.EntryBudgets) > 0 {
+		overruns, err := FindBudgetOverruns(result.OutputFiles, metafile, workingDir, options.MaxOutputBytes, options.EntryBudgets, options.BudgetGzip, pool)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		if len(overruns) > 0 {
+			return BundleResult{}, fmt.Errorf("Output size budget exceeded:\n%s", strings.Join(overruns, "\n"))
+		}
+	}
+
+	if options.EmitHtml {
+		pages, err := RenderEntryHTML(BuildManifestFromMetafile(metafile, buildOptions.Loader), options.Entrypoints, workingDir, htmlTemplate, format == api.FormatESModule, options.InlineScripts)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		result.OutputFiles = append(result.OutputFiles, pages...)
+	}
+
+	// Last, so it covers the HTML pages too
+	var precacheManifest api.OutputFile
+	if options.EmitPrecacheManifest {
+		precacheManifest, err = PrecacheManifest(result.OutputFiles, outdir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		result.OutputFiles = append(result.OutputFiles, precacheManifest)
+	}
+
+	writeStart := time.Now()
+	if err := emit(result.OutputFiles, outdir, pool); err != nil {
+		return BundleResult{}, err
+	}
+	writeDuration := time.Since(writeStart)
+
+	// Only once the new outputs are in place, so a failed build leaves the
+	// previous one intact
+	removed := []string{}
+	if options.CleanStale {
+		removed, err = RemoveStaleOutputs(result.OutputFiles, outdir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	outputs := make([]string, 0, len(result.OutputFiles))
+	for _, outputFile := range result.OutputFiles {
+		outputs = append(outputs, outputFile.Path)
+	}
+
+	buildHash, err := HashOutputFiles(result.OutputFiles, outdir)
+	if err != nil {
+		return BundleResult{}, err
+	}
+
+	bundleResult := BundleResult{
+		Outputs:   outputs,
+		Metafile:  json.RawMessage(result.Metafile),
+		BuildHash: buildHash,
+		Warnings:  warnings,
+	}
+
+	if len(polyfillNames) > 0 {
+		bundleResult.Polyfills = polyfillNames
+	}
+
+	if len(removed) > 0 {
+		bundleResult.Removed = removed
+	}
+
+	if len(incremental.Reuse) > 0 {
+		bundleResult.Reused = incremental.Reuse
+	}
+
+	if options.EmitPrecacheManifest {
+		bundleResult.PrecacheManifest = precacheManifest.Path
+	}
+
+	if options.MangleProps != "" {
+		bundleResult.MangleCache = result.MangleCache
+	}
+
+	if options.EmitHtml && len(options.InlineScripts) > 0 {
+		bundleResult.ScriptHashes = ScriptHashes(options.InlineScripts)
+	}
+
+	if options.DetectCycles {
+		bundleResult.Cycles = FindImportCycles(metafile)
+	}
+
+	if options.EmitManifest {
+		manifest := BuildManifestFromMetafile(metafile, buildOptions.Loader)
+		if len(options.VendorPackages) > 0 {
+			manifest.Vendor = VendorOutputs(metafile, options.VendorPackages)
+		}
+		if options.Integrity {
+			manifest.Integrity, err = IntegrityDigests(result.OutputFiles, workingDir)
+			if err != nil {
+				return BundleResult{}, err
+			}
+		}
+		if options.AbsMetafilePaths {
+			manifest.Absolutize(workingDir)
+		}
+		bundleResult.Manifest = &manifest
+	}
+
+	if options.AbsMetafilePaths {
+		bundleResult.Metafile, err = AbsolutizeMetafile(result.Metafile, workingDir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+	}
+
+	if options.CheckInterop {
+		bundleResult.Warnings = append(bundleResult.Warnings, FindInteropRisks(metafile, workingDir)...)
+	}
+
+	if options.EmitNotices {
+		noticesPath, err := WriteNotices(metafile, workingDir, outdir)
+		if err != nil {
+			return BundleResult{}, err
+		}
+		bundleResult.Notices = noticesPath
+	}
+
+	bundleResult.Timing = BuildTiming{
+		TotalMs:   milliseconds(time.Since(start)),
+		BuildMs:   milliseconds(buildDuration),
+		WriteMs:   milliseconds(writeDuration),
+		IOWorkers: int(pool.peak.Load()),
+	}
+	return bundleResult, nil
+}
+
+// FormatBuildWarnings renders esbuild's warnings one per line, in the same
+// "file:line:column: text [id]" shape esbuild's own CLI uses.
+func FormatBuildWarnings(messages []api.Message) []string {
+	formatted := make([]string, 0, len(messages))
+	for _, message := range messages {
+		text := message.Text
+		if message.ID != "" {
+			text += fmt.Sprintf(" [%s]", message.ID)
+		}
+		if message.Location != nil {
+			location := message.Location
+			text = fmt.Sprintf("%s:%d:%d: %s", location.File, location.Line, location.Column+1, text)
+		}
+		formatted = append(formatted, text)
+	}
+	return formatted
+}
+
+func resolveWorkingDir(absWorkingDir string, tsconfigPath string) (string, error) {
+	if absWorkingDir != "" {
+		return filepath.Abs(absWorkingDir)
+	}
+	if tsconfigPath != "" {
+		return filepath.Dir(tsconfigPath), nil
+	}
+	return os.Getwd()
+}
+
+// checkEntrypointExists fails early for a missing entrypoint, since esbuild
+// only reports it as a generic resolve error.
+func checkEntrypointExists(entrypoint string, workingDir string) error {
+	entrypointPath := entrypoint
+	if !filepath.IsAbs(entrypointPath) {
+		entrypointPath = filepath.Join(workingDir, entrypointPath)
+	}
+	if _, err := os.Stat(entrypointPath); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Entrypoint not found: %s", entrypoint)
+		}
+		return err
+	}
+	return nil
+}
+
+// HashOutputFiles returns a stable FNV-1a hash over the output files, sorted
+// by their path relative to outdir so the hash doesn't depend on where the
+// build was written.
+func HashOutputFiles(outputFiles []api.OutputFile, outdir string) (string, error) {
+	hash := fnv.New64a()
+	for _, outputFile := range SortOutputFiles(outputFiles) {
+		relativePath, err := filepath.Rel(outdir, outputFile.Path)
+		if err != nil {
+			return "", err
+		}
+		// Separate fields with a NUL so adjacent paths and contents can't
+		// run together into the same byte stream
+		hash.Write([]byte(filepath.ToSlash(relativePath)))
+		hash.Write([]byte{0})
+		hash.Write(outputFile.Contents)
+		hash.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", hash.Sum64()), nil
+}
+
+// SortOutputFiles returns a copy of the output files ordered by path.
+func SortOutputFiles(outputFiles []api.OutputFile) []api.OutputFile {
+	sortedFiles := make([]api.OutputFile, len(outputFiles))
+	copy(sortedFiles, outputFiles)
+	sort.Slice(sortedFiles, func(i, j int) bool {
+		return sortedFiles[i].Path < sortedFiles[j].Path
+	})
+	return sortedFiles
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// OutputChanges lists which output paths differ from the previous build,
+// so a host can push just those files to the browser.
+type OutputChanges struct {
+	Added   []string `json:"added"`
+	Changed []string `json:"changed"`
+	Removed []string `json:"removed"`
+}
+
+func HashOutputContents(outputFiles []api.OutputFile) map[string]uint64 {
+	hashes := make(map[string]uint64, len(outputFiles))
+	for _, outputFile := range outputFiles {
+		hash := fnv.New64a()
+		hash.Write(outputFile.Contents)
+		hashes[outputFile.Path] = hash.Sum64()
+	}
+	return hashes
+}
+
+func DiffOutputHashes(previous map[string]uint64, current map[string]uint64) OutputChanges {
+	changes := OutputChanges{
+		Added:   []string{},
+		Changed: []string{},
+		Removed: []string{},
+	}
+
+	for path, hash := range current {
+		previousHash, exists := previous[path]
+		if !exists {
+			changes.Added = append(changes.Added, path)
+		} else if previousHash != hash {
+			changes.Changed = append(changes.Changed, path)
+		}
+	}
+	for path := range previous {
+		if _, exists := current[path]; !exists {
+			changes.Removed = append(changes.Removed, path)
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Changed)
+	sort.Strings(changes.Removed)
+	return changes
+}
+
+//export GetContextOutputChanges
+func GetContextOutputChanges(id C.int) (returnChanges *C.char, returnError *C.char) {
+	/*
+	 * Returns the output changes made by the context's most recent successful
+	 * rebuild as JSON. On the first rebuild every output counts as added.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	changes := context.OutputChanges
+	if context.OutputHashes == nil {
+		// Nothing has been built yet
+		changes = DiffOutputHashes(nil, nil)
+	}
+
+	payload, err := json.Marshal(changes)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+import "C"
+
+// How long a rebuild waits before starting, so requests from a burst of
+// saves can join it. Stored in nanoseconds; 0 starts right away.
+var rebuildDebounce atomic.Int64
+
+// rebuildRequest is a pending rebuild shared by every request that joined it.
+// done is closed once it has run, after err is set.
+type rebuildRequest struct {
+	done chan struct{}
+	err  error
+}
+
+// requestRebuild rebuilds the context, coalescing concurrent requests. A
+// request queues a rebuild, which waits out the debounce and any rebuild
+// already running before it starts. Requests arriving while it's queued
+// share it instead of queuing their own, so a burst runs at most one
+// rebuild after the one in flight; since that rebuild starts after they
+// arrive, it still sees their changes. Returns whether the request joined
+// one queued by another, and the shared rebuild's error.
+func (context *ESBuildContext) requestRebuild() (bool, error) {
+	context.queueLock.Lock()
+	if request := context.queued; request != nil {
+		context.queueLock.Unlock()
+		<-request.done
+		return true, request.err
+	}
+	request := &rebuildRequest{done: make(chan struct{})}
+	context.queued = request
+	context.queueLock.Unlock()
+
+	if debounce := time.Duration(rebuildDebounce.Load()); debounce > 0 {
+		time.Sleep(debounce)
+	}
+
+	context.lock.Lock()
+	// Stop taking requests once this starts, since changes from here on
+	// may be missed
+	context.queueLock.Lock()
+	context.queued = nil
+	context.queueLock.Unlock()
+	request.err = context.rebuildLocked()
+	context.lock.Unlock()
+
+	close(request.done)
+	return false, request.err
+}
+
+//export SetRebuildDebounce
+func SetRebuildDebounce(debounceMs C.int) {
+	/*
+	 * Delays each rebuild requested through RebuildContext or
+	 * RebuildContextCoalesced by debounceMs, so a burst of saves, or a
+	 * formatter rewriting many files, builds once. Requests made while a
+	 * rebuild is waiting or running are coalesced either way; 0, the
+	 * default, only skips the wait. Applies to every context.
+	 */
+	if debounceMs < 0 {
+		debounceMs = 0
+	}
+	rebuildDebounce.Store(int64(time.Duration(debounceMs) * time.Millisecond))
+}
+
+//export RebuildContextCoalesced
+func RebuildContextCoalesced(id C.int) (returnCoalesced C.int, returnError *C.char) {
+	/*
+	 * Rebuilds like RebuildContext, and returns 1 if the request shared a
+	 * rebuild queued by another call rather than queuing its own. See
+	 * requestRebuild.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return 0, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	coalesced, err := context.requestRebuild()
+	var returnValue C.int
+	if coalesced {
+		returnValue = 1
+	}
+	if err != nil {
+		return returnValue, C.CString(err.Error())
+	}
+	return returnValue, nil
+}
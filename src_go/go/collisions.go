@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FindOutputCollisions predicts each entrypoint's output path from the
+// entry name template and its output type, .css for stylesheets and .js
+// for everything else, and returns the paths claimed by more than one
+// entrypoint. Templates with a [hash] placeholder can't collide on content
+// that differs, so they're never reported.
+func FindOutputCollisions(entrypoints []string, workingDir string, entryNames string) map[string][]string {
+	return collidingPaths(entrypoints, predictOutputPaths(entrypoints, workingDir, entryNames))
+}
+
+// predictOutputPaths returns the output path FindOutputCollisions predicts
+// for each entrypoint, relative to outdir, or "" where it can't tell. It
+// returns nil for templates with a [hash] placeholder.
+func predictOutputPaths(entrypoints []string, workingDir string, entryNames string) []string {
+	if entryNames == "" {
+		entryNames = "[dir]/[name]"
+	}
+	if strings.Contains(entryNames, "[hash]") {
+		return nil
+	}
+
+	absoluteEntrypoints := make([]string, len(entrypoints))
+	for i, entrypoint := range entrypoints {
+		if filepath.IsAbs(entrypoint) {
+			absoluteEntrypoints[i] = filepath.Clean(entrypoint)
+		} else {
+			absoluteEntrypoints[i] = filepath.Join(workingDir, entrypoint)
+		}
+	}
+
+	// Like esbuild, [dir] is relative to the lowest common ancestor of all
+	// the entrypoints
+	outbase := lowestCommonDirectory(absoluteEntrypoints)
+
+	outputPaths := make([]string, len(entrypoints))
+	for i, entrypoint := range absoluteEntrypoints {
+		dir, err := filepath.Rel(outbase, filepath.Dir(entrypoint))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(entrypoint), filepath.Ext(entrypoint))
+		extension := "js"
+		if filepath.Ext(entrypoint) == ".css" {
+			extension = "css"
+		}
+
+		// esbuild appends the extension whether or not the template has [ext]
+		outputPath := strings.NewReplacer(
+			"[dir]", filepath.ToSlash(dir),
+			"[name]", name,
+			"[ext]", extension,
+		).Replace(entryNames) + "." + extension
+		outputPaths[i] = filepath.ToSlash(filepath.Clean(outputPath))
+	}
+	return outputPaths
+}
+
+// collidingPaths returns the output paths claimed by more than one
+// entrypoint, along with the entrypoints claiming each.
+func collidingPaths(entrypoints []string, outputPaths []string) map[string][]string {
+	claimed := make(map[string][]string)
+	for i, outputPath := range outputPaths {
+		if outputPath != "" {
+			claimed[outputPath] = append(claimed[outputPath], entrypoints[i])
+		}
+	}
+
+	collisions := make(map[string][]string)
+	for outputPath, claimants := range claimed {
+		if len(claimants) > 1 {
+			collisions[outputPath] = claimants
+		}
+	}
+	return collisions
+}
+
+func FormatOutputCollisions(collisions map[string][]string) []string {
+	messages := make([]string, 0, len(collisions))
+	for outputPath, claimants := range collisions {
+		messages = append(messages, fmt.Sprintf(
+			"Entrypoints %s would all be written to %s",
+			strings.Join(claimants, ", "),
+			outputPath,
+		))
+	}
+	sort.Strings(messages)
+	return messages
+}
+
+func lowestCommonDirectory(paths []string) string {
+	if len(paths) == 0 {
+		return ""
+	}
+
+	common := filepath.Dir(paths[0])
+	for _, path := range paths[1:] {
+		dir := filepath.Dir(path)
+		for common != dir && !strings.HasPrefix(dir, common+string(filepath.Separator)) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+			common = parent
+		}
+	}
+	return common
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+import "C"
+
+// ProjectConfig is the subset of settings read from a project's
+// esbuild.config.json. Keys follow the matching BundleOptions names, which
+// are plural where esbuild's are not, like "loaders" for esbuild's "loader":
+//
+//	{
+//	  "loaders": {".png": "file"},
+//	  "defines": {"API_URL": "\"https://example.com\""},
+//	  "externals": ["fsevents"],
+//	  "target": "es2020,chrome100",
+//	  "aliases": {"lodash": "lodash-es"}
+//	}
+//
+// Unrecognized keys produce a warning rather than an error so that configs
+// written for newer versions still load.
+type ProjectConfig struct {
+	Loaders   map[string]string `json:"loaders"`
+	Defines   map[string]string `json:"defines"`
+	Externals []string          `json:"externals"`
+	Target    string            `json:"target"`
+	Aliases   map[string]string `json:"aliases"`
+}
+
+var projectConfigKeys = map[string]bool{
+	"loaders":   true,
+	"defines":   true,
+	"externals": true,
+	"target":    true,
+	"aliases":   true,
+}
+
+func LoadProjectConfig(configPath string) (ProjectConfig, []string, error) {
+	contents, err := os.ReadFile(configPath)
+	if err != nil {
+		return ProjectConfig{}, nil, err
+	}
+
+	var rawConfig map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &rawConfig); err != nil {
+		return ProjectConfig{}, nil, fmt.Errorf("Invalid config %s: %s", configPath, err)
+	}
+
+	warnings := []string{}
+	for key := range rawConfig {
+		if !projectConfigKeys[key] {
+			warnings = append(warnings, fmt.Sprintf("Ignoring unknown key %q in %s", key, configPath))
+		}
+	}
+	sort.Strings(warnings)
+
+	var config ProjectConfig
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return ProjectConfig{}, nil, fmt.Errorf("Invalid config %s: %s", configPath, err)
+	}
+	return config, warnings, nil
+}
+
+// MergeProjectConfig layers the per-call options on top of the project
+// config. Maps are merged key by key and externals are combined, with the
+// per-call value winning wherever both set the same key.
+func MergeProjectConfig(config ProjectConfig, options BundleOptions) BundleOptions {
+	options.Loaders = mergeStringMaps(config.Loaders, options.Loaders)
+	options.Defines = mergeStringMaps(config.Defines, options.Defines)
+
+	if options.Target == "" {
+		options.Target = config.Target
+	}
+
+	externals := append([]string{}, config.Externals...)
+	for _, external := range options.Externals {
+		if !containsString(externals, external) {
+			externals = append(externals, external)
+		}
+	}
+	options.Externals = externals
+
+	overriddenAliases := make(map[string]bool, len(options.Aliases))
+	for _, pair := range options.Aliases {
+		overriddenAliases[pair[0]] = true
+	}
+	aliasKeys := make([]string, 0, len(config.Aliases))
+	for from := range config.Aliases {
+		aliasKeys = append(aliasKeys, from)
+	}
+	sort.Strings(aliasKeys)
+
+	aliases := [][2]string{}
+	for _, from := range aliasKeys {
+		if !overriddenAliases[from] {
+			aliases = append(aliases, [2]string{from, config.Aliases[from]})
+		}
+	}
+	options.Aliases = append(aliases, options.Aliases...)
+
+	return options
+}
+
+// ResolveBundleOptions returns the options a BundleAll call would actually
+// build with, after merging in the project config and applying presets.
+func ResolveBundleOptions(options BundleOptions) (BundleOptions, []string, error) {
+	// Checked before merging, so browserslist can still replace a target
+	// from the project config
+	if options.UseBrowserslist && options.Target != "" {
+		return BundleOptions{}, nil, fmt.Errorf("target and useBrowserslist can't both be set")
+	}
+
+	warnings := []string{}
+	if options.ConfigFile != "" {
+		config, configWarnings, err := LoadProjectConfig(options.ConfigFile)
+		if err != nil {
+			return BundleOptions{}, nil, err
+		}
+		warnings = append(warnings, configWarnings...)
+		options = MergeProjectConfig(config, options)
+	}
+	if options.UseBrowserslist {
+		target, err := browserslistTargetFor(options)
+		if err != nil {
+			return BundleOptions{}, nil, err
+		}
+		options.Target = target
+	}
+	return ApplyProductionPreset(options), warnings, nil
+}
+
+// browserslistTargetFor derives the target from the browserslist config for
+// the options' working directory and environment.
+func browserslistTargetFor(options BundleOptions) (string, error) {
+	tsconfigPath := ""
+	if options.Tsconfig != "" {
+		var err error
+		if tsconfigPath, err = filepath.Abs(options.Tsconfig); err != nil {
+			return "", err
+		}
+	}
+	workingDir, err := resolveWorkingDir(options.AbsWorkingDir, tsconfigPath)
+	if err != nil {
+		return "", err
+	}
+
+	configPath := options.BrowserslistPath
+	if configPath != "" && !filepath.IsAbs(configPath) {
+		configPath = filepath.Join(workingDir, configPath)
+	}
+	queries, err := LoadBrowserslist(configPath, workingDir, options.Environment)
+	if err != nil {
+		return "", err
+	}
+	return BrowserslistTarget(queries)
+}
+
+//export ResolveBundleConfig
+func ResolveBundleConfig(rawOptions *C.char) (returnOptions *C.char, returnError *C.char) {
+	/*
+	 * Returns {"options": ..., "warnings": [...]} with the effective options
+	 * for a BundleAll call, without building.
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+
+	resolvedOptions, warnings, err := ResolveBundleOptions(options)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(struct {
+		Options  BundleOptions `json:"options"`
+		Warnings []string      `json:"warnings"`
+	}{resolvedOptions, warnings})
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+func mergeStringMaps(base map[string]string, overrides map[string]string) map[string]string {
+	if len(base) == 0 && len(overrides) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(overrides))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
+func containsString(values []string, value string) bool {
+	for _, existing := range values {
+		if existing == value {
+			return true
+		}
+	}
+	return false
+}
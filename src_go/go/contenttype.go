@@ -0,0 +1,56 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+import "C"
+
+const defaultContentType = "application/octet-stream"
+
+// Content types for what a build emits: esbuild's own outputs, plus the
+// assets the file and copy loaders usually pass through. Text types declare
+// UTF-8, since the utf8 charset emits non-ASCII text as is.
+var contentTypesByExtension = map[string]string{
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".cjs":   "text/javascript; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".map":   "application/json",
+	".json":  "application/json",
+	".html":  "text/html; charset=utf-8",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".avif":  "image/avif",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+}
+
+// OutputContentType returns the Content-Type to serve an output with, going
+// by its extension, or application/octet-stream for unknown ones.
+func OutputContentType(path string) string {
+	contentType, exists := contentTypesByExtension[strings.ToLower(filepath.Ext(path))]
+	if !exists {
+		return defaultContentType
+	}
+	return contentType
+}
+
+//export GetOutputContentType
+func GetOutputContentType(rawPath *C.char) (returnContentType *C.char) {
+	/*
+	 * Returns the Content-Type header value for serving an output, like one
+	 * from GetContextOutput, by its path or bare extension.
+	 */
+	return C.CString(OutputContentType(C.GoString(rawPath)))
+}
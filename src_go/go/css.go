@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// RestyleCSSOutputs re-prints the CSS outputs of a build with or without
+// minification, leaving every other output alone. esbuild applies its
+// minify settings to JS and CSS alike, so this is how the two are given
+// different settings.
+//
+// Each stylesheet's sourcemap is chained through the second pass, so it
+// still points at the original sources. Some differences from minifying
+// during the build:
+//   - Un-minifying only restores whitespace. Syntax the build already
+//     shortened, like "margin: 0px 0px" to "margin: 0", stays shortened.
+//   - Minifying doesn't rename local CSS class names, since the JS that
+//     references them has already been emitted.
+//   - The metafile still reports the byte sizes from before this pass.
+func RestyleCSSOutputs(outputFiles []api.OutputFile, minify bool, charset api.Charset, sourcesContent api.SourcesContent, sourceRoot string) error {
+	sourceMaps := make(map[string]int)
+	for index, outputFile := range outputFiles {
+		if strings.HasSuffix(outputFile.Path, ".css.map") {
+			sourceMaps[outputFile.Path] = index
+		}
+	}
+
+	for index, outputFile := range outputFiles {
+		if filepath.Ext(outputFile.Path) != ".css" {
+			continue
+		}
+
+		source := string(outputFile.Contents)
+		sourceMapIndex, hasSourceMap := sourceMaps[outputFile.Path+".map"]
+		if hasSourceMap {
+			source += "\n/*# sourceMappingURL=data:application/json;base64," +
+				base64.StdEncoding.EncodeToString(outputFiles[sourceMapIndex].Contents) + " */\n"
+		}
+
+		transformOptions := api.TransformOptions{
+			Loader:           api.LoaderCSS,
+			Sourcefile:       filepath.Base(outputFile.Path),
+			MinifyWhitespace: minify,
+			MinifySyntax:     minify,
+			Charset:          charset,
+		}
+		if hasSourceMap {
+			transformOptions.Sourcemap = api.SourceMapExternal
+			transformOptions.SourcesContent = sourcesContent
+			// Not carried over from the input sourcemap
+			transformOptions.SourceRoot = sourceRoot
+		}
+
+		result := api.Transform(source, transformOptions)
+		if len(result.Errors) > 0 {
+			header := fmt.Sprintf("Error restyling %s:\n\n", outputFile.Path)
+			return fmt.Errorf("%s", FormatBuildErrors(header, result.Errors))
+		}
+
+		outputFiles[index].Contents = result.Code
+		if hasSourceMap {
+			outputFiles[sourceMapIndex].Contents = result.Map
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"unsafe"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// #include <stdint.h>
+// #include <stdlib.h>
+//
+// typedef int32_t (*css_processor_callback)(void* userData, const char* path, const char* contents, int32_t length, char** output, int32_t* outputLength);
+//
+// static inline int32_t invoke_css_processor_callback(css_processor_callback callback, void* userData, const char* path, const char* contents, int32_t length, char** output, int32_t* outputLength) {
+//     return callback(userData, path, contents, length, output, outputLength);
+// }
+import "C"
+
+// CSSProcessor transforms a CSS output after esbuild has bundled it, like a
+// PostCSS or Tailwind pass, and returns the new contents.
+type CSSProcessor func(path string, contents []byte) ([]byte, error)
+
+// ProcessCSSOutputs runs every CSS output of a build through process,
+// leaving other outputs alone. Like RestyleCSSOutputs, this happens after
+// esbuild picked the output filenames and wrote the metafile, so hashed
+// names and reported sizes are from before processing. Sourcemaps aren't
+// chained through process, so a stylesheet's map still describes esbuild's
+// output.
+func ProcessCSSOutputs(outputFiles []api.OutputFile, process CSSProcessor) error {
+	for index, outputFile := range outputFiles {
+		if filepath.Ext(outputFile.Path) != ".css" {
+			continue
+		}
+		contents, err := process(outputFile.Path, outputFile.Contents)
+		if err != nil {
+			return err
+		}
+		outputFiles[index].Contents = contents
+	}
+	return nil
+}
+
+//export BundleAllWithCSSProcessor
+func BundleAllWithCSSProcessor(
+	rawOptions *C.char,
+	callback C.css_processor_callback,
+	userData unsafe.Pointer,
+) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll, but passes each CSS output through callback
+	 * before anything is written, so the host can run PostCSS or Tailwind
+	 * over the bundled stylesheets. See ProcessCSSOutputs for what the
+	 * metafile and sourcemaps reflect.
+	 *
+	 * callback receives the output's absolute path and contents and returns
+	 * 0 with *output set to a malloc()ed buffer of *outputLength bytes,
+	 * which Go takes ownership of and frees. Leaving *output NULL keeps the
+	 * stylesheet as it is. Any other return value fails the build. callback
+	 * runs on the calling thread, once per stylesheet, and userData is
+	 * passed through.
+	 */
+	if callback == nil {
+		return nil, C.CString("No CSS processor callback provided")
+	}
+
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+
+	options.CSSProcessor = func(path string, contents []byte) ([]byte, error) {
+		rawPath := C.CString(path)
+		defer C.free(unsafe.Pointer(rawPath))
+		rawContents := C.CBytes(contents)
+		defer C.free(rawContents)
+
+		var output *C.char
+		var outputLength C.int32_t
+		status := C.invoke_css_processor_callback(callback, userData, rawPath, (*C.char)(rawContents), C.int32_t(len(contents)), &output, &outputLength)
+		if status != 0 {
+			return nil, fmt.Errorf("CSS processor failed for %s with status %d", path, status)
+		}
+		if output == nil {
+			return contents, nil
+		}
+		defer C.free(unsafe.Pointer(output))
+		return C.GoBytes(unsafe.Pointer(output), C.int(outputLength)), nil
+	}
+
+	result, err := bundleAll(options, writeBundleOutputs)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
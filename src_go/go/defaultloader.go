@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// Extensions esbuild loads without being told how
+var builtinLoaderExtensions = map[string]bool{
+	".js":         true,
+	".mjs":        true,
+	".cjs":        true,
+	".jsx":        true,
+	".ts":         true,
+	".cts":        true,
+	".mts":        true,
+	".tsx":        true,
+	".css":        true,
+	".module.css": true,
+	".json":       true,
+	".txt":        true,
+}
+
+// DefaultLoaderPlugin loads files whose extension has no loader with
+// loader, instead of failing the build. esbuild's loader map has no
+// catch-all key (an empty extension only matches extensionless files), so
+// the fallback happens at load time. Extensions are matched the way esbuild
+// matches them, so a ".module.css" loader also claims "a.module.css".
+func DefaultLoaderPlugin(loader api.Loader, loaders map[string]api.Loader) api.Plugin {
+	isKnown := func(base string) bool {
+		for index := strings.IndexByte(base, '.'); index != -1; index = strings.IndexByte(base, '.') {
+			extension := base[index:]
+			if _, exists := loaders[extension]; exists || builtinLoaderExtensions[extension] {
+				return true
+			}
+			base = base[index+1:]
+		}
+		return false
+	}
+
+	return api.Plugin{
+		Name: "default-loader",
+		Setup: func(build api.PluginBuild) {
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `\.[^/\\]+$`, Namespace: "file"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					if isKnown(filepath.Base(args.Path)) {
+						return api.OnLoadResult{}, nil
+					}
+
+					contents, err := os.ReadFile(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					contentsString := string(contents)
+					return api.OnLoadResult{Contents: &contentsString, Loader: loader}, nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+import "C"
+
+// Defines that SSR and client bundles set differently on purpose
+var ssrOnlyDefines = map[string]bool{
+	"process.env.SSR_RENDERING": true,
+	"global":                    true,
+}
+
+// DefineMismatch is a define whose replacement differs between an SSR and a
+// client build. A side that doesn't set it at all is null.
+type DefineMismatch struct {
+	Name   string  `json:"name"`
+	SSR    *string `json:"ssr"`
+	Client *string `json:"client"`
+}
+
+// DiffSharedDefines lists the defines, sorted by name, that the SSR and
+// client builds replace differently, skipping the ones in ssrOnlyDefines.
+// Any of these can render different markup on the server than the client
+// hydrates with. Values of secret-looking defines are redacted, as in
+// DescribeBuildOptions, so the result is safe to log.
+func DiffSharedDefines(ssrDefines map[string]string, clientDefines map[string]string) []DefineMismatch {
+	names := map[string]bool{}
+	for name := range ssrDefines {
+		names[name] = true
+	}
+	for name := range clientDefines {
+		names[name] = true
+	}
+
+	value := func(defines map[string]string, name string) *string {
+		replacement, exists := defines[name]
+		if !exists {
+			return nil
+		}
+		if secretDefinePattern.MatchString(name) {
+			replacement = redactedDefine
+		}
+		return &replacement
+	}
+
+	mismatches := []DefineMismatch{}
+	for name := range names {
+		ssrValue, ssrExists := ssrDefines[name]
+		clientValue, clientExists := clientDefines[name]
+		if ssrOnlyDefines[name] || (ssrExists == clientExists && ssrValue == clientValue) {
+			continue
+		}
+		mismatches = append(mismatches, DefineMismatch{
+			Name:   name,
+			SSR:    value(ssrDefines, name),
+			Client: value(clientDefines, name),
+		})
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Name < mismatches[j].Name
+	})
+	return mismatches
+}
+
+//export CompareContextDefines
+func CompareContextDefines(ssrID C.int, clientID C.int) (returnMismatches *C.char, returnError *C.char) {
+	/*
+	 * Compares the effective defines of an SSR context and the client context
+	 * for the same page, including ones set through UpdateContextDefines,
+	 * and returns a JSON array of DefineMismatch. A non-empty result is a
+	 * likely cause of hydration mismatches, worth a warning from the host.
+	 */
+	ssrContext, exists := getContext(ssrID)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", ssrID))
+	}
+	clientContext, exists := getContext(clientID)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", clientID))
+	}
+
+	// Lock one at a time, since both IDs may name the same context
+	ssrContext.lock.Lock()
+	ssrDefines := ssrContext.Options.Define
+	ssrContext.lock.Unlock()
+	clientContext.lock.Lock()
+	clientDefines := clientContext.Options.Define
+	clientContext.lock.Unlock()
+
+	payload, err := json.Marshal(DiffSharedDefines(ssrDefines, clientDefines))
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A ${NAME} reference to another define, by any name esbuild accepts,
+// including dotted ones like process.env.HOST
+var defineReferencePattern = regexp.MustCompile(`\$\{([A-Za-z_$][\w$]*(?:\.[A-Za-z_$][\w$]*)*)\}`)
+
+// ExpandDefines replaces ${NAME} references in define values with the value
+// of define NAME, so API_URL can be built from HOST and PORT. esbuild only
+// substitutes identifiers in the code, never inside other define values.
+//
+// References resolve against defines first and then base, whose values are
+// used as is. Inside a string literal value, a reference to another string
+// literal inserts its text rather than its quoted JSON, so
+// {"HOST": "\"localhost\"", "URL": "\"http://${HOST}/\""} makes URL
+// "\"http://localhost/\"". Elsewhere, like in a JSON object value, the
+// referenced value is inserted verbatim. References to names that aren't
+// defines are left alone, since JS template literals look the same. A cycle
+// is an error.
+func ExpandDefines(defines map[string]string, base map[string]string) (map[string]string, error) {
+	expanded := make(map[string]string, len(defines))
+	expanding := map[string]bool{}
+	var path []string
+
+	var expand func(name string) (string, error)
+	expand = func(name string) (string, error) {
+		if value, exists := expanded[name]; exists {
+			return value, nil
+		}
+		value, exists := defines[name]
+		if !exists {
+			return base[name], nil
+		}
+		if !defineReferencePattern.MatchString(value) {
+			expanded[name] = value
+			return value, nil
+		}
+		if expanding[name] {
+			cycleStart := 0
+			for path[cycleStart] != name {
+				cycleStart++
+			}
+			return "", fmt.Errorf("Define references form a cycle: %s -> %s", strings.Join(path[cycleStart:], " -> "), name)
+		}
+
+		expanding[name] = true
+		path = append(path, name)
+		defer func() {
+			expanding[name] = false
+			path = path[:len(path)-1]
+		}()
+
+		var text string
+		isString := json.Unmarshal([]byte(value), &text) == nil
+		if !isString {
+			text = value
+		}
+
+		var expandErr error
+		text = defineReferencePattern.ReplaceAllStringFunc(text, func(reference string) string {
+			referenced := defineReferencePattern.FindStringSubmatch(reference)[1]
+			_, isDefine := defines[referenced]
+			_, isBase := base[referenced]
+			if expandErr != nil || (!isDefine && !isBase) {
+				return reference
+			}
+			replacement, err := expand(referenced)
+			if err != nil {
+				expandErr = err
+				return reference
+			}
+			if isString {
+				var referencedText string
+				if json.Unmarshal([]byte(replacement), &referencedText) == nil {
+					return referencedText
+				}
+			}
+			return replacement
+		})
+		if expandErr != nil {
+			return "", expandErr
+		}
+
+		if isString {
+			// Without HTML escaping, which would turn "<" into "\u003c"
+			var encoded strings.Builder
+			encoder := json.NewEncoder(&encoded)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(text); err != nil {
+				return "", err
+			}
+			text = strings.TrimSuffix(encoded.String(), "\n")
+		}
+		expanded[name] = text
+		return text, nil
+	}
+
+	// Sorted so the same cycle is always reported from the same define
+	names := make([]string, 0, len(defines))
+	for name := range defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err := expand(name); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// ContextDescription is a readable dump of the options a context builds
+// with, after defaults and later updates like UpdateContextDefines.
+type ContextDescription struct {
+	ID                int               `json:"id"`
+	Entrypoints       []string          `json:"entrypoints"`
+	Outfile           string            `json:"outfile"`
+	Format            string            `json:"format"`
+	Platform          string            `json:"platform"`
+	Conditions        []string          `json:"conditions,omitempty"`
+	GlobalName        string            `json:"globalName,omitempty"`
+	Target            string            `json:"target"`
+	Engines           []string          `json:"engines"`
+	JSX               string            `json:"jsx"`
+	JSXImportSource   string            `json:"jsxImportSource,omitempty"`
+	JSXDev            bool              `json:"jsxDev,omitempty"`
+	Sourcemap         string            `json:"sourcemap"`
+	Loaders           map[string]string `json:"loaders"`
+	ResolveExtensions []string          `json:"resolveExtensions"`
+	Defines           map[string]string `json:"defines"`
+	Aliases           map[string]string `json:"aliases"`
+	Externals         []string          `json:"externals"`
+	NodePaths         []string          `json:"nodePaths"`
+	KeepOutputs       bool              `json:"keepOutputs"`
+	FailFast          bool              `json:"failFast"`
+	MaxErrors         int               `json:"maxErrors,omitempty"`
+	Rebuilds          int               `json:"rebuilds"`
+	ServePort         int               `json:"servePort,omitempty"`
+}
+
+// Defines are where hosts inject API keys and the like, so values whose name
+// looks like a credential are left out of descriptions
+var secretDefinePattern = regexp.MustCompile(`(?i)secret|token|password|passwd|api_?key|private|credential|auth`)
+
+const redactedDefine = `"[redacted]"`
+
+var platformNames = map[api.Platform]string{
+	api.PlatformBrowser: "browser",
+	api.PlatformNode:    "node",
+	api.PlatformNeutral: "neutral",
+}
+
+var sourcemapNames = map[api.SourceMap]string{
+	api.SourceMapNone:              "none",
+	api.SourceMapInline:            "inline",
+	api.SourceMapLinked:            "linked",
+	api.SourceMapExternal:          "external",
+	api.SourceMapInlineAndExternal: "both",
+}
+
+// nameFor looks up the name a value was parsed from, or "" for values that
+// have no name, like esbuild's defaults.
+func nameFor[T comparable](namesByValue map[string]T, value T) string {
+	for name, candidate := range namesByValue {
+		if candidate == value {
+			return name
+		}
+	}
+	return ""
+}
+
+// DescribeBuildOptions renders options with enums as the names this package
+// accepts for them, and with secret-looking defines redacted. See
+// secretDefinePattern.
+func DescribeBuildOptions(options api.BuildOptions) ContextDescription {
+	engines := make([]string, 0, len(options.Engines))
+	for _, engine := range options.Engines {
+		engines = append(engines, nameFor(enginesByName, engine.Name)+engine.Version)
+	}
+
+	loaders := make(map[string]string, len(options.Loader))
+	for extension, loader := range options.Loader {
+		loaders[extension] = nameFor(loadersByName, loader)
+	}
+
+	defines := make(map[string]string, len(options.Define))
+	for key, value := range options.Define {
+		if secretDefinePattern.MatchString(key) {
+			value = redactedDefine
+		}
+		defines[key] = value
+	}
+
+	externals := append([]string{}, options.External...)
+	sort.Strings(externals)
+
+	return ContextDescription{
+		Entrypoints:       options.EntryPoints,
+		Outfile:           options.Outfile,
+		Format:            nameFor(formatsByName, options.Format),
+		Platform:          platformNames[options.Platform],
+		Conditions:        options.Conditions,
+		GlobalName:        options.GlobalName,
+		Target:            nameFor(targetsByName, options.Target),
+		Engines:           engines,
+		JSX:               nameFor(jsxModesByName, options.JSX),
+		JSXImportSource:   options.JSXImportSource,
+		JSXDev:            options.JSXDev,
+		Sourcemap:         sourcemapNames[options.Sourcemap],
+		Loaders:           loaders,
+		ResolveExtensions: options.ResolveExtensions,
+		Defines:           defines,
+		Aliases:           options.Alias,
+		Externals:         externals,
+		NodePaths:         options.NodePaths,
+	}
+}
+
+//export DescribeContext
+func DescribeContext(id C.int) (returnDescription *C.char, returnError *C.char) {
+	/*
+	 * Returns the context's effective build options as a ContextDescription
+	 * JSON object, for attaching to bug reports. Defines with names like
+	 * "API_KEY" or "AUTH_TOKEN" have their values replaced with
+	 * "[redacted]".
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	description := DescribeBuildOptions(context.Options)
+	description.ID = int(id)
+	description.KeepOutputs = context.KeepOutputs
+	description.FailFast = context.FailFast
+	description.MaxErrors = context.MaxErrors
+	description.Rebuilds = context.Rebuilds
+	description.ServePort = context.ServePort
+	context.lock.Unlock()
+
+	payload, err := json.Marshal(description)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const emptyModulesNamespace = "empty-module"
+
+// EmptyModulesPlugin resolves imports of the given modules, and of any path
+// within them like "debug-panel/styles.css", to an empty module, for
+// stripping dev-only tooling out of production bundles. Unlike externals,
+// nothing is left behind: esbuild's empty loader makes every import of them
+// undefined, so the importing code tree-shakes away too when it's only
+// reached through them.
+func EmptyModulesPlugin(modules []string) api.Plugin {
+	patterns := make([]string, len(modules))
+	for index, module := range modules {
+		patterns[index] = regexp.QuoteMeta(module)
+	}
+	filter := `^(` + strings.Join(patterns, "|") + `)(/.*)?$`
+
+	return api.Plugin{
+		Name: "empty-modules",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(
+				api.OnResolveOptions{Filter: filter},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					return api.OnResolveResult{Path: args.Path, Namespace: emptyModulesNamespace}, nil
+				},
+			)
+
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: emptyModulesNamespace},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					contents := ""
+					return api.OnLoadResult{Contents: &contents, Loader: api.LoaderEmpty}, nil
+				},
+			)
+		},
+	}
+}
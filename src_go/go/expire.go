@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+import "C"
+
+//export DisposeContextsOlderThan
+func DisposeContextsOlderThan(seconds C.int) (returnCount C.int, returnFilenames *C.char) {
+	/*
+	 * Disposes every context that hasn't rebuilt in the last `seconds`,
+	 * counting a context that never rebuilt from its creation. Long-running
+	 * dev servers can call this periodically to drop contexts for pages
+	 * nobody is editing, regardless of the SetMaxContexts cap. Contexts busy
+	 * rebuilding are skipped. Returns how many were disposed and a JSON array
+	 * of their filenames. The eviction callback isn't called.
+	 */
+	filenames := disposeContextsOlderThan(time.Duration(seconds) * time.Second)
+	payload, _ := json.Marshal(filenames)
+	return C.int(len(filenames)), C.CString(string(payload))
+}
+
+func disposeContextsOlderThan(age time.Duration) []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	cutoff := time.Now().Add(-age)
+	filenames := []string{}
+	for id, context := range contexts {
+		if !context.lock.TryLock() {
+			continue
+		}
+		if context.LastUsed.Before(cutoff) {
+			delete(contexts, id)
+			context.dispose()
+			filenames = append(filenames, context.Filename)
+		}
+		context.lock.Unlock()
+	}
+	sort.Strings(filenames)
+	return filenames
+}
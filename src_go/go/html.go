@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const defaultHTMLTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+</head>
+<body>
+</body>
+</html>
+`
+
+// RenderEntryHTML creates an HTML page per entrypoint that loads its script
+// and stylesheet, named after the entrypoint and placed next to its script,
+// so "pages/home.tsx" built to "dist/home-HASH.js" gets "dist/home.html".
+// Stylesheets are injected before the template's </head> and the script
+// before its </body>. Only entrypoints are covered, not the chunks esbuild
+// creates for dynamic imports, which their importers load on their own.
+// inlineScripts are added as inline <script> tags ahead of the entry's
+// script, for bootstrapping code that has to run first. Entrypoints sharing
+// a page path overwrite each other's page; see FindHTMLPageCollisions.
+func RenderEntryHTML(
+	manifest BuildManifest,
+	entrypoints []string,
+	workingDir string,
+	template string,
+	module bool,
+	inlineScripts []string,
+) ([]api.OutputFile, error) {
+	lowerTemplate := strings.ToLower(template)
+	headIndex := strings.LastIndex(lowerTemplate, "</head>")
+	bodyIndex := strings.LastIndex(lowerTemplate, "</body>")
+	if headIndex == -1 || bodyIndex == -1 || headIndex > bodyIndex {
+		return nil, fmt.Errorf("HTML template needs a </head> followed by a </body>")
+	}
+
+	isEntrypoint := make(map[string]bool, len(entrypoints))
+	for _, entrypoint := range entrypoints {
+		isEntrypoint[absolutePath(entrypoint, workingDir)] = true
+	}
+
+	scriptType := ""
+	if module {
+		scriptType = ` type="module"`
+	}
+
+	inlineScriptTags := ""
+	for _, inlineScript := range inlineScripts {
+		inlineScriptTags += "<script>" + inlineScript + "</script>\n"
+	}
+
+	pages := []api.OutputFile{}
+	for _, entry := range manifest.Entries {
+		if !isEntrypoint[absolutePath(entry.Source, workingDir)] {
+			continue
+		}
+
+		outputPath := absolutePath(entry.Output, workingDir)
+		outputDir := filepath.Dir(outputPath)
+		sourceName := filepath.Base(entry.Source)
+		pagePath := filepath.Join(outputDir, strings.TrimSuffix(sourceName, filepath.Ext(sourceName))+".html")
+
+		styles := ""
+		if entry.CSS != "" {
+			href, err := filepath.Rel(outputDir, absolutePath(entry.CSS, workingDir))
+			if err != nil {
+				return nil, err
+			}
+			styles = fmt.Sprintf("<link rel=\"stylesheet\" href=\"%s\">\n", html.EscapeString(filepath.ToSlash(href)))
+		}
+		script := fmt.Sprintf("<script%s src=\"%s\"></script>\n", scriptType, html.EscapeString(filepath.Base(outputPath)))
+
+		page := template[:headIndex] + styles + template[headIndex:bodyIndex] + inlineScriptTags + script + template[bodyIndex:]
+		pages = append(pages, api.OutputFile{Path: pagePath, Contents: []byte(page)})
+	}
+	return pages, nil
+}
+
+// FindHTMLPageCollisions returns the HTML page paths RenderEntryHTML would
+// give more than one entrypoint, along with the entrypoints claiming each.
+// Pages are named after the entrypoint alone, so entrypoints whose scripts
+// a [hash] keeps apart, like "a/index.tsx" and "b/index.tsx" under
+// "[name]-[hash]", still write the same page.
+func FindHTMLPageCollisions(entrypoints []string, workingDir string, entryNames string) map[string][]string {
+	if entryNames == "" {
+		entryNames = "[dir]/[name]"
+	}
+	// Pages go in the script's directory, whatever its own name looks like
+	pageNames := path.Join(path.Dir(entryNames), "[name]")
+
+	pagePaths := predictOutputPaths(entrypoints, workingDir, pageNames)
+	for i, pagePath := range pagePaths {
+		if pagePath != "" {
+			pagePaths[i] = strings.TrimSuffix(pagePath, path.Ext(pagePath)) + ".html"
+		}
+	}
+	return collidingPaths(entrypoints, pagePaths)
+}
+
+// readHTMLTemplate returns the template at path, or the default template if
+// path is empty.
+func readHTMLTemplate(path string) (string, error) {
+	if path == "" {
+		return defaultHTMLTemplate, nil
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Invalid HTML template: %s", err)
+	}
+	return string(contents), nil
+}
+
+// ScriptHashes returns the CSP hash source for each inline script, like
+// "sha256-..."; a Content-Security-Policy lists them in single quotes under
+// script-src. The hash covers exactly the text between the script tags,
+// which is why RenderEntryHTML injects the scripts unchanged.
+func ScriptHashes(inlineScripts []string) []string {
+	hashes := make([]string, len(inlineScripts))
+	for index, inlineScript := range inlineScripts {
+		digest := sha256.Sum256([]byte(inlineScript))
+		hashes[index] = "sha256-" + base64.StdEncoding.EncodeToString(digest[:])
+	}
+	return hashes
+}
+
+// validateInlineScript rejects scripts that would end their own tag early.
+func validateInlineScript(inlineScript string) error {
+	if strings.Contains(strings.ToLower(inlineScript), "</script") {
+		return fmt.Errorf("Inline script can't contain \"</script\": %q", inlineScript)
+	}
+	return nil
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// IncrementalPlan splits a build's entrypoints into the ones to rebuild and
+// the ones whose outputs from the previous build are still current.
+type IncrementalPlan struct {
+	Rebuild []string
+	Reuse   []string
+	// The previous metafile's entries for the reused outputs and the inputs
+	// they were built from, carried over into this build's metafile
+	outputs map[string]json.RawMessage
+	inputs  map[string]json.RawMessage
+}
+
+type rawMetafile struct {
+	Inputs  map[string]json.RawMessage `json:"inputs"`
+	Outputs map[string]json.RawMessage `json:"outputs"`
+}
+
+// PlanIncrementalBuild compares the inputs recorded in the previous build's
+// metafile against the outputs that build wrote. An entrypoint is reused if
+// all its outputs are still in outdir and none of its transitive inputs was
+// modified after them; any other entrypoint, including one the previous
+// build didn't have, is rebuilt.
+//
+// This is best-effort, since esbuild itself always rebuilds everything it's
+// given:
+//   - Only files are compared. Changes to the options, to inputs of plugin
+//     namespaces, or to which file an import resolves to aren't noticed, so
+//     the previous build must have used the same options.
+//   - With code splitting, entrypoints sharing a chunk are rebuilt together,
+//     since rebuilding only some of them would split the chunk differently.
+//   - An input saved while the previous build was running can look older
+//     than the outputs built from its earlier contents.
+func PlanIncrementalBuild(priorMetafile string, entrypoints []string, workingDir string, outdir string) (IncrementalPlan, error) {
+	metafile, err := ParseMetafile(priorMetafile)
+	if err != nil {
+		return IncrementalPlan{}, err
+	}
+	var raw rawMetafile
+	if err := json.Unmarshal([]byte(priorMetafile), &raw); err != nil {
+		return IncrementalPlan{}, fmt.Errorf("Invalid metafile: %s", err)
+	}
+
+	// Each entrypoint's outputs, along with the chunks, stylesheets, assets,
+	// and sourcemaps they pull in
+	entryOutputs := map[string][]string{}
+	for _, outputPath := range sortedOutputPaths(metafile) {
+		if entryPoint := metafile.Outputs[outputPath].EntryPoint; entryPoint != "" {
+			entry := absolutePath(entryPoint, workingDir)
+			entryOutputs[entry] = append(entryOutputs[entry], outputPath)
+		}
+	}
+	entries := make([]string, 0, len(entryOutputs))
+	for entry, outputs := range entryOutputs {
+		entryOutputs[entry] = outputClosure(metafile, outputs)
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	// Group entrypoints that share an output, so they're rebuilt together
+	groups := map[string]string{}
+	var groupOf func(entry string) string
+	groupOf = func(entry string) string {
+		if group, exists := groups[entry]; exists && group != entry {
+			groups[entry] = groupOf(group)
+			return groups[entry]
+		}
+		return entry
+	}
+	owners := map[string]string{}
+	for _, entry := range entries {
+		groups[entry] = entry
+		for _, outputPath := range entryOutputs[entry] {
+			if owner, exists := owners[outputPath]; exists {
+				groups[groupOf(entry)] = groupOf(owner)
+			} else {
+				owners[outputPath] = entry
+			}
+		}
+	}
+
+	stale := map[string]bool{}
+	entryInputs := map[string][]string{}
+	for _, entry := range entries {
+		inputs := inputClosure(metafile, entryOutputs[entry])
+		entryInputs[entry] = inputs
+		isStale, err := outputsAreStale(entryOutputs[entry], inputs, workingDir, outdir)
+		if err != nil {
+			return IncrementalPlan{}, err
+		}
+		if isStale {
+			stale[groupOf(entry)] = true
+		}
+	}
+
+	plan := IncrementalPlan{
+		Rebuild: []string{},
+		Reuse:   []string{},
+		outputs: map[string]json.RawMessage{},
+		inputs:  map[string]json.RawMessage{},
+	}
+	for _, entrypoint := range entrypoints {
+		entry := absolutePath(entrypoint, workingDir)
+		if _, built := entryOutputs[entry]; !built || stale[groupOf(entry)] {
+			plan.Rebuild = append(plan.Rebuild, entrypoint)
+			continue
+		}
+		plan.Reuse = append(plan.Reuse, entrypoint)
+		for _, outputPath := range entryOutputs[entry] {
+			plan.outputs[outputPath] = raw.Outputs[outputPath]
+		}
+		for _, inputPath := range entryInputs[entry] {
+			if input, exists := raw.Inputs[inputPath]; exists {
+				plan.inputs[inputPath] = input
+			}
+		}
+	}
+	return plan, nil
+}
+
+func sortedOutputPaths(metafile Metafile) []string {
+	paths := make([]string, 0, len(metafile.Outputs))
+	for path := range metafile.Outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func sortedSet(values map[string]bool) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// outputClosure follows the imports of outputs to the other outputs they
+// need at runtime, returning them all sorted.
+func outputClosure(metafile Metafile, outputs []string) []string {
+	seen := map[string]bool{}
+	pending := append([]string{}, outputs...)
+	for len(pending) > 0 {
+		outputPath := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		output, exists := metafile.Outputs[outputPath]
+		if !exists || seen[outputPath] {
+			continue
+		}
+		seen[outputPath] = true
+
+		pending = append(pending, outputPath+".map")
+		if output.CSSBundle != "" {
+			pending = append(pending, output.CSSBundle)
+		}
+		for _, imported := range output.Imports {
+			if !imported.External {
+				pending = append(pending, imported.Path)
+			}
+		}
+	}
+	return sortedSet(seen)
+}
+
+// inputClosure returns every input the outputs were built from, including
+// ones tree-shaking removed entirely, since editing them can change that.
+func inputClosure(metafile Metafile, outputs []string) []string {
+	seen := map[string]bool{}
+	pending := []string{}
+	for _, outputPath := range outputs {
+		for inputPath := range metafile.Outputs[outputPath].Inputs {
+			pending = append(pending, inputPath)
+		}
+	}
+	for len(pending) > 0 {
+		inputPath := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[inputPath] {
+			continue
+		}
+		seen[inputPath] = true
+		for _, imported := range metafile.Inputs[inputPath].Imports {
+			if !imported.External {
+				pending = append(pending, imported.Path)
+			}
+		}
+	}
+	return sortedSet(seen)
+}
+
+// outputsAreStale reports whether any output is missing or outside outdir,
+// or any input is missing or was modified after the oldest output.
+func outputsAreStale(outputs []string, inputs []string, workingDir string, outdir string) (bool, error) {
+	var builtAt time.Time
+	for _, outputPath := range outputs {
+		path := absolutePath(outputPath, workingDir)
+		if relativePath, err := filepath.Rel(outdir, path); err != nil || strings.HasPrefix(relativePath, "..") {
+			return true, nil
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		if builtAt.IsZero() || info.ModTime().Before(builtAt) {
+			builtAt = info.ModTime()
+		}
+	}
+
+	for _, inputPath := range inputs {
+		path := absolutePath(inputPath, workingDir)
+		if !filepath.IsAbs(path) {
+			// Plugin namespaces and <stdin> have no file to compare
+			continue
+		}
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(builtAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ReadOutputs loads the reused outputs from disk, so they can be hashed,
+// checked against budgets, and emitted alongside the rebuilt ones.
+func (plan IncrementalPlan) ReadOutputs(workingDir string) ([]api.OutputFile, error) {
+	outputPaths := make([]string, 0, len(plan.outputs))
+	for outputPath := range plan.outputs {
+		outputPaths = append(outputPaths, outputPath)
+	}
+	sort.Strings(outputPaths)
+
+	outputFiles := make([]api.OutputFile, 0, len(outputPaths))
+	for _, outputPath := range outputPaths {
+		path := absolutePath(outputPath, workingDir)
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		outputFiles = append(outputFiles, api.OutputFile{Path: path, Contents: contents})
+	}
+	return outputFiles, nil
+}
+
+// MergeMetafile adds the reused outputs and their inputs to the metafile of
+// the entrypoints that were rebuilt, so it describes the whole build.
+func (plan IncrementalPlan) MergeMetafile(metafile string) (string, error) {
+	var merged rawMetafile
+	if err := json.Unmarshal([]byte(metafile), &merged); err != nil {
+		return "", fmt.Errorf("Invalid metafile: %s", err)
+	}
+	if merged.Inputs == nil {
+		merged.Inputs = map[string]json.RawMessage{}
+	}
+	if merged.Outputs == nil {
+		merged.Outputs = map[string]json.RawMessage{}
+	}
+	for path, input := range plan.inputs {
+		if _, exists := merged.Inputs[path]; !exists {
+			merged.Inputs[path] = input
+		}
+	}
+	for path, output := range plan.outputs {
+		merged.Outputs[path] = output
+	}
+
+	payload, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+//export BundleAllIncremental
+func BundleAllIncremental(rawOptions *C.char, incremental C.int, rawPriorMetafile *C.char) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll, but when incremental is nonzero, only the
+	 * entrypoints whose inputs changed since the build that produced
+	 * rawPriorMetafile are rebuilt. The others keep that build's outputs,
+	 * which are listed in the result as usual and their entrypoints under
+	 * "reused". See PlanIncrementalBuild for what counts as a change.
+	 *
+	 * Meant for CI that restores the outdir from a cache along with the
+	 * metafile. A NULL or empty rawPriorMetafile builds everything, as for
+	 * the first build.
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	if incremental != 0 && rawPriorMetafile != nil {
+		options.PriorMetafile = C.GoString(rawPriorMetafile)
+	}
+
+	result, err := bundleAll(options, writeBundleOutputs)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+import "C"
+
+// MetafileInputFiles returns the absolute path of every file a build read,
+// sorted. Inputs that aren't files on disk, like plugin namespaces and
+// "<runtime>", are left out.
+func MetafileInputFiles(metafile Metafile, workingDir string) []string {
+	files := make([]string, 0, len(metafile.Inputs))
+	for inputPath := range metafile.Inputs {
+		path := absolutePath(inputPath, workingDir)
+		if !filepath.IsAbs(path) {
+			continue
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func isNodeModulesPath(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == "node_modules" {
+			return true
+		}
+	}
+	return false
+}
+
+//export GetContextInputs
+func GetContextInputs(id C.int, excludeNodeModules C.int) (returnInputs *C.char, returnError *C.char) {
+	/*
+	 * Returns a JSON array of every file the context's most recent successful
+	 * rebuild read, directly or through imports, for hosts running their own
+	 * file watcher. Set excludeNodeModules to 1 to leave out dependencies,
+	 * which are rarely edited and can be huge to watch. Empty until the
+	 * context has been rebuilt.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	inputs := []string{}
+	for _, input := range context.Inputs {
+		if excludeNodeModules == 1 && isNodeModulesPath(input) {
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+
+	payload, err := json.Marshal(inputs)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FindInteropRisks flags ESM imports of CommonJS modules in node_modules,
+// where esbuild has to guess what the default and named imports map to. The
+// classic failure is a default import that resolves to undefined once the
+// dependency's __esModule handling differs between dev and minified builds.
+//
+// This is a heuristic. The metafile doesn't record which bindings an import
+// uses, so importers are re-read and matched with a regular expression, and
+// a module counts as CommonJS when esbuild detected it as such or, failing
+// that, when its package.json doesn't declare "type": "module". Side-effect
+// imports and require() calls are never flagged.
+func FindInteropRisks(metafile Metafile, workingDir string) []string {
+	importers := make([]string, 0, len(metafile.Inputs))
+	for path := range metafile.Inputs {
+		importers = append(importers, path)
+	}
+	sort.Strings(importers)
+
+	risks := []string{}
+	for _, importer := range importers {
+		input := metafile.Inputs[importer]
+		if input.Format != "esm" {
+			continue
+		}
+
+		var source string
+		for _, imported := range input.Imports {
+			if imported.Kind != "import-statement" || imported.External || imported.Original == "" {
+				continue
+			}
+			if !strings.Contains(filepath.ToSlash(imported.Path), "node_modules/") {
+				continue
+			}
+			if !isCommonJSInput(metafile.Inputs[imported.Path], filepath.Join(workingDir, imported.Path)) {
+				continue
+			}
+
+			if source == "" {
+				contents, err := os.ReadFile(filepath.Join(workingDir, importer))
+				if err != nil {
+					break
+				}
+				source = string(contents)
+			}
+
+			for _, binding := range findImportBindings(source, imported.Original) {
+				risks = append(risks, fmt.Sprintf(
+					"%s: %s import of %q, which is CommonJS (%s). Check it isn't undefined in production builds.",
+					importer, binding, imported.Original, imported.Path,
+				))
+			}
+		}
+	}
+	return risks
+}
+
+func isCommonJSInput(input MetafileInput, absolutePath string) bool {
+	switch input.Format {
+	case "cjs":
+		return true
+	case "esm":
+		return false
+	}
+
+	switch filepath.Ext(absolutePath) {
+	case ".cjs":
+		return true
+	case ".mjs":
+		return false
+	}
+
+	packageDir := findPackageDir(absolutePath)
+	if packageDir == "" {
+		return false
+	}
+	contents, err := os.ReadFile(filepath.Join(packageDir, "package.json"))
+	if err != nil {
+		return true
+	}
+	var manifest packageManifest
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		return true
+	}
+	return manifest.Type != "module"
+}
+
+var importClausePattern = regexp.MustCompile(`import\s+([\w$*{][^;'"]*?)\s+from\s*["']([^"']+)["']`)
+
+// findImportBindings returns the kinds of binding ("default" or "named")
+// that import statements in source take from specifier. Namespace imports
+// always see module.exports as-is, so they aren't reported.
+func findImportBindings(source string, specifier string) []string {
+	bindings := []string{}
+	for _, match := range importClausePattern.FindAllStringSubmatch(source, -1) {
+		if match[2] != specifier {
+			continue
+		}
+
+		clause := strings.TrimSpace(match[1])
+		// Type-only imports are erased before bundling
+		if strings.HasPrefix(clause, "type ") {
+			continue
+		}
+		if !strings.HasPrefix(clause, "{") && !strings.HasPrefix(clause, "*") {
+			bindings = append(bindings, "default")
+		}
+		if strings.Contains(clause, "{") {
+			bindings = append(bindings, "named")
+		}
+	}
+	return bindings
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ioPool bounds how many outputs BundleAll writes or compresses at once, so
+// a build with hundreds of chunks doesn't open hundreds of files on a small
+// CI runner.
+type ioPool struct {
+	slots  chan struct{}
+	active atomic.Int32
+	// Most tasks that ever ran at once, see BuildTiming.IOWorkers
+	peak atomic.Int32
+}
+
+// newIOPool makes a pool running up to concurrency tasks at once, or
+// GOMAXPROCS if concurrency isn't positive.
+func newIOPool(concurrency int) *ioPool {
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	return &ioPool{slots: make(chan struct{}, concurrency)}
+}
+
+// each calls task for every index in [0, count), running as many at once as
+// the pool allows, and returns the first error by index once all are done.
+func (pool *ioPool) each(count int, task func(index int) error) error {
+	errors := make([]error, count)
+	var wg sync.WaitGroup
+	for index := 0; index < count; index++ {
+		pool.slots <- struct{}{}
+		wg.Add(1)
+		go func(index int) {
+			defer func() {
+				pool.active.Add(-1)
+				<-pool.slots
+				wg.Done()
+			}()
+			active := pool.active.Add(1)
+			for {
+				peak := pool.peak.Load()
+				if active <= peak || pool.peak.CompareAndSwap(peak, active) {
+					break
+				}
+			}
+			errors[index] = task(index)
+		}(index)
+	}
+	wg.Wait()
+
+	for _, err := range errors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/evanw/esbuild/pkg/api"
 )
@@ -11,17 +18,123 @@ import (
 // #include <stdint.h>
 //
 // extern void rust_callback(int32_t);
+//
+// typedef void (*context_evicted_callback)(void* userData, int32_t id);
+//
+// static inline void invoke_context_evicted_callback(context_evicted_callback callback, void* userData, int32_t id) {
+//     callback(userData, id);
+// }
 import "C"
 
 var (
 	mutex    sync.Mutex
 	contexts = make(map[int]*ESBuildContext)
 	nextID   = 1
+
+	// Eviction settings, guarded by mutex. A maxContexts of 0 means no limit.
+	maxContexts      = 0
+	onContextEvicted C.context_evicted_callback
+	evictedUserData  unsafe.Pointer
 )
 
 type ESBuildContext struct {
+	ID       int
 	Filename string
 	Context  api.BuildContext
+	// Options used to create Context, retained so the context can be
+	// recreated when its build settings change
+	Options api.BuildOptions
+
+	// Held for the duration of a rebuild and while touching any of the
+	// fields above or below, so a rebuild never sees a half-swapped context
+	lock sync.Mutex
+	// Set while esbuild is rebuilding, which CancelAndRemove checks without
+	// waiting on lock
+	rebuilding atomic.Bool
+	// When set, each rebuild also keeps its outputs in memory, keyed by
+	// output path, so a dev server can serve them without touching disk
+	KeepOutputs bool
+	Outputs     map[string][]byte
+	// Report only the first error of a failed rebuild, see SetContextFailFast
+	FailFast bool
+	// Most errors a failed rebuild formats, or 0 for all of them. See
+	// SetContextMaxErrors.
+	MaxErrors int
+	// Content hashes from the last successful rebuild, and how its outputs
+	// differed from the rebuild before it
+	OutputHashes  map[string]uint64
+	OutputChanges OutputChanges
+	// Absolute paths of every file the last successful rebuild read. See
+	// GetContextInputs.
+	Inputs []string
+	// Outcome of the most recent rebuild, successful or not, or nil before
+	// the first one
+	LastResult *RebuildResult
+	// Rebuilds actually run, as opposed to requested. See requestRebuild.
+	Rebuilds int
+	// Rebuild that requests arriving now will share, if it hasn't started.
+	// Guarded by queueLock rather than lock, so requests can queue up while
+	// a rebuild holds lock.
+	queueLock sync.Mutex
+	queued    *rebuildRequest
+	// When the context was created or last rebuilt, for LRU eviction and
+	// DisposeContextsOlderThan
+	LastUsed time.Time
+	// Port of esbuild's dev server, or 0 when not serving
+	ServePort int
+	// Set once the context is removed, evicted, or expired, so a rebuild
+	// that was already waiting on lock doesn't mistake the disposed
+	// context's empty result for an internal failure and recreate it
+	disposed bool
+}
+
+// dispose releases the esbuild context for good. The caller must hold lock
+// and have already removed the context from contexts.
+func (context *ESBuildContext) dispose() {
+	context.disposed = true
+	context.Context.Dispose()
+	context.Outputs = nil
+}
+
+func getContext(id C.int) (*ESBuildContext, bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	context, exists := contexts[int(id)]
+	return context, exists
+}
+
+// ContextSpec describes the build context for a single entrypoint.
+type ContextSpec struct {
+	Filename        string
+	NodeModulesPath string
+	Environment     string
+	LiveReloadPort  int
+	// Host the live reload client connects to; "localhost" if empty
+	LiveReloadHost string
+	IsSSR          bool
+	Aliases        map[string]string
+	// esbuild target string; empty keeps esbuild's default
+	Target string
+	// "transform" or "automatic", see ParseJSX. The automatic runtime is
+	// bundled like any other import, so it also works for SSR's IIFE output.
+	JSX string
+	// Package that provides jsx-runtime for the automatic runtime, "react"
+	// if empty
+	JSXImportSource string
+	// Use the automatic runtime's development build, jsx-dev-runtime, whose
+	// jsxDEV calls carry each element's file, line, and column so React can
+	// point component stacks and warnings at the source
+	JSXDev bool
+	// Global that SSR bundles assign their exports to, "SSR" if empty. See
+	// ParseGlobalName.
+	GlobalName string
+	// Build an SSR bundle for an edge runtime like Cloudflare Workers: the
+	// neutral platform, without the browser's window standing in for global
+	Edge bool
+	// package.json "exports" conditions to resolve with. Edge bundles
+	// default to edgeConditions.
+	Conditions []string
 }
 
 //export GetBuildContext
@@ -30,118 +143,957 @@ func GetBuildContext(
 	rawNodeModulesPath *C.char,
 	rawEnvironment *C.char,
 	liveReloadPort C.int,
+	rawLiveReloadHost *C.char,
 	isSSR C.int,
+	rawAliases *C.char,
 ) (returnId C.int, returnError *C.char) {
 	/*
 	 * liveReloadPort: 0 for no live reload
+	 * rawLiveReloadHost: host the live reload client connects to, or empty
+	 *   for localhost. Set it when the browser reaches the dev server over a
+	 *   LAN or from outside a container.
+	 * rawAliases: JSON array of [from, to] module pairs, or empty for none
 	 */
+	var evicted []int
 	mutex.Lock()
-	defer mutex.Unlock()
+	defer func() {
+		mutex.Unlock()
+		notifyEvictedContexts(evicted)
+	}()
+
+	aliases, err := ParseAliases(C.GoString(rawAliases))
+	if err != nil {
+		return -1, C.CString(err.Error())
+	}
+
+	id, _, err := createBuildContext(ContextSpec{
+		Filename:        C.GoString(rawFilename),
+		NodeModulesPath: C.GoString(rawNodeModulesPath),
+		Environment:     C.GoString(rawEnvironment),
+		LiveReloadPort:  int(liveReloadPort),
+		LiveReloadHost:  C.GoString(rawLiveReloadHost),
+		IsSSR:           isSSR == 1,
+		Aliases:         aliases,
+	})
+	if err != nil {
+		// Log the error
+		fmt.Println(err)
+		return -1, C.CString(err.Error())
+	}
+
+	evicted = evictContexts(map[int]bool{id: true})
+	return C.int(id), nil
+}
+
+//export GetBuildContexts
+func GetBuildContexts(
+	rawSpecsJSON *C.char,
+	rawNodeModulesPath *C.char,
+	rawEnvironment *C.char,
+	liveReloadPort C.int,
+	rawLiveReloadHost *C.char,
+) (returnIds *C.char, returnError *C.char) {
+	/*
+	 * Creates a context per entry in a JSON array like
+	 * [{"path": "page.tsx", "isSSR": true, "target": "node18"}], so client and
+	 * SSR bundles can target different runtimes. Specs can also set "jsx" and
+	 * "jsxImportSource" to use the automatic JSX runtime, and "jsxDev" for its
+	 * development build. SSR specs can set "globalName" so several bundles
+	 * can share a JS context, and "edge" to build for an edge runtime like
+	 * Cloudflare Workers. Any spec can set "conditions", like ["edge-light",
+	 * "worker", "browser"]; see edgeConditions for the defaults. Returns a JSON array of the
+	 * context IDs in the same order. If any context fails to build, the ones
+	 * created by this call are disposed again.
+	 */
+	var rawSpecs []struct {
+		Path            string   `json:"path"`
+		IsSSR           bool     `json:"isSSR"`
+		Target          string   `json:"target"`
+		JSX             string   `json:"jsx"`
+		JSXImportSource string   `json:"jsxImportSource"`
+		JSXDev          bool     `json:"jsxDev"`
+		GlobalName      string   `json:"globalName"`
+		Edge            bool     `json:"edge"`
+		Conditions      []string `json:"conditions"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(rawSpecsJSON)), &rawSpecs); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid context specs JSON: %s", err))
+	}
+
+	// Validate every spec before creating anything
+	for _, rawSpec := range rawSpecs {
+		if _, _, err := ParseTarget(rawSpec.Target); err != nil {
+			return nil, C.CString(fmt.Sprintf("Invalid target for %s: %s", rawSpec.Path, err))
+		}
+		if _, err := ParseJSX(rawSpec.JSX); err != nil {
+			return nil, C.CString(fmt.Sprintf("Invalid jsx for %s: %s", rawSpec.Path, err))
+		}
+		if err := ValidateJSXDev(rawSpec.JSX, rawSpec.JSXDev); err != nil {
+			return nil, C.CString(fmt.Sprintf("Invalid jsxDev for %s: %s", rawSpec.Path, err))
+		}
+		if rawSpec.GlobalName != "" && !rawSpec.IsSSR {
+			return nil, C.CString(fmt.Sprintf("Invalid globalName for %s: only SSR bundles are assigned to a global", rawSpec.Path))
+		}
+		if _, err := ParseGlobalName(rawSpec.GlobalName); err != nil {
+			return nil, C.CString(fmt.Sprintf("Invalid globalName for %s: %s", rawSpec.Path, err))
+		}
+		if rawSpec.Edge && !rawSpec.IsSSR {
+			return nil, C.CString(fmt.Sprintf("Invalid edge for %s: only SSR bundles run on the edge", rawSpec.Path))
+		}
+		if _, err := ParseConditions(rawSpec.Conditions, rawSpec.Edge); err != nil {
+			return nil, C.CString(fmt.Sprintf("Invalid conditions for %s: %s", rawSpec.Path, err))
+		}
+	}
 
-	filename := C.GoString(rawFilename)
-	nodeModulesPath := C.GoString(rawNodeModulesPath)
-	environment := C.GoString(rawEnvironment)
+	var evicted []int
+	mutex.Lock()
+	defer func() {
+		mutex.Unlock()
+		notifyEvictedContexts(evicted)
+	}()
 
+	ids := make([]int, 0, len(rawSpecs))
+	createdIds := []int{}
+	for _, rawSpec := range rawSpecs {
+		id, created, err := createBuildContext(ContextSpec{
+			Filename:        rawSpec.Path,
+			NodeModulesPath: C.GoString(rawNodeModulesPath),
+			Environment:     C.GoString(rawEnvironment),
+			LiveReloadPort:  int(liveReloadPort),
+			LiveReloadHost:  C.GoString(rawLiveReloadHost),
+			IsSSR:           rawSpec.IsSSR,
+			Target:          rawSpec.Target,
+			JSX:             rawSpec.JSX,
+			JSXImportSource: rawSpec.JSXImportSource,
+			JSXDev:          rawSpec.JSXDev,
+			GlobalName:      rawSpec.GlobalName,
+			Edge:            rawSpec.Edge,
+			Conditions:      rawSpec.Conditions,
+		})
+		if err != nil {
+			for _, createdId := range createdIds {
+				contexts[createdId].Context.Dispose()
+				delete(contexts, createdId)
+			}
+			return nil, C.CString(err.Error())
+		}
+		ids = append(ids, id)
+		if created {
+			createdIds = append(createdIds, id)
+		}
+	}
+
+	requested := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		requested[id] = true
+	}
+	evicted = evictContexts(requested)
+
+	payload, err := json.Marshal(ids)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+// createBuildContext registers a context for the spec and returns its ID,
+// along with whether a new context was created. The caller must hold mutex.
+func createBuildContext(spec ContextSpec) (int, bool, error) {
 	// If we already have the filename registered, return
 	// the existing context ID.
 	for id, context := range contexts {
-		if context.Filename == filename {
-			return C.int(id), nil
+		if context.Filename == spec.Filename {
+			return id, false, nil
 		}
 	}
 
+	// Relative filenames resolve against the process's working directory
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return -1, false, err
+	}
+	if err := checkEntrypointExists(spec.Filename, workingDir); err != nil {
+		return -1, false, err
+	}
+
+	target, engines, err := ParseTarget(spec.Target)
+	if err != nil {
+		return -1, false, err
+	}
+	jsx, err := ParseJSX(spec.JSX)
+	if err != nil {
+		return -1, false, err
+	}
+	globalName, err := ParseGlobalName(spec.GlobalName)
+	if err != nil {
+		return -1, false, err
+	}
+	conditions, err := ParseConditions(spec.Conditions, spec.Edge)
+	if err != nil {
+		return -1, false, err
+	}
+	liveReloadHost := spec.LiveReloadHost
+	if liveReloadHost == "" {
+		liveReloadHost = "localhost"
+	}
+
 	buildOptions := api.BuildOptions{
-		EntryPoints: []string{filename},
+		EntryPoints: []string{spec.Filename},
 		Bundle:      true,
-		Outfile:     filename + ".out",
+		Outfile:     spec.Filename + ".out",
 		Sourcemap:   api.SourceMapExternal,
+		// For the inputs each rebuild read
+		Metafile:      true,
+		AbsWorkingDir: workingDir,
 		Loader: map[string]api.Loader{
 			".tsx": api.LoaderTSX,
 			".jsx": api.LoaderJSX,
+			".mjs": api.LoaderJS,
+			".cjs": api.LoaderJS,
 		},
+		ResolveExtensions: resolveExtensions,
 		Define: map[string]string{
-			"process.env.NODE_ENV":         fmt.Sprintf("\"%s\"", environment),
-			"process.env.LIVE_RELOAD_PORT": fmt.Sprintf("%d", liveReloadPort),
+			"process.env.NODE_ENV":         fmt.Sprintf("\"%s\"", spec.Environment),
+			"process.env.LIVE_RELOAD_PORT": fmt.Sprintf("%d", spec.LiveReloadPort),
+			"process.env.LIVE_RELOAD_HOST": fmt.Sprintf("\"%s\"", liveReloadHost),
 		},
-		NodePaths: []string{nodeModulesPath},
+		NodePaths: []string{spec.NodeModulesPath},
+		Alias:     spec.Aliases,
+		Target:    target,
+		Engines:   engines,
+		JSX:       jsx,
+		// Only read by the automatic runtime
+		JSXImportSource: spec.JSXImportSource,
+		JSXDev:          spec.JSXDev,
+		Conditions:      conditions,
+		Plugins:         []api.Plugin{ImportAttributesPlugin()},
 	}
 
-	if isSSR == 1 {
-		buildOptions.GlobalName = "SSR"
+	if spec.IsSSR {
+		buildOptions.GlobalName = globalName
 		buildOptions.Format = api.FormatIIFE
 		buildOptions.Define["process.env.SSR_RENDERING"] = "true"
-		buildOptions.Define["global"] = "window"
+		if spec.Edge {
+			// Edge runtimes have globalThis but neither window nor node's
+			// global, and no node builtins to resolve
+			buildOptions.Platform = api.PlatformNeutral
+			buildOptions.MainFields = edgeMainFields
+		} else {
+			buildOptions.Define["global"] = "window"
+		}
 	} else {
 		buildOptions.Format = api.FormatESModule
 		buildOptions.Define["process.env.SSR_RENDERING"] = "false"
 	}
 
-	ctx, err := api.Context(buildOptions)
-	if err != nil {
-		// Log the error
-		fmt.Println(err)
-		return -1, C.CString(err.Error())
+	// api.Context returns a *ContextError, which compares non-nil once it's
+	// stored in an error variable, so check it before converting
+	ctx, contextErr := api.Context(buildOptions)
+	if contextErr != nil {
+		return -1, false, contextErr
 	}
 
 	id := nextID
 	nextID++
 	contexts[id] = &ESBuildContext{
-		Filename: filename,
+		ID:       id,
+		Filename: spec.Filename,
 		Context:  ctx,
+		Options:  buildOptions,
+		LastUsed: time.Now(),
 	}
-	return C.int(id), nil
+	return id, true, nil
+}
+
+//export UpdateContextDefines
+func UpdateContextDefines(id C.int, rawDefinesJSON *C.char) (returnError *C.char) {
+	/*
+	 * Merges the given defines (a JSON object of identifier to replacement
+	 * expression) into the context's existing defines and swaps in a new esbuild
+	 * context built from the updated options. Keys that aren't provided keep
+	 * their current value. If every define already has the given value, the
+	 * existing context is kept along with its incremental state. Values can
+	 * reference other defines, given or existing, as ${NAME}.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	var defines map[string]string
+	if err := json.Unmarshal([]byte(C.GoString(rawDefinesJSON)), &defines); err != nil {
+		return C.CString(fmt.Sprintf("Invalid defines JSON: %s", err))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	if _, err := context.updateDefines(defines); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// DefineUpdateResult reports an UpdateContextDefinesAndRebuild call.
+type DefineUpdateResult struct {
+	// Whether the defines changed, which takes a new esbuild context
+	Recreated bool          `json:"recreated"`
+	Changes   OutputChanges `json:"changes"`
+	// Time spent swapping in the new context, and rebuilding with it
+	UpdateMs  float64 `json:"updateMs"`
+	RebuildMs float64 `json:"rebuildMs"`
+}
+
+//export UpdateContextDefinesAndRebuild
+func UpdateContextDefinesAndRebuild(id C.int, rawDefinesJSON *C.char) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * UpdateContextDefines followed by RebuildContext, for flipping a feature
+	 * flag during development. Returns a DefineUpdateResult as JSON.
+	 *
+	 * esbuild fixes a context's options when it's created, so there's no way
+	 * to change defines in place: a change always means a new context, whose
+	 * first rebuild parses every file again and costs about as much as the
+	 * initial build. What's saved over disposing and recreating the context
+	 * from the host is the round trip, and keeping the context's ID, outputs,
+	 * and output hashes, so Changes covers just what the flag affected.
+	 * Defines that already have the given values skip the new context, and
+	 * rebuild incrementally.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	var defines map[string]string
+	if err := json.Unmarshal([]byte(C.GoString(rawDefinesJSON)), &defines); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid defines JSON: %s", err))
+	}
+
+	updateStart := time.Now()
+	context.lock.Lock()
+	recreated, err := context.updateDefines(defines)
+	context.lock.Unlock()
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	updateDuration := time.Since(updateStart)
+
+	rebuildStart := time.Now()
+	if err := rebuildContext(context); err != nil {
+		return nil, C.CString(err.Error())
+	}
+	rebuildDuration := time.Since(rebuildStart)
+
+	context.lock.Lock()
+	changes := context.OutputChanges
+	context.lock.Unlock()
+
+	payload, err := json.Marshal(DefineUpdateResult{
+		Recreated: recreated,
+		Changes:   changes,
+		UpdateMs:  milliseconds(updateDuration),
+		RebuildMs: milliseconds(rebuildDuration),
+	})
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+// updateDefines merges defines into the context's options and swaps in a new
+// esbuild context built from them. ${NAME} references are expanded first,
+// see ExpandDefines, and can name the context's current defines. Returns
+// false without touching the context if every define already has the given
+// value, and fails if the context was removed. The caller must hold lock.
+func (context *ESBuildContext) updateDefines(defines map[string]string) (bool, error) {
+	// A context removed while the caller waited for lock must not get a new
+	// esbuild context, since nothing would dispose of it
+	if context.disposed {
+		return false, fmt.Errorf("Context %d was removed", context.ID)
+	}
+	defines, err := ExpandDefines(defines, context.Options.Define)
+	if err != nil {
+		return false, err
+	}
+
+	changed := false
+	for key, value := range defines {
+		if current, exists := context.Options.Define[key]; !exists || current != value {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	// Copy the define map so a failed context creation leaves the stored
+	// options untouched
+	buildOptions := context.Options
+	buildOptions.Define = make(map[string]string, len(context.Options.Define)+len(defines))
+	for key, value := range context.Options.Define {
+		buildOptions.Define[key] = value
+	}
+	for key, value := range defines {
+		buildOptions.Define[key] = value
+	}
+
+	// api.Context returns a *ContextError, see createBuildContext
+	ctx, contextErr := api.Context(buildOptions)
+	if contextErr != nil {
+		return false, contextErr
+	}
+
+	// Dispose of the previous context only once its replacement is ready.
+	// This also stops its dev server, if any.
+	context.Context.Dispose()
+	context.Context = ctx
+	context.Options = buildOptions
+	context.ServePort = 0
+	return true, nil
+}
+
+//export StartServe
+func StartServe(id C.int, port C.int) (returnPort C.int, returnError *C.char) {
+	/*
+	 * Starts esbuild's dev server for the context on 127.0.0.1 and returns the
+	 * port it bound, for the host to proxy to. With a port of 0, esbuild picks
+	 * the first free port from 8000 up.
+	 *
+	 * The server builds on demand: a request rebuilds first if any input has
+	 * changed, then serves outputs from memory without writing them. It
+	 * shares incremental state with RebuildContext, which keeps writing to
+	 * disk as before. esbuild runs one build per context at a time, so the
+	 * two never overlap.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return 0, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	if context.ServePort != 0 {
+		return 0, C.CString(fmt.Sprintf("Context with ID %d is already serving on port %d", id, context.ServePort))
+	}
+
+	result, err := context.Context.Serve(api.ServeOptions{
+		Host: "127.0.0.1",
+		Port: uint16(port),
+	})
+	if err != nil {
+		return 0, C.CString(err.Error())
+	}
+
+	context.ServePort = int(result.Port)
+	return C.int(result.Port), nil
+}
+
+//export StopServe
+func StopServe(id C.int) (returnError *C.char) {
+	/*
+	 * Stops the context's dev server. esbuild can only stop serving by
+	 * disposing the context, so the context is recreated from its options;
+	 * the next rebuild starts from a cold cache.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	// Disposing the context already stopped its server, and recreating it
+	// would leave an esbuild context nothing disposes of
+	if context.disposed {
+		return C.CString(fmt.Sprintf("Context %d was removed", id))
+	}
+	if context.ServePort == 0 {
+		return nil
+	}
+
+	ctx, err := api.Context(context.Options)
+	if err != nil {
+		return C.CString(err.Error())
+	}
+
+	context.Context.Dispose()
+	context.Context = ctx
+	context.ServePort = 0
+	return nil
 }
 
 //export RebuildContext
 func RebuildContext(id C.int) (returnError *C.char) {
-	mutex.Lock()
-
-	context, exists := contexts[int(id)]
+	/*
+	 * Calls made while the context is already rebuilding are coalesced into
+	 * a single follow-up rebuild. See requestRebuild.
+	 */
+	context, exists := getContext(id)
 	if !exists {
 		fmt.Printf("Context with ID %d does not exist\n", id)
-		mutex.Unlock()
 		return
 	}
+
+	if _, err := context.requestRebuild(); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+//export RebuildEntrypoints
+func RebuildEntrypoints(rawPaths **C.char, count C.int) (returnError *C.char) {
+	/*
+	 * Rebuilds only the contexts for the given entrypoint paths, so a change
+	 * that touches one page doesn't pay for rebuilding every other page.
+	 * Each context already holds a single entrypoint, so these are
+	 * independent esbuild contexts that rebuild in parallel, each once even
+	 * if its path is listed twice. Every path must belong to an existing
+	 * context; otherwise nothing is rebuilt. Rebuilds go through
+	 * requestRebuild, so they coalesce with any already queued. Errors from
+	 * individual rebuilds are joined with a blank line between them.
+	 */
+	paths := unsafe.Slice(rawPaths, int(count))
+
+	mutex.Lock()
+	contextsByFilename := make(map[string][]*ESBuildContext, len(contexts))
+	for _, context := range contexts {
+		contextsByFilename[context.Filename] = append(contextsByFilename[context.Filename], context)
+	}
 	mutex.Unlock()
 
-	result := context.Context.Rebuild()
-	if len(result.Errors) > 0 {
-		errorString := fmt.Sprintf("Error rebuilding %s:\n\n", context.Filename)
-		for _, err := range result.Errors {
-			errorString += ParseErrorLocation(err.Location)
-			errorString += fmt.Sprintf("%s\n\n", err.Text)
+	selected := make([]*ESBuildContext, 0, len(paths))
+	seen := map[int]bool{}
+	for _, rawPath := range paths {
+		path := C.GoString(rawPath)
+		matches, exists := contextsByFilename[path]
+		if !exists {
+			return C.CString(fmt.Sprintf("No context for entrypoint %s", path))
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+		for _, context := range matches {
+			if !seen[context.ID] {
+				seen[context.ID] = true
+				selected = append(selected, context)
+			}
 		}
+	}
+
+	if errorString := joinRebuildErrors(rebuildContexts(selected)); errorString != "" {
 		return C.CString(errorString)
 	}
+	return nil
+}
+
+// rebuildContexts rebuilds the contexts in parallel through requestRebuild,
+// returning each one's error at its index in selected.
+func rebuildContexts(selected []*ESBuildContext) []error {
+	errors := make([]error, len(selected))
+	var wg sync.WaitGroup
+	for index, context := range selected {
+		wg.Add(1)
+		go func(index int, context *ESBuildContext) {
+			defer wg.Done()
+			_, errors[index] = context.requestRebuild()
+		}(index, context)
+	}
+	wg.Wait()
+	return errors
+}
 
-	for i := range result.OutputFiles {
-		outputFile := result.OutputFiles[i]
-		// Write the output to a file
-		err := os.WriteFile(outputFile.Path, outputFile.Contents, 0644)
+// joinRebuildErrors joins the messages of the non-nil errors with a blank
+// line between them, or returns "" if there are none.
+func joinRebuildErrors(errors []error) string {
+	messages := []string{}
+	for _, err := range errors {
 		if err != nil {
-			// Log the error
-			fmt.Println(err)
-			return C.CString(err.Error())
+			messages = append(messages, strings.TrimRight(err.Error(), "\n"))
+		}
+	}
+	return strings.Join(messages, "\n\n")
+}
+
+func rebuildContext(context *ESBuildContext) error {
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	return context.rebuildLocked()
+}
+
+// rebuildLocked runs one rebuild and records its outcome. The caller must
+// hold lock.
+func (context *ESBuildContext) rebuildLocked() error {
+	// Removed while this rebuild waited for lock, after which esbuild only
+	// returns an empty result
+	if context.disposed {
+		return fmt.Errorf("Context %d was removed", context.ID)
+	}
+	context.Rebuilds++
+	context.LastUsed = time.Now()
+	context.rebuilding.Store(true)
+	result, recreated := context.rebuildWithRecovery()
+	context.rebuilding.Store(false)
+	context.LastResult = NewRebuildResult(result, nil)
+	context.LastResult.Recreated = recreated
+	if len(result.Errors) > 0 {
+		header := fmt.Sprintf("Error rebuilding %s:\n\n", context.Filename)
+		limit := context.MaxErrors
+		if context.FailFast {
+			limit = 1
+		}
+		errors, omitted := TruncateBuildErrors(result.Errors, limit)
+		errors = ExplainMissingPeerErrors(ExplainTopLevelAwaitErrors(errors), context.Options.AbsWorkingDir)
+		return fmt.Errorf("%s%s", FormatBuildErrors(header, errors), omittedErrorsNote(omitted))
+	}
+
+	if err := WriteOutputFiles(result.OutputFiles); err != nil {
+		// Log the error
+		fmt.Println(err)
+		context.LastResult = NewRebuildResult(result, err)
+		context.LastResult.Recreated = recreated
+		return err
+	}
+
+	metafile, err := ParseMetafile(result.Metafile)
+	if err != nil {
+		return err
+	}
+	context.Inputs = MetafileInputFiles(metafile, context.Options.AbsWorkingDir)
+
+	outputHashes := HashOutputContents(result.OutputFiles)
+	context.OutputChanges = DiffOutputHashes(context.OutputHashes, outputHashes)
+	context.OutputHashes = outputHashes
+
+	if context.KeepOutputs {
+		context.Outputs = make(map[string][]byte, len(result.OutputFiles))
+		for _, outputFile := range result.OutputFiles {
+			context.Outputs[outputFile.Path] = outputFile.Contents
 		}
 	}
 
 	return nil
 }
 
+//export SetContextKeepOutputs
+func SetContextKeepOutputs(id C.int, keepOutputs C.int) (returnError *C.char) {
+	/*
+	 * Toggles in-memory output retention for subsequent rebuilds. Disabling it
+	 * also releases any outputs that are currently held.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	context.KeepOutputs = keepOutputs == 1
+	if !context.KeepOutputs {
+		context.Outputs = nil
+	}
+	return nil
+}
+
+//export SetContextFailFast
+func SetContextFailFast(id C.int, failFast C.int) (returnError *C.char) {
+	/*
+	 * When enabled, a failed rebuild returns only its first error, with a
+	 * count of the rest, instead of formatting every one. A build with
+	 * hundreds of errors is usually one broken import, and formatting them
+	 * all is slow and buries it. esbuild still finds every error, and
+	 * GetLastResult still lists them all.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	context.FailFast = failFast == 1
+	return nil
+}
+
+//export SetContextMaxErrors
+func SetContextMaxErrors(id C.int, maxErrors C.int) (returnError *C.char) {
+	/*
+	 * Caps how many errors a failed rebuild formats, adding a count of the
+	 * rest, so a cascade of thousands of errors doesn't flood the host. 0
+	 * formats every error. FailFast, when enabled, caps it at 1 regardless.
+	 * Like there, GetLastResult still lists every error. Only the
+	 * formatting is capped: contexts don't log, so esbuild's own LogLimit
+	 * would have nothing to limit.
+	 */
+	if maxErrors < 0 {
+		return C.CString("maxErrors can't be negative")
+	}
+	context, exists := getContext(id)
+	if !exists {
+		return C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	context.MaxErrors = int(maxErrors)
+	return nil
+}
+
+//export GetContextOutput
+func GetContextOutput(id C.int, rawPath *C.char) (returnContents unsafe.Pointer, returnLength C.int, returnError *C.char) {
+	/*
+	 * Returns a copy of an output from the most recent rebuild. Outputs can be
+	 * binary, so the contents aren't NUL-terminated; the caller owns the
+	 * returned buffer and must free() it.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return nil, 0, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	path := C.GoString(rawPath)
+	contents, exists := context.Outputs[path]
+	if !exists {
+		return nil, 0, C.CString(fmt.Sprintf("No in-memory output for %s", path))
+	}
+	return C.CBytes(contents), C.int(len(contents)), nil
+}
+
 //export RemoveContext
-func RemoveContext(id C.int) {
+func RemoveContext(id C.int) (returnRemoved C.int) {
+	/*
+	 * Returns 1 if the context was removed, 0 if it didn't exist. Removing an
+	 * unknown or already removed ID is a no-op so hosts can safely double-remove
+	 * during cleanup.
+	 */
 	mutex.Lock()
-	defer mutex.Unlock()
+	context, exists := contexts[int(id)]
+	if !exists {
+		mutex.Unlock()
+		return 0
+	}
+	delete(contexts, int(id))
+	mutex.Unlock()
+
+	// Dispose of the ESBuild context to free up resources, waiting for any
+	// in-flight rebuild to finish first
+	context.lock.Lock()
+	defer context.lock.Unlock()
 
-	// Dispose of the ESBuild context to free up resources
+	context.dispose()
+	return 1
+}
+
+//export CancelAndRemove
+func CancelAndRemove(id C.int) (returnInterrupted C.int, returnError *C.char) {
+	/*
+	 * Like RemoveContext, but cancels an in-flight rebuild instead of waiting
+	 * for it to finish, for when its entrypoint was deleted or is about to
+	 * change again. Returns 1 if a rebuild was interrupted, which then fails
+	 * with esbuild's cancellation error, and 0 if the context was idle.
+	 */
+	mutex.Lock()
 	context, exists := contexts[int(id)]
 	if !exists {
-		fmt.Printf("Context with ID %d does not exist\n", id)
+		mutex.Unlock()
+		return 0, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+	delete(contexts, int(id))
+	mutex.Unlock()
+
+	// Cancel returns once the rebuild has stopped, and is a no-op when none
+	// is running
+	interrupted := context.rebuilding.Load()
+	context.Context.Cancel()
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	context.dispose()
+	if interrupted {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+//export SetMaxContexts
+func SetMaxContexts(maxCount C.int, callback C.context_evicted_callback, userData unsafe.Pointer) {
+	/*
+	 * Caps how many contexts stay alive. Whenever a new context pushes the
+	 * count over maxCount, the least recently rebuilt contexts are disposed
+	 * until it fits again. 0 removes the cap. Lowering the cap evicts
+	 * immediately.
+	 *
+	 * callback (optional) is called with the ID of each evicted context, once
+	 * it has been disposed, so the host can recreate it on demand. It's called
+	 * on the thread that triggered the eviction after all locks are released,
+	 * so it may call back into any export. userData is passed through.
+	 *
+	 * The cap is soft: contexts that are mid-rebuild, or that were requested
+	 * by the call that triggered the eviction, are never evicted.
+	 */
+	var evicted []int
+	mutex.Lock()
+	defer func() {
+		mutex.Unlock()
+		notifyEvictedContexts(evicted)
+	}()
+
+	maxContexts = int(maxCount)
+	if maxContexts < 0 {
+		maxContexts = 0
+	}
+	onContextEvicted = callback
+	evictedUserData = userData
+
+	evicted = evictContexts(nil)
+}
+
+// evictContexts disposes the least recently used contexts until there are
+// no more than maxContexts, skipping the protected IDs and any context that
+// is busy rebuilding. Returns the evicted IDs. The caller must hold mutex.
+func evictContexts(protected map[int]bool) []int {
+	if maxContexts == 0 || len(contexts) <= maxContexts {
+		return nil
+	}
+
+	type candidate struct {
+		id       int
+		lastUsed time.Time
+	}
+	candidates := []candidate{}
+	for id, context := range contexts {
+		if protected[id] || !context.lock.TryLock() {
+			continue
+		}
+		candidates = append(candidates, candidate{id, context.LastUsed})
+		context.lock.Unlock()
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].lastUsed.Equal(candidates[j].lastUsed) {
+			return candidates[i].id < candidates[j].id
+		}
+		return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+	})
+
+	evicted := []int{}
+	for _, candidate := range candidates {
+		if len(contexts) <= maxContexts {
+			break
+		}
+		context := contexts[candidate.id]
+		// A rebuild may have started since the context was inspected
+		if !context.lock.TryLock() {
+			continue
+		}
+		delete(contexts, candidate.id)
+		context.dispose()
+		context.lock.Unlock()
+		evicted = append(evicted, candidate.id)
+	}
+	return evicted
+}
+
+// notifyEvictedContexts reports evicted IDs to the host. Call it without
+// holding mutex, since the host may call straight back in.
+func notifyEvictedContexts(evicted []int) {
+	if len(evicted) == 0 {
 		return
 	}
 
-	context.Context.Dispose()
-	delete(contexts, int(id))
+	mutex.Lock()
+	callback, userData := onContextEvicted, evictedUserData
+	mutex.Unlock()
+
+	if callback == nil {
+		return
+	}
+	for _, id := range evicted {
+		C.invoke_context_evicted_callback(callback, userData, C.int32_t(id))
+	}
+}
+
+func FormatBuildErrors(header string, errors []api.Message) string {
+	errorString := header
+	for _, err := range errors {
+		// Some errors (like a missing entrypoint) aren't tied to a file
+		if err.Location != nil {
+			errorString += ParseErrorLocation(err.Location)
+		}
+		errorString += fmt.Sprintf("%s\n\n", err.Text)
+	}
+	return errorString
+}
+
+// TruncateBuildErrors keeps the first limit errors, or all of them if limit
+// is 0, and returns how many were left out.
+func TruncateBuildErrors(errors []api.Message, limit int) ([]api.Message, int) {
+	if limit <= 0 || len(errors) <= limit {
+		return errors, 0
+	}
+	return errors[:limit], len(errors) - limit
+}
+
+// omittedErrorsNote ends formatted errors that TruncateBuildErrors cut short.
+func omittedErrorsNote(omitted int) string {
+	if omitted == 0 {
+		return ""
+	}
+	return fmt.Sprintf("(%d more errors not shown)\n", omitted)
+}
+
+// FormatColorBuildErrors formats errors with esbuild's own formatter instead,
+// which adds ANSI colors and the offending line of code, for hosts that
+// print straight to a terminal.
+func FormatColorBuildErrors(header string, errors []api.Message) string {
+	formatted := api.FormatMessages(errors, api.FormatMessagesOptions{Kind: api.ErrorMessage, Color: true})
+	return header + strings.Join(formatted, "")
+}
+
+func WriteOutputFiles(outputFiles []api.OutputFile) error {
+	for i := range outputFiles {
+		outputFile := outputFiles[i]
+		// Split chunks and assets can be nested below the output directory
+		if err := os.MkdirAll(filepath.Dir(outputFile.Path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(outputFile.Path, outputFile.Contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes contents to a temporary file next to path and
+// renames it into place, so a dev server reading path mid-write sees either
+// the previous file or the complete new one, never a truncated one. An
+// interrupted write leaves the previous file untouched.
+func writeFileAtomic(path string, contents []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
+
+	if _, err := file.Write(contents); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	// CreateTemp only grants the owner access
+	if err := os.Chmod(tempPath, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
 }
 
 func ParseErrorLocation(loc *api.Location) string {
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// RebuildResult is what a context keeps from its most recent rebuild, for
+// hosts that poll for build errors instead of taking them from the rebuild
+// call.
+type RebuildResult struct {
+	Success bool `json:"success"`
+	// esbuild's messages, formatted like FormatBuildWarnings. A failure to
+	// write the outputs is reported as an error too.
+	Errors   []string  `json:"errors"`
+	Warnings []string  `json:"warnings"`
+	Time     time.Time `json:"timestamp"`
+	// Whether the context had to be recreated after an internal esbuild
+	// error. See SetRecreateOnInternalError.
+	Recreated bool `json:"recreated"`
+}
+
+// NewRebuildResult summarizes result, and writeErr if the outputs couldn't
+// be written.
+func NewRebuildResult(result api.BuildResult, writeErr error) *RebuildResult {
+	errors := FormatBuildWarnings(result.Errors)
+	if writeErr != nil {
+		errors = append(errors, writeErr.Error())
+	}
+	return &RebuildResult{
+		Success:  len(errors) == 0,
+		Errors:   errors,
+		Warnings: FormatBuildWarnings(result.Warnings),
+		Time:     time.Now(),
+	}
+}
+
+//export GetLastResult
+func GetLastResult(id C.int) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Returns the RebuildResult of the context's most recent rebuild as JSON,
+	 * without rebuilding, or null if it hasn't been rebuilt yet. Waits for a
+	 * rebuild in progress to finish.
+	 */
+	context, exists := getContext(id)
+	if !exists {
+		return nil, C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+	}
+
+	context.lock.Lock()
+	defer context.lock.Unlock()
+
+	payload, err := json.Marshal(context.LastResult)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
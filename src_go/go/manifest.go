@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// BuildManifest maps each entrypoint to what it produced. Paths are relative
+// to the build's working directory, the same as the metafile's.
+type BuildManifest struct {
+	Entries []ManifestEntry `json:"entries"`
+	// Output path to the chunks it loads through import(), for preloading
+	// route-level splits. Outputs without dynamic imports are left out.
+	DynamicImports map[string][]string `json:"dynamicImports"`
+	// Source file to output path for assets emitted by the "file" and
+	// "copy" loaders
+	Assets map[string]string `json:"assets"`
+	// Output path to its Subresource Integrity value, like "sha384-...", for
+	// the integrity attribute of the tag that loads it. Only populated when
+	// the build asks for it. See IntegrityDigests.
+	Integrity map[string]string `json:"integrity,omitempty"`
+	// Outputs holding code from the build's vendor packages, for preloading
+	// or caching them separately. Only populated when the build lists some.
+	// See VendorOutputs.
+	Vendor []string `json:"vendor,omitempty"`
+}
+
+type ManifestEntry struct {
+	Source string `json:"source"`
+	Output string `json:"output"`
+	// Stylesheet bundled from the entry's CSS imports, if any
+	CSS string `json:"css,omitempty"`
+	// For TypeScript sources, where a declaration file belongs so it sits
+	// alongside Output. esbuild only strips types, so producing it is left
+	// to a separate `tsc --emitDeclarationOnly` step; this is just the path
+	// to copy or point "types" at.
+	Declaration string `json:"declaration,omitempty"`
+}
+
+var typeScriptExtensions = map[string]bool{
+	".ts":  true,
+	".tsx": true,
+	".mts": true,
+	".cts": true,
+}
+
+// Outputs esbuild generates itself, as opposed to copied assets
+var codeExtensions = map[string]bool{
+	".js":  true,
+	".mjs": true,
+	".cjs": true,
+	".css": true,
+	".map": true,
+}
+
+var declarationExtensions = map[string]string{
+	".js":  ".d.ts",
+	".mjs": ".d.mts",
+	".cjs": ".d.cts",
+}
+
+// BuildManifestFromMetafile collects the entrypoint outputs of a build and
+// the dynamic imports between its outputs. Chunks created for import() calls
+// are entrypoints as far as esbuild is concerned, so they're listed too.
+// loaders is the build's extension to loader map, used to spot assets with a
+// code extension, like a pre-built ".worker.js" passed through with "copy".
+func BuildManifestFromMetafile(metafile Metafile, loaders map[string]api.Loader) BuildManifest {
+	manifest := BuildManifest{
+		Entries:        []ManifestEntry{},
+		DynamicImports: map[string][]string{},
+		Assets:         map[string]string{},
+	}
+	for outputPath, output := range metafile.Outputs {
+		for _, imported := range output.Imports {
+			if imported.Kind == "dynamic-import" && !imported.External {
+				manifest.DynamicImports[outputPath] = append(manifest.DynamicImports[outputPath], imported.Path)
+			}
+		}
+		sort.Strings(manifest.DynamicImports[outputPath])
+
+		if output.EntryPoint == "" {
+			if !codeExtensions[filepath.Ext(outputPath)] || isCopiedOutput(output, loaders) {
+				// Assets come from exactly one input
+				for inputPath := range output.Inputs {
+					manifest.Assets[inputPath] = outputPath
+				}
+			}
+			continue
+		}
+
+		entry := ManifestEntry{
+			Source: output.EntryPoint,
+			Output: outputPath,
+			CSS:    output.CSSBundle,
+		}
+		if typeScriptExtensions[filepath.Ext(output.EntryPoint)] {
+			extension := filepath.Ext(outputPath)
+			if declarationExtension, exists := declarationExtensions[extension]; exists {
+				entry.Declaration = strings.TrimSuffix(outputPath, extension) + declarationExtension
+			}
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Output < manifest.Entries[j].Output
+	})
+	return manifest
+}
+
+// isCopiedOutput reports whether the output is an input emitted verbatim by
+// the "copy" or "file" loader rather than code esbuild generated.
+func isCopiedOutput(output MetafileOutput, loaders map[string]api.Loader) bool {
+	if len(output.Inputs) != 1 {
+		return false
+	}
+	for inputPath := range output.Inputs {
+		// Like esbuild, the longest matching extension picks the loader
+		base := filepath.Base(inputPath)
+		for index := strings.IndexByte(base, '.'); index != -1; index = strings.IndexByte(base, '.') {
+			if loader, exists := loaders[base[index:]]; exists {
+				return loader == api.LoaderCopy || loader == api.LoaderFile
+			}
+			base = base[index+1:]
+		}
+	}
+	return false
+}
+
+// Absolutize resolves the manifest's paths against workingDir, matching
+// AbsolutizeMetafile.
+func (manifest *BuildManifest) Absolutize(workingDir string) {
+	for index := range manifest.Entries {
+		entry := &manifest.Entries[index]
+		entry.Source = absolutePath(entry.Source, workingDir)
+		entry.Output = absolutePath(entry.Output, workingDir)
+		entry.CSS = absolutePath(entry.CSS, workingDir)
+		entry.Declaration = absolutePath(entry.Declaration, workingDir)
+	}
+
+	dynamicImports := make(map[string][]string, len(manifest.DynamicImports))
+	for outputPath, chunks := range manifest.DynamicImports {
+		absoluteChunks := make([]string, len(chunks))
+		for index, chunk := range chunks {
+			absoluteChunks[index] = absolutePath(chunk, workingDir)
+		}
+		dynamicImports[absolutePath(outputPath, workingDir)] = absoluteChunks
+	}
+	manifest.DynamicImports = dynamicImports
+
+	assets := make(map[string]string, len(manifest.Assets))
+	for source, output := range manifest.Assets {
+		assets[absolutePath(source, workingDir)] = absolutePath(output, workingDir)
+	}
+	manifest.Assets = assets
+
+	for index, outputPath := range manifest.Vendor {
+		manifest.Vendor[index] = absolutePath(outputPath, workingDir)
+	}
+
+	if manifest.Integrity != nil {
+		integrity := make(map[string]string, len(manifest.Integrity))
+		for outputPath, digest := range manifest.Integrity {
+			integrity[absolutePath(outputPath, workingDir)] = digest
+		}
+		manifest.Integrity = integrity
+	}
+}
+
+// IntegrityDigests returns the sha384 Subresource Integrity value of every
+// output, keyed by its path relative to workingDir like the rest of the
+// manifest. Digests cover the final contents as written to disk, after any
+// CSS restyling, so they match the served file even though esbuild computed
+// hashed filenames before that.
+func IntegrityDigests(outputFiles []api.OutputFile, workingDir string) (map[string]string, error) {
+	digests := make(map[string]string, len(outputFiles))
+	for _, outputFile := range outputFiles {
+		outputPath, err := filepath.Rel(workingDir, outputFile.Path)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha512.Sum384(outputFile.Contents)
+		digests[filepath.ToSlash(outputPath)] = "sha384-" + base64.StdEncoding.EncodeToString(digest[:])
+	}
+	return digests, nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+import "C"
+
+// Metafile mirrors the subset of esbuild's metafile schema that we analyze.
+// https://esbuild.github.io/api/#metafile
+type Metafile struct {
+	Inputs  map[string]MetafileInput  `json:"inputs"`
+	Outputs map[string]MetafileOutput `json:"outputs"`
+}
+
+type MetafileImport struct {
+	Path     string `json:"path"`
+	Kind     string `json:"kind"`
+	External bool   `json:"external"`
+	Original string `json:"original"`
+}
+
+type MetafileInput struct {
+	Bytes   int              `json:"bytes"`
+	Imports []MetafileImport `json:"imports"`
+	Format  string           `json:"format"`
+}
+
+type MetafileOutputInput struct {
+	BytesInOutput int `json:"bytesInOutput"`
+}
+
+type MetafileOutput struct {
+	Bytes      int                            `json:"bytes"`
+	Inputs     map[string]MetafileOutputInput `json:"inputs"`
+	Imports    []MetafileImport               `json:"imports"`
+	Exports    []string                       `json:"exports"`
+	EntryPoint string                         `json:"entryPoint"`
+	CSSBundle  string                         `json:"cssBundle"`
+}
+
+func ParseMetafile(rawMetafile string) (Metafile, error) {
+	var metafile Metafile
+	if err := json.Unmarshal([]byte(rawMetafile), &metafile); err != nil {
+		return Metafile{}, fmt.Errorf("Invalid metafile: %s", err)
+	}
+	return metafile, nil
+}
+
+var namespacedPathPattern = regexp.MustCompile(`^[\w-]+:`)
+
+// absolutePath resolves a metafile path against workingDir. Paths from
+// plugin namespaces (like "svg-component:icon.svg") and "<stdin>" aren't
+// files, so they're left alone.
+func absolutePath(path string, workingDir string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "<") || namespacedPathPattern.MatchString(path) {
+		return path
+	}
+	return filepath.Join(workingDir, filepath.FromSlash(path))
+}
+
+// AbsolutizeMetafile rewrites every file path in a metafile to be absolute,
+// for consumers that don't know the build's working directory. Newer esbuild
+// versions can do this themselves; the embedded one can't, so the paths are
+// rewritten here. Fields this code doesn't know about are kept as-is.
+func AbsolutizeMetafile(rawMetafile string, workingDir string) (json.RawMessage, error) {
+	var metafile struct {
+		Inputs  map[string]map[string]json.RawMessage `json:"inputs"`
+		Outputs map[string]map[string]json.RawMessage `json:"outputs"`
+	}
+	if err := json.Unmarshal([]byte(rawMetafile), &metafile); err != nil {
+		return nil, fmt.Errorf("Invalid metafile: %s", err)
+	}
+
+	absolutizeImports := func(fields map[string]json.RawMessage) error {
+		if fields["imports"] == nil {
+			return nil
+		}
+		var imports []map[string]json.RawMessage
+		if err := json.Unmarshal(fields["imports"], &imports); err != nil {
+			return err
+		}
+		for _, imported := range imports {
+			var path string
+			var external bool
+			json.Unmarshal(imported["path"], &path)
+			json.Unmarshal(imported["external"], &external)
+			if external {
+				continue
+			}
+			imported["path"], _ = json.Marshal(absolutePath(path, workingDir))
+		}
+		var err error
+		fields["imports"], err = json.Marshal(imports)
+		return err
+	}
+
+	inputs := make(map[string]map[string]json.RawMessage, len(metafile.Inputs))
+	for path, fields := range metafile.Inputs {
+		if err := absolutizeImports(fields); err != nil {
+			return nil, err
+		}
+		inputs[absolutePath(path, workingDir)] = fields
+	}
+
+	outputs := make(map[string]map[string]json.RawMessage, len(metafile.Outputs))
+	for path, fields := range metafile.Outputs {
+		if err := absolutizeImports(fields); err != nil {
+			return nil, err
+		}
+
+		if fields["inputs"] != nil {
+			var outputInputs map[string]json.RawMessage
+			if err := json.Unmarshal(fields["inputs"], &outputInputs); err != nil {
+				return nil, err
+			}
+			absoluteInputs := make(map[string]json.RawMessage, len(outputInputs))
+			for inputPath, inputFields := range outputInputs {
+				absoluteInputs[absolutePath(inputPath, workingDir)] = inputFields
+			}
+			fields["inputs"], _ = json.Marshal(absoluteInputs)
+		}
+
+		for _, key := range []string{"entryPoint", "cssBundle"} {
+			if fields[key] == nil {
+				continue
+			}
+			var value string
+			if err := json.Unmarshal(fields[key], &value); err != nil {
+				return nil, err
+			}
+			fields[key], _ = json.Marshal(absolutePath(value, workingDir))
+		}
+
+		outputs[absolutePath(path, workingDir)] = fields
+	}
+
+	return json.Marshal(map[string]any{
+		"inputs":  inputs,
+		"outputs": outputs,
+	})
+}
+
+// UnusedCodeReport lists candidates for deletion. esbuild doesn't report
+// usage per export, so both fields are heuristics:
+//   - EliminatedInputs are source files that were parsed but contributed no
+//     bytes to any output. This includes modules that were fully tree-shaken
+//     and files that only contain types.
+//   - UnusedExports are the exports of entrypoint outputs that no other
+//     output imports. esbuild always keeps every export of an entrypoint, so
+//     these are only unused from the perspective of the bundle itself; the
+//     host may still consume them at runtime.
+type UnusedCodeReport struct {
+	EliminatedInputs []string            `json:"eliminatedInputs"`
+	UnusedExports    map[string][]string `json:"unusedExports"`
+}
+
+//export AnalyzeUnusedCode
+func AnalyzeUnusedCode(rawMetafile *C.char) (returnReport *C.char, returnError *C.char) {
+	metafile, err := ParseMetafile(C.GoString(rawMetafile))
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(analyzeUnusedCode(metafile))
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+func analyzeUnusedCode(metafile Metafile) UnusedCodeReport {
+	bytesInOutputs := make(map[string]int)
+	importedOutputs := make(map[string]bool)
+	for _, output := range metafile.Outputs {
+		for inputPath, input := range output.Inputs {
+			bytesInOutputs[inputPath] += input.BytesInOutput
+		}
+		for _, imported := range output.Imports {
+			importedOutputs[imported.Path] = true
+		}
+	}
+
+	report := UnusedCodeReport{
+		EliminatedInputs: []string{},
+		UnusedExports:    make(map[string][]string),
+	}
+
+	for inputPath := range metafile.Inputs {
+		if bytesInOutputs[inputPath] == 0 {
+			report.EliminatedInputs = append(report.EliminatedInputs, inputPath)
+		}
+	}
+	sort.Strings(report.EliminatedInputs)
+
+	for outputPath, output := range metafile.Outputs {
+		if output.EntryPoint == "" || len(output.Exports) == 0 || importedOutputs[outputPath] {
+			continue
+		}
+		exports := append([]string{}, output.Exports...)
+		sort.Strings(exports)
+		report.UnusedExports[output.EntryPoint] = exports
+	}
+
+	return report
+}
+
+// FindImportCycles walks the metafile's input import graph and returns each
+// distinct cycle as the chain of files involved, starting from its
+// lexicographically smallest path. Not every cycle is a bug: cycles are
+// only harmful when a module reads an import during evaluation, before the
+// other side of the cycle has finished initializing.
+func FindImportCycles(metafile Metafile) [][]string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	inputPaths := make([]string, 0, len(metafile.Inputs))
+	for inputPath := range metafile.Inputs {
+		inputPaths = append(inputPaths, inputPath)
+	}
+	sort.Strings(inputPaths)
+
+	state := make(map[string]int, len(inputPaths))
+	stack := []string{}
+	seenCycles := make(map[string]bool)
+	cycles := [][]string{}
+
+	var visit func(inputPath string)
+	visit = func(inputPath string) {
+		state[inputPath] = visiting
+		stack = append(stack, inputPath)
+
+		for _, imported := range metafile.Inputs[inputPath].Imports {
+			if imported.External {
+				continue
+			}
+			if _, exists := metafile.Inputs[imported.Path]; !exists {
+				continue
+			}
+
+			switch state[imported.Path] {
+			case unvisited:
+				visit(imported.Path)
+			case visiting:
+				// A back edge closes a cycle through everything on the stack
+				// since the imported file was entered
+				start := len(stack) - 1
+				for stack[start] != imported.Path {
+					start--
+				}
+				cycle := canonicalCycle(stack[start:])
+				key := strings.Join(cycle, "\x00")
+				if !seenCycles[key] {
+					seenCycles[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[inputPath] = visited
+	}
+
+	for _, inputPath := range inputPaths {
+		if state[inputPath] == unvisited {
+			visit(inputPath)
+		}
+	}
+	return cycles
+}
+
+// canonicalCycle rotates a cycle to start at its smallest path so the same
+// cycle found from different entry files compares equal.
+func canonicalCycle(cycle []string) []string {
+	smallest := 0
+	for i := range cycle {
+		if cycle[i] < cycle[smallest] {
+			smallest = i
+		}
+	}
+	return append(append([]string{}, cycle[smallest:]...), cycle[:smallest]...)
+}
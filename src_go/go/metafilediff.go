@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+import "C"
+
+// MetafileDiff compares the outputs and inputs of two builds.
+type MetafileDiff struct {
+	// Every output present in either build, by logical name. See
+	// logicalOutputNames.
+	Outputs []OutputSizeDelta `json:"outputs"`
+	// Sum of the output deltas
+	TotalDelta    int      `json:"totalDelta"`
+	AddedInputs   []string `json:"addedInputs"`
+	RemovedInputs []string `json:"removedInputs"`
+}
+
+type OutputSizeDelta struct {
+	Name          string `json:"name"`
+	PreviousBytes int    `json:"previousBytes"`
+	CurrentBytes  int    `json:"currentBytes"`
+	Delta         int    `json:"delta"`
+	// "added", "removed", "grown", "shrunk", or "unchanged"
+	Status string `json:"status"`
+}
+
+// esbuild's [hash] placeholder, 8 characters of uppercase base32
+var outputHashPattern = regexp.MustCompile(`-[A-Z2-7]{8}(\.|$)`)
+
+// logicalOutputNames names each output in a way that survives content
+// hashes changing between builds:
+//   - entrypoint outputs by their entrypoint plus extension, like
+//     "src/page.tsx.js"
+//   - stylesheets bundled for an entrypoint as that entrypoint plus ".css"
+//   - sourcemaps as the name of the file they map plus ".map"
+//   - everything else, like shared chunks and assets, by their path with the
+//     hash removed. Shared chunks all become "chunk.js", so their sizes are
+//     summed.
+func logicalOutputNames(metafile Metafile) map[string]string {
+	names := make(map[string]string, len(metafile.Outputs))
+	for outputPath, output := range metafile.Outputs {
+		if output.EntryPoint == "" {
+			continue
+		}
+		names[outputPath] = output.EntryPoint + path.Ext(outputPath)
+		if output.CSSBundle != "" {
+			names[output.CSSBundle] = output.EntryPoint + ".css"
+		}
+	}
+	for outputPath := range metafile.Outputs {
+		if _, exists := names[outputPath]; exists || strings.HasSuffix(outputPath, ".map") {
+			continue
+		}
+		names[outputPath] = outputHashPattern.ReplaceAllString(outputPath, "$1")
+	}
+	for outputPath := range metafile.Outputs {
+		if mappedPath, isMap := strings.CutSuffix(outputPath, ".map"); isMap {
+			if mappedName, exists := names[mappedPath]; exists {
+				names[outputPath] = mappedName + ".map"
+			} else {
+				names[outputPath] = outputHashPattern.ReplaceAllString(outputPath, "$1")
+			}
+		}
+	}
+	return names
+}
+
+func outputSizesByName(metafile Metafile) map[string]int {
+	names := logicalOutputNames(metafile)
+	sizes := make(map[string]int, len(names))
+	for outputPath, output := range metafile.Outputs {
+		sizes[names[outputPath]] += output.Bytes
+	}
+	return sizes
+}
+
+// DiffMetafiles reports how output sizes and the set of inputs changed from
+// previous to current. Outputs are sorted by the size of their change,
+// largest first.
+func DiffMetafiles(previous Metafile, current Metafile) MetafileDiff {
+	previousSizes := outputSizesByName(previous)
+	currentSizes := outputSizesByName(current)
+
+	diff := MetafileDiff{
+		Outputs:       []OutputSizeDelta{},
+		AddedInputs:   []string{},
+		RemovedInputs: []string{},
+	}
+
+	names := make(map[string]bool, len(previousSizes)+len(currentSizes))
+	for name := range previousSizes {
+		names[name] = true
+	}
+	for name := range currentSizes {
+		names[name] = true
+	}
+	for name := range names {
+		previousBytes, inPrevious := previousSizes[name]
+		currentBytes, inCurrent := currentSizes[name]
+		delta := OutputSizeDelta{
+			Name:          name,
+			PreviousBytes: previousBytes,
+			CurrentBytes:  currentBytes,
+			Delta:         currentBytes - previousBytes,
+		}
+		switch {
+		case !inPrevious:
+			delta.Status = "added"
+		case !inCurrent:
+			delta.Status = "removed"
+		case delta.Delta > 0:
+			delta.Status = "grown"
+		case delta.Delta < 0:
+			delta.Status = "shrunk"
+		default:
+			delta.Status = "unchanged"
+		}
+		diff.Outputs = append(diff.Outputs, delta)
+		diff.TotalDelta += delta.Delta
+	}
+	sort.Slice(diff.Outputs, func(i, j int) bool {
+		left, right := diff.Outputs[i], diff.Outputs[j]
+		if abs(left.Delta) != abs(right.Delta) {
+			return abs(left.Delta) > abs(right.Delta)
+		}
+		return left.Name < right.Name
+	})
+
+	for inputPath := range current.Inputs {
+		if _, exists := previous.Inputs[inputPath]; !exists {
+			diff.AddedInputs = append(diff.AddedInputs, inputPath)
+		}
+	}
+	for inputPath := range previous.Inputs {
+		if _, exists := current.Inputs[inputPath]; !exists {
+			diff.RemovedInputs = append(diff.RemovedInputs, inputPath)
+		}
+	}
+	sort.Strings(diff.AddedInputs)
+	sort.Strings(diff.RemovedInputs)
+	return diff
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+//export DiffBuildMetafiles
+func DiffBuildMetafiles(rawPrevious *C.char, rawCurrent *C.char) (returnDiff *C.char, returnError *C.char) {
+	/*
+	 * Compares the metafiles of two BundleAll results and returns a
+	 * MetafileDiff as JSON, for reporting size changes like "+12KB" in CI.
+	 * Both metafiles need to come from builds with the same working
+	 * directory (or both with absMetafilePaths) for their paths to line up.
+	 */
+	previous, err := ParseMetafile(C.GoString(rawPrevious))
+	if err != nil {
+		return nil, C.CString(fmt.Sprintf("Previous metafile: %s", err))
+	}
+	current, err := ParseMetafile(C.GoString(rawCurrent))
+	if err != nil {
+		return nil, C.CString(fmt.Sprintf("Current metafile: %s", err))
+	}
+
+	payload, err := json.Marshal(DiffMetafiles(previous, current))
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+import "C"
+
+//export BundleMultiFormat
+func BundleMultiFormat(rawOptions *C.char, rawFormats *C.char) (returnResults *C.char, returnError *C.char) {
+	/*
+	 * Builds the same options once per format in rawFormats (a JSON array
+	 * like ["esm", "cjs"]), writing each to a subdirectory of the outdir named
+	 * after the format. Returns a JSON object of format to its BundleResult,
+	 * each with a manifest.
+	 *
+	 * esbuild has no way to share parsing between builds with different
+	 * formats, so this costs the same as separate BundleAll calls. It saves
+	 * the host from juggling per-format options and output directories.
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+
+	var formats []string
+	if err := json.Unmarshal([]byte(C.GoString(rawFormats)), &formats); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid formats JSON: %s", err))
+	}
+
+	results, err := bundleMultiFormat(options, formats)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(results)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+func bundleMultiFormat(options BundleOptions, formats []string) (map[string]BundleResult, error) {
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("No formats provided")
+	}
+	if options.Outfile != "" {
+		return nil, fmt.Errorf("Multi-format builds need an outdir to put each format in")
+	}
+
+	// Validate every format before building anything
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		// ParseFormat treats an empty string as ESM, which would collide
+		// with "esm" itself
+		if format == "" {
+			return nil, fmt.Errorf("Empty format name")
+		}
+		if _, err := ParseFormat(format); err != nil {
+			return nil, err
+		}
+		if seen[format] {
+			return nil, fmt.Errorf("Format %q is listed more than once", format)
+		}
+		seen[format] = true
+	}
+
+	results := make(map[string]BundleResult, len(formats))
+	for _, format := range formats {
+		formatOptions := options
+		formatOptions.Format = format
+		formatOptions.Outdir = filepath.Join(options.Outdir, format)
+		formatOptions.EmitManifest = true
+
+		result, err := bundleAll(formatOptions, writeBundleOutputs)
+		if err != nil {
+			return nil, fmt.Errorf("Error building %s: %s", format, err)
+		}
+		results[format] = result
+	}
+	return results, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const nodeBuiltinsNamespace = "node-builtin"
+
+// NodeBuiltinsPlugin handles "node:" imports, which browser builds otherwise
+// fail to resolve, with one of two strategies:
+//   - "external" leaves them as imports for a node runtime to provide
+//   - "empty" replaces them with an empty CommonJS module, so code that only
+//     touches them on the server still bundles for the client. Named imports
+//     come out undefined rather than failing the build.
+func NodeBuiltinsPlugin(strategy string) (api.Plugin, error) {
+	if strategy != "external" && strategy != "empty" {
+		return api.Plugin{}, fmt.Errorf("Unknown nodeBuiltins %q: expected \"external\" or \"empty\"", strategy)
+	}
+
+	return api.Plugin{
+		Name: "node-builtins",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(
+				api.OnResolveOptions{Filter: `^node:`},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					if strategy == "external" {
+						return api.OnResolveResult{Path: args.Path, External: true}, nil
+					}
+					return api.OnResolveResult{Path: args.Path, Namespace: nodeBuiltinsNamespace}, nil
+				},
+			)
+
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: nodeBuiltinsNamespace},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					contents := "module.exports = {};"
+					return api.OnLoadResult{Contents: &contents, Loader: api.LoaderJS}, nil
+				},
+			)
+		},
+	}, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const noticesFilename = "NOTICES.txt"
+
+var licenseFilenames = []string{
+	"LICENSE",
+	"LICENSE.md",
+	"LICENSE.txt",
+	"LICENCE",
+	"LICENCE.md",
+	"LICENCE.txt",
+	"license",
+	"license.md",
+	"license.txt",
+}
+
+type PackageNotice struct {
+	Name        string
+	Version     string
+	License     string
+	LicenseText string
+}
+
+type packageManifest struct {
+	Name             string            `json:"name"`
+	Version          string            `json:"version"`
+	License          json.RawMessage   `json:"license"`
+	Type             string            `json:"type"`
+	PeerDependencies map[string]string `json:"peerDependencies"`
+}
+
+// WriteNotices aggregates the licenses of every node_modules package that
+// contributed an input to the build and writes them to the output directory.
+// Metafile paths are relative to workingDir.
+func WriteNotices(metafile Metafile, workingDir string, outdir string) (string, error) {
+	notices, err := CollectNotices(metafile, workingDir)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	builder.WriteString("Third-party notices for bundled dependencies\n")
+	for _, notice := range notices {
+		builder.WriteString("\n")
+		builder.WriteString(strings.Repeat("=", 80))
+		builder.WriteString("\n" + notice.Name)
+		if notice.Version != "" {
+			builder.WriteString("@" + notice.Version)
+		}
+		builder.WriteString(fmt.Sprintf("\nLicense: %s\n", notice.License))
+		if notice.LicenseText != "" {
+			builder.WriteString("\n")
+			builder.WriteString(strings.TrimSpace(notice.LicenseText))
+			builder.WriteString("\n")
+		}
+	}
+
+	noticesPath := filepath.Join(outdir, noticesFilename)
+	if err := writeFileAtomic(noticesPath, []byte(builder.String())); err != nil {
+		return "", err
+	}
+	return noticesPath, nil
+}
+
+func CollectNotices(metafile Metafile, workingDir string) ([]PackageNotice, error) {
+	packageDirs := make(map[string]bool)
+	for inputPath := range metafile.Inputs {
+		packageDir := findPackageDir(filepath.Join(workingDir, inputPath))
+		if packageDir != "" {
+			packageDirs[packageDir] = true
+		}
+	}
+
+	// Multiple directories can hold the same package version when the
+	// dependency tree isn't fully deduplicated
+	seen := make(map[string]bool)
+	notices := []PackageNotice{}
+	for packageDir := range packageDirs {
+		notice, err := readPackageNotice(packageDir)
+		if err != nil {
+			return nil, err
+		}
+		key := notice.Name + "@" + notice.Version
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		notices = append(notices, notice)
+	}
+
+	sort.Slice(notices, func(i, j int) bool {
+		if notices[i].Name == notices[j].Name {
+			return notices[i].Version < notices[j].Version
+		}
+		return notices[i].Name < notices[j].Name
+	})
+	return notices, nil
+}
+
+func findPackageDir(absolutePath string) string {
+	// Only third-party code needs a notice. Use the package root directly
+	// below the innermost node_modules, since packages can contain nested
+	// package.json files that only declare a module type.
+	slashPath := filepath.ToSlash(absolutePath)
+	index := strings.LastIndex(slashPath, "/node_modules/")
+	if index == -1 {
+		return ""
+	}
+	packageRoot := slashPath[:index+len("/node_modules/")]
+	segments := strings.Split(slashPath[len(packageRoot):], "/")
+	if len(segments) < 2 {
+		return ""
+	}
+	packageRoot += segments[0]
+	if strings.HasPrefix(segments[0], "@") && len(segments) > 2 {
+		packageRoot += "/" + segments[1]
+	}
+	return filepath.FromSlash(packageRoot)
+}
+
+func readPackageNotice(packageDir string) (PackageNotice, error) {
+	var manifest packageManifest
+	contents, err := os.ReadFile(filepath.Join(packageDir, "package.json"))
+	if err == nil {
+		if err := json.Unmarshal(contents, &manifest); err != nil {
+			return PackageNotice{}, fmt.Errorf("Invalid package.json in %s: %s", packageDir, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return PackageNotice{}, err
+	}
+
+	notice := PackageNotice{
+		Name:    manifest.Name,
+		Version: manifest.Version,
+		License: parseLicenseField(manifest.License),
+	}
+	if notice.Name == "" {
+		notice.Name = filepath.Base(packageDir)
+	}
+
+	for _, filename := range licenseFilenames {
+		licenseText, err := os.ReadFile(filepath.Join(packageDir, filename))
+		if err == nil {
+			notice.LicenseText = string(licenseText)
+			break
+		}
+	}
+
+	if notice.License == "" && notice.LicenseText == "" {
+		notice.License = "unknown"
+	} else if notice.License == "" {
+		notice.License = "see license text"
+	}
+	return notice, nil
+}
+
+func parseLicenseField(rawLicense json.RawMessage) string {
+	if len(rawLicense) == 0 {
+		return ""
+	}
+
+	var license string
+	if err := json.Unmarshal(rawLicense, &license); err == nil {
+		return license
+	}
+
+	// Older packages use the deprecated {"type": "MIT", "url": "..."} form
+	var legacyLicense struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(rawLicense, &legacyLicense); err == nil {
+		return legacyLicense.Type
+	}
+	return ""
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// ParseAliases parses a JSON array of [from, to] pairs into esbuild's alias
+// map. An empty string means no aliases. Mapping the same module to two
+// different replacements is rejected rather than letting the last one win.
+func ParseAliases(rawAliases string) (map[string]string, error) {
+	if rawAliases == "" {
+		return nil, nil
+	}
+
+	var pairs [][2]string
+	if err := json.Unmarshal([]byte(rawAliases), &pairs); err != nil {
+		return nil, fmt.Errorf("Invalid aliases JSON: %s", err)
+	}
+	return ValidateAliases(pairs)
+}
+
+func ValidateAliases(pairs [][2]string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	aliases := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		from, to := pair[0], pair[1]
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("Invalid alias %q -> %q: both sides are required", from, to)
+		}
+		if existing, exists := aliases[from]; exists && existing != to {
+			return nil, fmt.Errorf("Conflicting aliases for %q: %q and %q", from, existing, to)
+		}
+		aliases[from] = to
+	}
+	return aliases, nil
+}
+
+// esbuild's default resolve extensions plus .mjs and .cjs, which packages
+// increasingly ship. esbuild already picks the module format from these
+// extensions, so .mjs is always ESM and .cjs always CommonJS.
+var resolveExtensions = []string{".tsx", ".ts", ".jsx", ".js", ".mjs", ".cjs", ".css", ".json"}
+
+var loadersByName = map[string]api.Loader{
+	"base64":     api.LoaderBase64,
+	"binary":     api.LoaderBinary,
+	"copy":       api.LoaderCopy,
+	"css":        api.LoaderCSS,
+	"dataurl":    api.LoaderDataURL,
+	"default":    api.LoaderDefault,
+	"empty":      api.LoaderEmpty,
+	"file":       api.LoaderFile,
+	"global-css": api.LoaderGlobalCSS,
+	"js":         api.LoaderJS,
+	"json":       api.LoaderJSON,
+	"jsx":        api.LoaderJSX,
+	"local-css":  api.LoaderLocalCSS,
+	"text":       api.LoaderText,
+	"ts":         api.LoaderTS,
+	"tsx":        api.LoaderTSX,
+}
+
+// ParseLoader maps one of esbuild's loader names to its api.Loader.
+func ParseLoader(name string) (api.Loader, error) {
+	loader, exists := loadersByName[name]
+	if !exists {
+		return api.LoaderNone, fmt.Errorf("Unknown loader %q", name)
+	}
+	return loader, nil
+}
+
+// Ways to load .wasm imports: emitted with its URL as the import ("file"),
+// inlined as a Uint8Array ("binary"), or passed through verbatim for a
+// runtime that imports wasm itself ("copy").
+var wasmLoadersByName = map[string]api.Loader{
+	"binary": api.LoaderBinary,
+	"copy":   api.LoaderCopy,
+	"file":   api.LoaderFile,
+}
+
+// ParseWasmLoader maps a .wasm handling strategy to its api.Loader.
+func ParseWasmLoader(name string) (api.Loader, error) {
+	loader, exists := wasmLoadersByName[name]
+	if !exists {
+		return api.LoaderNone, fmt.Errorf("Unknown wasm loader %q: expected \"file\", \"binary\", or \"copy\"", name)
+	}
+	return loader, nil
+}
+
+var formatsByName = map[string]api.Format{
+	"esm":  api.FormatESModule,
+	"cjs":  api.FormatCommonJS,
+	"iife": api.FormatIIFE,
+}
+
+// ParseFormat maps "esm", "cjs", or "iife" to its api.Format. An empty
+// string means ESM.
+func ParseFormat(name string) (api.Format, error) {
+	if name == "" {
+		return api.FormatESModule, nil
+	}
+	format, exists := formatsByName[name]
+	if !exists {
+		return api.FormatDefault, fmt.Errorf("Unknown format %q: expected \"esm\", \"cjs\", or \"iife\"", name)
+	}
+	return format, nil
+}
+
+var charsetsByName = map[string]api.Charset{
+	"ascii": api.CharsetASCII,
+	"utf8":  api.CharsetUTF8,
+}
+
+// ParseCharset maps "ascii" (escape everything outside ASCII, esbuild's
+// default) or "utf8" (emit it as-is) to its api.Charset. An empty string
+// means "ascii".
+func ParseCharset(name string) (api.Charset, error) {
+	if name == "" {
+		return api.CharsetASCII, nil
+	}
+	charset, exists := charsetsByName[name]
+	if !exists {
+		return api.CharsetDefault, fmt.Errorf("Unknown charset %q: expected \"ascii\" or \"utf8\"", name)
+	}
+	return charset, nil
+}
+
+var jsxModesByName = map[string]api.JSX{
+	"transform": api.JSXTransform,
+	"automatic": api.JSXAutomatic,
+}
+
+// ParseJSX maps "transform" (React.createElement calls, the default) or
+// "automatic" (imports from "<jsxImportSource>/jsx-runtime") to esbuild's
+// JSX mode. An empty string means "transform".
+func ParseJSX(name string) (api.JSX, error) {
+	if name == "" {
+		return api.JSXTransform, nil
+	}
+	mode, exists := jsxModesByName[name]
+	if !exists {
+		return api.JSXTransform, fmt.Errorf("Unknown JSX mode %q: expected \"transform\" or \"automatic\"", name)
+	}
+	return mode, nil
+}
+
+// ValidateJSXDev checks that jsxDev is only set along with the automatic
+// runtime, since it's what imports "<jsxImportSource>/jsx-dev-runtime".
+func ValidateJSXDev(jsx string, jsxDev bool) error {
+	if jsxDev && jsx != "automatic" {
+		return fmt.Errorf("jsxDev needs the automatic JSX runtime, set jsx to \"automatic\"")
+	}
+	return nil
+}
+
+var namePlaceholderPattern = regexp.MustCompile(`\[[^\]]*\]`)
+
+var namePlaceholders = map[string]bool{
+	"[dir]":  true,
+	"[name]": true,
+	"[hash]": true,
+	"[ext]":  true,
+}
+
+// ValidateNameTemplate checks an entryNames, chunkNames, or assetNames
+// template. esbuild writes unknown placeholders into the filename as-is, so
+// a typo like "[nme]" would otherwise quietly name every output the same.
+// Templates are relative to the outdir, so absolute ones are rejected too.
+func ValidateNameTemplate(option string, template string) error {
+	for _, placeholder := range namePlaceholderPattern.FindAllString(template, -1) {
+		if !namePlaceholders[placeholder] {
+			return fmt.Errorf("Invalid %s %q: unknown placeholder %s, expected [dir], [name], [hash], or [ext]", option, template, placeholder)
+		}
+	}
+	if strings.HasPrefix(template, "/") {
+		return fmt.Errorf("Invalid %s %q: templates are relative to the outdir", option, template)
+	}
+	return nil
+}
+
+// A JavaScript identifier, or a dotted path of them like "App.pages.home"
+var globalNamePattern = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*)*$`)
+
+// ParseGlobalName returns the global an SSR bundle assigns its exports to,
+// "SSR" if name is empty. Hosts loading several SSR bundles into one JS
+// context give each its own name so they don't overwrite each other.
+func ParseGlobalName(name string) (string, error) {
+	if name == "" {
+		return "SSR", nil
+	}
+	if !globalNamePattern.MatchString(name) {
+		return "", fmt.Errorf("Invalid global name %q: expected an identifier like \"SSRPage\" or a dotted path like \"SSR.page\"", name)
+	}
+	return name, nil
+}
+
+// Conditions for edge SSR bundles when a spec doesn't list its own. These
+// are what Cloudflare's wrangler resolves with: "workerd" and "worker" pick
+// a package's Workers build where it ships one, and "browser" falls back to
+// code that only relies on web APIs like fetch and Web Crypto. Other edge
+// runtimes have their own keys to put first, like "edge-light" for Vercel
+// or "deno" for Deno Deploy.
+var edgeConditions = []string{"workerd", "worker", "browser"}
+
+// The neutral platform reads no package.json main fields by default, which
+// would leave most packages unresolvable
+var edgeMainFields = []string{"browser", "module", "main"}
+
+// ParseConditions checks the package.json "exports" conditions a context
+// resolves with, falling back to edgeConditions for edge bundles. esbuild
+// always adds "default", "import" or "require", and the platform's own
+// condition where it has one, so those don't need listing.
+func ParseConditions(conditions []string, edge bool) ([]string, error) {
+	for _, condition := range conditions {
+		if strings.TrimSpace(condition) == "" {
+			return nil, fmt.Errorf("Invalid conditions: condition names can't be empty")
+		}
+	}
+	if conditions == nil && edge {
+		return edgeConditions, nil
+	}
+	return conditions, nil
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+// ParseBannerFooter checks that a banner or footer is keyed by output type,
+// "js" or "css", and normalizes its line endings to LF. Text pasted from a
+// Windows editor or read from a CRLF file would otherwise leave the outputs
+// with mixed line endings, since esbuild prints everything else with LF.
+func ParseBannerFooter(name string, texts map[string]string) (map[string]string, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]string, len(texts))
+	for _, outputType := range sortedKeys(texts) {
+		if outputType != "js" && outputType != "css" {
+			return nil, fmt.Errorf("Invalid %s type %q: expected \"js\" or \"css\"", name, outputType)
+		}
+		normalized[outputType] = normalizeLineEndings(texts[outputType])
+	}
+	return normalized, nil
+}
+
+func normalizeLineEndings(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+}
+
+// ValidateAutoInit checks that autoInit names an export, and that there's a
+// global to call it through.
+func ValidateAutoInit(autoInit string, globalName string) error {
+	if autoInit == "" {
+		return nil
+	}
+	if globalName == "" {
+		return fmt.Errorf("autoInit needs a globalName to call %s through", autoInit)
+	}
+	if !identifierPattern.MatchString(autoInit) {
+		return fmt.Errorf("Invalid autoInit %q: expected the name of an exported function, like \"init\"", autoInit)
+	}
+	return nil
+}
+
+// ValidateSourceRoot checks that a sourcemap sourceRoot is a URL or path
+// prefix, like "/static/src/" or "https://cdn.example.com/src/", that devtools
+// can prepend to each source. Queries and fragments would end up in the
+// middle of the joined URL, so they're rejected.
+func ValidateSourceRoot(sourceRoot string) error {
+	if strings.ContainsAny(sourceRoot, " \t\r\n\\") {
+		return fmt.Errorf("Invalid sourceRoot %q: whitespace and backslashes aren't allowed", sourceRoot)
+	}
+	if _, err := url.Parse(sourceRoot); err != nil {
+		return fmt.Errorf("Invalid sourceRoot %q: %s", sourceRoot, err)
+	}
+	if strings.ContainsAny(sourceRoot, "?#") {
+		return fmt.Errorf("Invalid sourceRoot %q: queries and fragments aren't allowed", sourceRoot)
+	}
+	return nil
+}
+
+var logLevelsByName = map[string]api.LogLevel{
+	"verbose": api.LogLevelVerbose,
+	"debug":   api.LogLevelDebug,
+	"info":    api.LogLevelInfo,
+	"warning": api.LogLevelWarning,
+	"error":   api.LogLevelError,
+	"silent":  api.LogLevelSilent,
+}
+
+// ParseLogOverrides validates a map of esbuild message IDs (like
+// "this-is-undefined-in-esm") to log level names and converts it for
+// esbuild's LogOverride.
+func ParseLogOverrides(rawOverrides map[string]string) (map[string]api.LogLevel, error) {
+	if len(rawOverrides) == 0 {
+		return nil, nil
+	}
+
+	overrides := make(map[string]api.LogLevel, len(rawOverrides))
+	for messageID, levelName := range rawOverrides {
+		level, exists := logLevelsByName[levelName]
+		if !exists {
+			return nil, fmt.Errorf("Invalid log level %q for %q: expected one of verbose, debug, info, warning, error, or silent", levelName, messageID)
+		}
+		overrides[messageID] = level
+	}
+	return overrides, nil
+}
+
+var targetsByName = map[string]api.Target{
+	"esnext": api.ESNext,
+	"es5":    api.ES5,
+	"es2015": api.ES2015,
+	"es2016": api.ES2016,
+	"es2017": api.ES2017,
+	"es2018": api.ES2018,
+	"es2019": api.ES2019,
+	"es2020": api.ES2020,
+	"es2021": api.ES2021,
+	"es2022": api.ES2022,
+}
+
+var enginesByName = map[string]api.EngineName{
+	"chrome":  api.EngineChrome,
+	"deno":    api.EngineDeno,
+	"edge":    api.EngineEdge,
+	"firefox": api.EngineFirefox,
+	"hermes":  api.EngineHermes,
+	"ie":      api.EngineIE,
+	"ios":     api.EngineIOS,
+	"node":    api.EngineNode,
+	"opera":   api.EngineOpera,
+	"rhino":   api.EngineRhino,
+	"safari":  api.EngineSafari,
+}
+
+// ParseTarget parses an esbuild target string like "es2020" or
+// "chrome100,safari15" into the language target and engine list. An empty
+// string leaves both at esbuild's defaults.
+func ParseTarget(rawTarget string) (api.Target, []api.Engine, error) {
+	target := api.DefaultTarget
+	engines := []api.Engine{}
+
+	for _, part := range strings.Split(rawTarget, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		if languageTarget, exists := targetsByName[part]; exists {
+			target = languageTarget
+			continue
+		}
+
+		// Engines are a name immediately followed by a version, like "node18.2"
+		versionStart := strings.IndexAny(part, "0123456789")
+		if versionStart <= 0 {
+			return target, nil, fmt.Errorf("Invalid target %q", part)
+		}
+		engineName, exists := enginesByName[part[:versionStart]]
+		if !exists {
+			return target, nil, fmt.Errorf("Unknown target engine %q", part[:versionStart])
+		}
+		engines = append(engines, api.Engine{Name: engineName, Version: part[versionStart:]})
+	}
+
+	return target, engines, nil
+}
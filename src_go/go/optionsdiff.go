@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+import "C"
+
+// OptionDifference is a BundleAll option whose effective value differs
+// between two sets of options. Object options like defines are compared key
+// by key and reported as "defines.API_URL", with null for a side that
+// doesn't set the key.
+type OptionDifference struct {
+	Option string          `json:"option"`
+	A      json.RawMessage `json:"a"`
+	B      json.RawMessage `json:"b"`
+}
+
+// DiffBundleOptions compares two sets of options the way BundleAll would see
+// them, after ResolveBundleOptions merges the config file and applies the
+// production preset, so an option that's only spelled differently doesn't
+// count. Returns the differences sorted by option name. Secret-looking
+// defines are redacted, as in DescribeBuildOptions.
+func DiffBundleOptions(a BundleOptions, b BundleOptions) ([]OptionDifference, error) {
+	fieldsA, err := resolvedOptionFields(a)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving the first options: %s", err)
+	}
+	fieldsB, err := resolvedOptionFields(b)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving the second options: %s", err)
+	}
+
+	differences := []OptionDifference{}
+	for option, valueA := range fieldsA {
+		valueB := fieldsB[option]
+		if bytes.Equal(valueA, valueB) {
+			continue
+		}
+
+		var objectA, objectB map[string]json.RawMessage
+		if json.Unmarshal(valueA, &objectA) != nil || json.Unmarshal(valueB, &objectB) != nil {
+			differences = append(differences, OptionDifference{option, valueA, valueB})
+			continue
+		}
+		for key := range mergeKeys(objectA, objectB) {
+			keyA, keyB := objectA[key], objectB[key]
+			if bytes.Equal(keyA, keyB) {
+				continue
+			}
+			if option == "defines" && secretDefinePattern.MatchString(key) {
+				keyA, keyB = redactOptionValue(keyA), redactOptionValue(keyB)
+			}
+			differences = append(differences, OptionDifference{option + "." + key, nullIfMissing(keyA), nullIfMissing(keyB)})
+		}
+	}
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Option < differences[j].Option
+	})
+	return differences, nil
+}
+
+// resolvedOptionFields resolves options and splits their JSON into fields,
+// each re-encoded so equal values have equal bytes.
+func resolvedOptionFields(options BundleOptions) (map[string]json.RawMessage, error) {
+	options, _, err := ResolveBundleOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func mergeKeys(a map[string]json.RawMessage, b map[string]json.RawMessage) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
+
+func redactOptionValue(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return nil
+	}
+	return json.RawMessage(redactedDefine)
+}
+
+func nullIfMissing(value json.RawMessage) json.RawMessage {
+	if value == nil {
+		return json.RawMessage("null")
+	}
+	return value
+}
+
+//export DiffOptions
+func DiffOptions(rawOptionsA *C.char, rawOptionsB *C.char) (returnDifferences *C.char, returnError *C.char) {
+	/*
+	 * Compares two BundleAll options JSON blobs and returns a JSON array of
+	 * {"option", "a", "b"} for every option whose effective value differs,
+	 * for explaining why two builds' outputs differ. See DiffBundleOptions.
+	 */
+	var optionsA, optionsB BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptionsA)), &optionsA); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	if err := json.Unmarshal([]byte(C.GoString(rawOptionsB)), &optionsB); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+
+	differences, err := DiffBundleOptions(optionsA, optionsB)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(differences)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+var unresolvedImportPattern = regexp.MustCompile(`^Could not resolve "([^"]+)"`)
+
+// ExplainMissingPeerErrors adds guidance to esbuild's errors for a bare
+// import from inside node_modules that couldn't be resolved, when the
+// importing package lists it in its peerDependencies. That means the app is
+// expected to install it, which esbuild's error, pointing deep into the
+// dependency, doesn't say. File paths in errors are relative to workingDir.
+// Other errors are returned as is.
+func ExplainMissingPeerErrors(errors []api.Message, workingDir string) []api.Message {
+	explained := make([]api.Message, len(errors))
+	for index, message := range errors {
+		explained[index] = message
+		match := unresolvedImportPattern.FindStringSubmatch(message.Text)
+		if match == nil || message.Location == nil || strings.HasPrefix(match[1], ".") || filepath.IsAbs(match[1]) {
+			continue
+		}
+
+		importerPath := message.Location.File
+		if !filepath.IsAbs(importerPath) {
+			importerPath = filepath.Join(workingDir, importerPath)
+		}
+		packageDir := findPackageDir(importerPath)
+		if packageDir == "" {
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(packageDir, "package.json"))
+		if err != nil {
+			continue
+		}
+		var manifest packageManifest
+		if err := json.Unmarshal(contents, &manifest); err != nil {
+			continue
+		}
+
+		peer := packageRootName(match[1])
+		versionRange, exists := manifest.PeerDependencies[peer]
+		if !exists {
+			continue
+		}
+		if manifest.Name == "" {
+			manifest.Name = filepath.Base(packageDir)
+		}
+		explained[index].Text += fmt.Sprintf(
+			". Note: package %s requires peer %s@%s, which is not installed; add it to the app's dependencies",
+			manifest.Name, peer, versionRange,
+		)
+	}
+	return explained
+}
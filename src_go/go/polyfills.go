@@ -0,0 +1,211 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const polyfillsNamespace = "polyfill"
+
+// polyfill is a runtime API that esbuild can't lower, since it's a missing
+// global rather than syntax, along with the first version of each engine
+// that ships it.
+type polyfill struct {
+	// Language target that every engine supporting it had reached when it
+	// shipped, used when the target names no engines
+	target   api.Target
+	engines  map[api.EngineName]string
+	contents string
+}
+
+// Each polyfill only installs itself when the global is missing, so
+// including one the runtime turns out to have is harmless. They're written
+// in ES5, since the oldest targets are the ones that need them, and esbuild
+// can't lower const, let, or arrow functions to ES5.
+var polyfills = map[string]polyfill{
+	"structuredClone": {
+		target: api.ES2022,
+		engines: map[api.EngineName]string{
+			api.EngineChrome:  "98",
+			api.EngineDeno:    "1.14",
+			api.EngineEdge:    "98",
+			api.EngineFirefox: "94",
+			api.EngineIOS:     "15.4",
+			api.EngineNode:    "17",
+			api.EngineOpera:   "84",
+			api.EngineSafari:  "15.4",
+		},
+		contents: `(function (root) {
+  if (typeof root.structuredClone === "function") return;
+  root.structuredClone = function (value) {
+    // Pairs of originals and their copies, so cycles are copied as cycles.
+    // Arrays rather than a Map, which ES5 runtimes lack.
+    var originals = [];
+    var copies = [];
+    var remember = function (original, copy) {
+      originals.push(original);
+      copies.push(copy);
+      return copy;
+    };
+    var clone = function (value) {
+      if (typeof value !== "object" || value === null) return value;
+      var index = originals.indexOf(value);
+      if (index !== -1) return copies[index];
+      if (value instanceof Date) return new Date(value.getTime());
+      if (value instanceof RegExp) return new RegExp(value);
+      if (typeof Map === "function" && value instanceof Map) {
+        var map = remember(value, new Map());
+        value.forEach(function (entry, key) {
+          map.set(clone(key), clone(entry));
+        });
+        return map;
+      }
+      if (typeof Set === "function" && value instanceof Set) {
+        var set = remember(value, new Set());
+        value.forEach(function (entry) {
+          set.add(clone(entry));
+        });
+        return set;
+      }
+      var copy = remember(value, Array.isArray(value) ? [] : {});
+      var keys = Object.keys(value);
+      for (var i = 0; i < keys.length; i++) copy[keys[i]] = clone(value[keys[i]]);
+      return copy;
+    };
+    return clone(value);
+  };
+})(typeof globalThis !== "undefined" ? globalThis : typeof self !== "undefined" ? self : typeof window !== "undefined" ? window : global);
+`,
+	},
+	"Object.hasOwn": {
+		target: api.ES2022,
+		engines: map[api.EngineName]string{
+			api.EngineChrome:  "93",
+			api.EngineDeno:    "1.13",
+			api.EngineEdge:    "93",
+			api.EngineFirefox: "92",
+			api.EngineIOS:     "15.4",
+			api.EngineNode:    "16.9",
+			api.EngineOpera:   "79",
+			api.EngineSafari:  "15.4",
+		},
+		contents: `if (typeof Object.hasOwn !== "function") {
+  Object.defineProperty(Object, "hasOwn", {
+    value: function hasOwn(object, key) {
+      return Object.prototype.hasOwnProperty.call(Object(object), key);
+    },
+    configurable: true,
+    writable: true,
+  });
+}
+`,
+	},
+	"Array.prototype.at": {
+		target: api.ES2022,
+		engines: map[api.EngineName]string{
+			api.EngineChrome:  "92",
+			api.EngineDeno:    "1.12",
+			api.EngineEdge:    "92",
+			api.EngineFirefox: "90",
+			api.EngineIOS:     "15.4",
+			api.EngineNode:    "16.6",
+			api.EngineOpera:   "78",
+			api.EngineSafari:  "15.4",
+		},
+		contents: `if (typeof Array.prototype.at !== "function") {
+  Object.defineProperty(Array.prototype, "at", {
+    value: function at(index) {
+      // Math.trunc is ES2015
+      index = Number(index) || 0;
+      index = index < 0 ? Math.ceil(index) : Math.floor(index);
+      if (index < 0) index += this.length;
+      return index < 0 || index >= this.length ? undefined : this[index];
+    },
+    configurable: true,
+    writable: true,
+  });
+}
+`,
+	},
+}
+
+// NeededPolyfills returns the polyfills, by name, that some runtime in the
+// target is missing. With engines, that's any engine older than the first
+// version shipping the API, or one not in the table at all, like "ie".
+// Without, it's judged from the language target alone, and esbuild's
+// default of esnext needs none.
+func NeededPolyfills(target api.Target, engines []api.Engine) []string {
+	needed := []string{}
+	for name, polyfill := range polyfills {
+		if polyfillNeeded(polyfill, target, engines) {
+			needed = append(needed, name)
+		}
+	}
+	sort.Strings(needed)
+	return needed
+}
+
+func polyfillNeeded(polyfill polyfill, target api.Target, engines []api.Engine) bool {
+	if len(engines) == 0 {
+		return target != api.DefaultTarget && target != api.ESNext && target < polyfill.target
+	}
+	for _, engine := range engines {
+		minimumVersion, exists := polyfill.engines[engine.Name]
+		if !exists || compareVersions(engine.Version, minimumVersion) < 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares dotted versions like "15.4" numerically, treating
+// missing parts as 0.
+func compareVersions(left string, right string) int {
+	leftParts := strings.Split(left, ".")
+	rightParts := strings.Split(right, ".")
+	for index := 0; index < len(leftParts) || index < len(rightParts); index++ {
+		leftPart, rightPart := 0, 0
+		if index < len(leftParts) {
+			leftPart, _ = strconv.Atoi(leftParts[index])
+		}
+		if index < len(rightParts) {
+			rightPart, _ = strconv.Atoi(rightParts[index])
+		}
+		if leftPart != rightPart {
+			if leftPart < rightPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// PolyfillsPlugin loads the polyfills injected as "polyfill:<name>".
+func PolyfillsPlugin() api.Plugin {
+	return api.Plugin{
+		Name: "polyfills",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(
+				api.OnResolveOptions{Filter: `^polyfill:`},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					return api.OnResolveResult{
+						Path:      strings.TrimPrefix(args.Path, "polyfill:"),
+						Namespace: polyfillsNamespace,
+					}, nil
+				},
+			)
+
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: polyfillsNamespace},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					contents := polyfills[args.Path].contents
+					return api.OnLoadResult{Contents: &contents, Loader: api.LoaderJS}, nil
+				},
+			)
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const precacheManifestFilename = "precache-manifest.json"
+
+// PrecacheEntry is one output in a service worker's precache list, in the
+// {url, revision} shape Workbox's precacheAndRoute takes.
+type PrecacheEntry struct {
+	// Relative to the output directory, so it resolves against a service
+	// worker served from there
+	URL string `json:"url"`
+	// Hash of the contents, which changes whenever they do, even for
+	// outputs without a hash in their name
+	Revision string `json:"revision"`
+}
+
+// PrecacheManifest lists every output but sourcemaps, which only devtools
+// fetch, sorted by URL, and returns it as an output file at the root of
+// outdir.
+func PrecacheManifest(outputFiles []api.OutputFile, outdir string) (api.OutputFile, error) {
+	entries := []PrecacheEntry{}
+	for _, outputFile := range SortOutputFiles(outputFiles) {
+		if strings.HasSuffix(outputFile.Path, ".map") {
+			continue
+		}
+		relativePath, err := filepath.Rel(outdir, outputFile.Path)
+		if err != nil {
+			return api.OutputFile{}, err
+		}
+
+		hash := fnv.New64a()
+		hash.Write(outputFile.Contents)
+		entries = append(entries, PrecacheEntry{
+			URL:      filepath.ToSlash(relativePath),
+			Revision: fmt.Sprintf("%016x", hash.Sum64()),
+		})
+	}
+
+	contents, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return api.OutputFile{}, err
+	}
+	return api.OutputFile{
+		Path:     filepath.Join(outdir, precacheManifestFilename),
+		Contents: contents,
+	}, nil
+}
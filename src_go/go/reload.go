@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unsafe"
+)
+
+// #include <stdint.h>
+//
+// typedef void (*context_rebuilt_callback)(void* userData, int32_t id);
+//
+// static inline void invoke_context_rebuilt_callback(context_rebuilt_callback callback, void* userData, int32_t id) {
+//     callback(userData, id);
+// }
+import "C"
+
+// FilterReloadPaths keeps the changed paths whose extension is in extensions,
+// compared case-insensitively and with or without the leading dot. An empty
+// extensions list keeps every path.
+func FilterReloadPaths(paths []string, extensions []string) []string {
+	if len(extensions) == 0 {
+		return paths
+	}
+
+	reloadExtensions := make(map[string]bool, len(extensions))
+	for _, extension := range extensions {
+		reloadExtensions["."+strings.TrimPrefix(strings.ToLower(extension), ".")] = true
+	}
+
+	filtered := []string{}
+	for _, path := range paths {
+		if reloadExtensions[strings.ToLower(filepath.Ext(path))] {
+			filtered = append(filtered, path)
+		}
+	}
+	return filtered
+}
+
+//export RebuildContextsForChanges
+func RebuildContextsForChanges(
+	rawIds *C.int,
+	count C.int,
+	rawChangesJSON *C.char,
+	rawExtensionsJSON *C.char,
+	callback C.context_rebuilt_callback,
+	userData unsafe.Pointer,
+) (returnError *C.char) {
+	/*
+	 * Rebuilds the given contexts in response to a batch of file changes,
+	 * given as a JSON array of paths. rawExtensionsJSON lists the extensions
+	 * that are worth a reload, like [".ts", ".tsx", ".css"]; if none of the
+	 * changed files has one, nothing is rebuilt and callback never fires, so
+	 * saving a README doesn't reload the page. An empty list counts every
+	 * change. callback is called with the ID of each context that rebuilt
+	 * successfully, on the calling thread, once all of them are done. Errors
+	 * from individual rebuilds are joined with a blank line between them.
+	 */
+	var changes []string
+	if err := json.Unmarshal([]byte(C.GoString(rawChangesJSON)), &changes); err != nil {
+		return C.CString(fmt.Sprintf("Invalid changes JSON: %s", err))
+	}
+	var extensions []string
+	if rawExtensions := C.GoString(rawExtensionsJSON); rawExtensions != "" {
+		if err := json.Unmarshal([]byte(rawExtensions), &extensions); err != nil {
+			return C.CString(fmt.Sprintf("Invalid reload extensions JSON: %s", err))
+		}
+	}
+
+	if len(FilterReloadPaths(changes, extensions)) == 0 {
+		return nil
+	}
+
+	ids := unsafe.Slice(rawIds, int(count))
+	selected := make([]*ESBuildContext, 0, len(ids))
+	for _, id := range ids {
+		context, exists := getContext(id)
+		if !exists {
+			return C.CString(fmt.Sprintf("Context with ID %d does not exist", id))
+		}
+		selected = append(selected, context)
+	}
+
+	errors := rebuildContexts(selected)
+	if callback != nil {
+		for index, err := range errors {
+			if err == nil {
+				C.invoke_context_rebuilt_callback(callback, userData, C.int32_t(ids[index]))
+			}
+		}
+	}
+	if errorString := joinRebuildErrors(errors); errorString != "" {
+		return C.CString(errorString)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// When set, a rebuild that fails inside esbuild itself recreates the context
+// and tries again once. See isInternalFailure.
+var recreateOnInternalError atomic.Bool
+
+// isInternalFailure reports whether a rebuild failed because of esbuild's own
+// state rather than the code being built, which a fresh context can recover
+// from:
+//   - esbuild turns its own panics into errors starting with "panic: ".
+//     Panics in plugins are left alone, since they name the plugin and
+//     would just happen again.
+//   - a context that was disposed underneath us returns neither outputs nor
+//     errors. Contexts disposed on purpose, by RemoveContext and the like,
+//     return the same, so rebuildWithRecovery checks for those first.
+func isInternalFailure(result api.BuildResult) bool {
+	if len(result.Errors) == 0 {
+		return len(result.OutputFiles) == 0
+	}
+	for _, message := range result.Errors {
+		if message.PluginName == "" && strings.HasPrefix(message.Text, "panic: ") {
+			return true
+		}
+	}
+	return false
+}
+
+// recreate swaps in a fresh esbuild context built from the stored options,
+// dropping all incremental state. Like StopServe, this also stops its dev
+// server. The caller must hold lock.
+func (context *ESBuildContext) recreate() error {
+	ctx, contextErr := api.Context(context.Options)
+	if contextErr != nil {
+		return contextErr
+	}
+	context.Context.Dispose()
+	context.Context = ctx
+	context.ServePort = 0
+	return nil
+}
+
+// rebuildWithRecovery rebuilds the context, and if the rebuild hits an
+// internal failure while recreateOnInternalError is set, recreates the
+// context and rebuilds once more. Returns whether it was recreated. The
+// caller must hold lock.
+func (context *ESBuildContext) rebuildWithRecovery() (api.BuildResult, bool) {
+	result := context.Context.Rebuild()
+	if !recreateOnInternalError.Load() || context.disposed || !isInternalFailure(result) {
+		return result, false
+	}
+
+	// Logged to stderr, since stdout belongs to the host
+	fmt.Fprintf(os.Stderr, "Recreating the build context for %s after an internal esbuild error\n", context.Filename)
+	if err := context.recreate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return result, false
+	}
+	return context.Context.Rebuild(), true
+}
+
+//export SetRecreateOnInternalError
+func SetRecreateOnInternalError(enabled C.int) {
+	/*
+	 * Opts in to self-healing rebuilds: when esbuild fails for reasons of its
+	 * own rather than the code being built, like stale incremental state
+	 * after a long session, the context is recreated from its options and
+	 * rebuilt once more. The retry starts from a cold cache and stops any
+	 * dev server the context was running. Errors in the code itself are
+	 * never retried. Off by default.
+	 */
+	recreateOnInternalError.Store(enabled == 1)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// selfTestSource exercises the TypeScript and JSX parsers and the minifier,
+// which between them touch most of what a real build does in memory.
+const selfTestSource = `
+interface Props { name: string }
+export const Greeting = ({ name }: Props) => <p className="greeting">Hello {name}</p>;
+`
+
+// RunSelfTest transforms selfTestSource and checks the result looks like
+// compiled JS, returning what went wrong otherwise. esbuild panics are
+// recovered into the error, so a broken toolchain doesn't take the host
+// down with it.
+func RunSelfTest() (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("esbuild self-test panicked: %v", recovered)
+		}
+	}()
+
+	result := api.Transform(selfTestSource, api.TransformOptions{
+		Loader:            api.LoaderTSX,
+		Format:            api.FormatESModule,
+		MinifyWhitespace:  true,
+		MinifyIdentifiers: true,
+		MinifySyntax:      true,
+	})
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%s", FormatBuildErrors("esbuild self-test failed:\n\n", result.Errors))
+	}
+
+	code := string(result.Code)
+	for _, expected := range []string{"React.createElement", `"greeting"`, "export"} {
+		if !strings.Contains(code, expected) {
+			return fmt.Errorf("esbuild self-test produced unexpected output, missing %s:\n\n%s", expected, code)
+		}
+	}
+	if strings.Contains(code, "Props") {
+		return fmt.Errorf("esbuild self-test produced unexpected output, TypeScript types weren't stripped:\n\n%s", code)
+	}
+	return nil
+}
+
+//export SelfTest
+func SelfTest() (returnError *C.char) {
+	/*
+	 * Runs a tiny TSX transform to confirm the embedded esbuild works, for
+	 * hosts to call at startup. Returns NULL on success, or a diagnostic
+	 * describing the failure, so a broken build of the library is reported
+	 * clearly instead of as a confusing error from the first real build.
+	 */
+	if err := RunSelfTest(); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// esbuild's [hash] is the first 8 characters of a base32 digest
+var contentHashPattern = regexp.MustCompile(`\b[A-Z2-7]{8}\b`)
+
+// RemoveStaleOutputs deletes files under outdir that an earlier build wrote
+// under a different content hash than this one, like "home-OLDHASH.js" once
+// "home-NEWHASH.js" replaces it, along with their sourcemaps. A file only
+// counts as stale if its name matches one of outputFiles' apart from the
+// hash, so anything else in outdir, including outputs of entrypoints that
+// have since been removed, is left alone. Returns the removed paths.
+func RemoveStaleOutputs(outputFiles []api.OutputFile, outdir string) ([]string, error) {
+	current := make(map[string]bool, len(outputFiles))
+	hashedShapes := map[string]bool{}
+	for _, outputFile := range outputFiles {
+		outputPath := filepath.Clean(outputFile.Path)
+		current[outputPath] = true
+		if shape, hashed := hashedShape(outputPath); hashed {
+			hashedShapes[shape] = true
+		}
+	}
+
+	removed := []string{}
+	err := filepath.WalkDir(outdir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == outdir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || current[path] {
+			return nil
+		}
+		if shape, hashed := hashedShape(path); hashed && hashedShapes[shape] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed = append(removed, path)
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// hashedShape replaces the content hash in a path's filename with a
+// placeholder, reporting whether it had one.
+func hashedShape(path string) (string, bool) {
+	base := filepath.Base(path)
+	if !contentHashPattern.MatchString(base) {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(path), contentHashPattern.ReplaceAllString(base, "[hash]")), true
+}
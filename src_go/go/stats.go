@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+)
+
+import "C"
+
+// ContextStats is a snapshot of what the Go side is holding on to, so hosts
+// can decide when to dispose of idle contexts.
+type ContextStats struct {
+	Contexts int `json:"contexts"`
+	// Contexts with in-memory output retention turned on
+	KeepingOutputs int `json:"keepingOutputs"`
+	// Bytes of rebuild outputs retained in memory across all contexts
+	RetainedOutputBytes int `json:"retainedOutputBytes"`
+	// Process-wide figures from the Go runtime. Nothing here runs a
+	// background watcher, so every goroutine is either serving a call or
+	// belongs to esbuild itself.
+	Goroutines    int    `json:"goroutines"`
+	HeapAllocated uint64 `json:"heapAllocated"`
+}
+
+//export GetBuildContextStats
+func GetBuildContextStats() (returnStats *C.char, returnError *C.char) {
+	/*
+	 * Returns a ContextStats snapshot as JSON. Contexts are inspected one at a
+	 * time, so a rebuild that finishes mid-snapshot may or may not be counted.
+	 */
+	mutex.Lock()
+	snapshot := make([]*ESBuildContext, 0, len(contexts))
+	for _, context := range contexts {
+		snapshot = append(snapshot, context)
+	}
+	mutex.Unlock()
+
+	stats := ContextStats{Contexts: len(snapshot)}
+	for _, context := range snapshot {
+		context.lock.Lock()
+		if context.KeepOutputs {
+			stats.KeepingOutputs++
+		}
+		for _, contents := range context.Outputs {
+			stats.RetainedOutputBytes += len(contents)
+		}
+		context.lock.Unlock()
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats.Goroutines = runtime.NumGoroutine()
+	stats.HeapAllocated = memStats.HeapAlloc
+
+	payload, err := json.Marshal(stats)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// #include <stdint.h>
+// #include <stdlib.h>
+//
+// typedef void (*output_chunk_callback)(void* userData, const char* path, const char* data, int32_t length, int32_t isLast);
+//
+// static inline void invoke_output_chunk_callback(output_chunk_callback callback, void* userData, const char* path, const char* data, int32_t length, int32_t isLast) {
+//     callback(userData, path, data, length, isLast);
+// }
+//
+// typedef int32_t (*output_file_callback)(void* userData, const char* path, const char* data, int32_t length);
+//
+// static inline int32_t invoke_output_file_callback(output_file_callback callback, void* userData, const char* path, const char* data, int32_t length) {
+//     return callback(userData, path, data, length);
+// }
+import "C"
+
+const defaultStreamChunkSize = 64 * 1024
+
+//export BundleAllStreaming
+func BundleAllStreaming(
+	rawOptions *C.char,
+	callback C.output_chunk_callback,
+	userData unsafe.Pointer,
+	chunkSize C.int,
+) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll but hands outputs to the callback instead of writing
+	 * them to disk, so large bundles never need to be copied into one C string.
+	 *
+	 * Files are streamed one at a time, ordered by path. Each file is sent as
+	 * one or more chunks of at most chunkSize bytes (64KB if chunkSize <= 0),
+	 * and its final call has isLast set to 1, so empty files still produce a
+	 * single call with a length of 0. The path and data pointers are only
+	 * valid for the duration of each call; copy anything that needs to outlive
+	 * it. userData is passed through untouched. cleanStale and emitNotices
+	 * aren't supported, since the outdir isn't written to.
+	 */
+	if callback == nil {
+		return nil, C.CString("No output callback provided")
+	}
+
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	if options.CleanStale {
+		return nil, C.CString("cleanStale only applies to builds written to the outdir")
+	}
+	if options.EmitNotices {
+		return nil, C.CString("emitNotices only applies to builds written to the outdir")
+	}
+
+	size := int(chunkSize)
+	if size <= 0 {
+		size = defaultStreamChunkSize
+	}
+
+	emit := func(outputFiles []api.OutputFile, outdir string, _ *ioPool) error {
+		for _, outputFile := range SortOutputFiles(outputFiles) {
+			streamOutputFile(callback, userData, outputFile, size)
+		}
+		return nil
+	}
+
+	result, err := bundleAll(options, emit)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+//export BundleAllWithCallback
+func BundleAllWithCallback(
+	rawOptions *C.char,
+	callback C.output_file_callback,
+	userData unsafe.Pointer,
+) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll but leaves output placement entirely to the host:
+	 * nothing is written to disk, and the callback receives each output file
+	 * whole so it can be uploaded or stored wherever the host likes.
+	 *
+	 * The callback runs synchronously on the calling thread, once per file,
+	 * ordered by path, after esbuild has finished and before this returns.
+	 * The path and data pointers are only valid for the duration of each
+	 * call. Returning a non-zero status stops the remaining files and fails
+	 * the build. Use BundleAllStreaming instead if files are too large to
+	 * handle in one piece. cleanStale and emitNotices aren't supported,
+	 * since the outdir isn't written to.
+	 */
+	if callback == nil {
+		return nil, C.CString("No output callback provided")
+	}
+
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	if options.CleanStale {
+		return nil, C.CString("cleanStale only applies to builds written to the outdir")
+	}
+	if options.EmitNotices {
+		return nil, C.CString("emitNotices only applies to builds written to the outdir")
+	}
+
+	emit := func(outputFiles []api.OutputFile, outdir string, _ *ioPool) error {
+		for _, outputFile := range SortOutputFiles(outputFiles) {
+			if status := emitOutputFile(callback, userData, outputFile); status != 0 {
+				return fmt.Errorf("Output callback failed for %s with status %d", outputFile.Path, status)
+			}
+		}
+		return nil
+	}
+
+	result, err := bundleAll(options, emit)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+func emitOutputFile(callback C.output_file_callback, userData unsafe.Pointer, outputFile api.OutputFile) int {
+	path := C.CString(outputFile.Path)
+	defer C.free(unsafe.Pointer(path))
+
+	// As with streaming, point directly into the Go slice
+	var data *C.char
+	if len(outputFile.Contents) > 0 {
+		data = (*C.char)(unsafe.Pointer(&outputFile.Contents[0]))
+	}
+	return int(C.invoke_output_file_callback(callback, userData, path, data, C.int32_t(len(outputFile.Contents))))
+}
+
+func streamOutputFile(callback C.output_chunk_callback, userData unsafe.Pointer, outputFile api.OutputFile, chunkSize int) {
+	path := C.CString(outputFile.Path)
+	defer C.free(unsafe.Pointer(path))
+
+	contents := outputFile.Contents
+	for offset := 0; offset == 0 || offset < len(contents); offset += chunkSize {
+		end := offset + chunkSize
+		if end > len(contents) {
+			end = len(contents)
+		}
+
+		// Point directly into the Go slice rather than copying each chunk into
+		// C memory. This is allowed since the bytes hold no Go pointers and C
+		// doesn't retain them past the call.
+		var data *C.char
+		if end > offset {
+			data = (*C.char)(unsafe.Pointer(&contents[offset]))
+		}
+
+		isLast := C.int32_t(0)
+		if end == len(contents) {
+			isLast = 1
+		}
+		C.invoke_output_chunk_callback(callback, userData, path, data, C.int32_t(end-offset), isLast)
+
+		if isLast == 1 {
+			break
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const svgComponentSuffix = "?react"
+
+// SvgComponentPlugin turns `import Icon from "./icon.svg?react"` into a React
+// component that renders the file's markup inline. Package paths, like
+// "icons/check.svg?react", resolve like any other import of the file. Plain
+// `.svg` imports are left to whichever loader is registered for the extension.
+func SvgComponentPlugin() api.Plugin {
+	return api.Plugin{
+		Name: "svg-component",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(
+				api.OnResolveOptions{Filter: `\.svg\?react$`},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					path := strings.TrimSuffix(args.Path, svgComponentSuffix)
+					if filepath.IsAbs(path) {
+						return api.OnResolveResult{Path: path, Namespace: "svg-component"}, nil
+					}
+					if strings.HasPrefix(path, ".") {
+						return api.OnResolveResult{Path: filepath.Join(args.ResolveDir, path), Namespace: "svg-component"}, nil
+					}
+
+					// Package paths go through the normal resolver without the
+					// suffix, so node_modules and aliases apply as usual
+					resolved := build.Resolve(path, api.ResolveOptions{
+						Importer:   args.Importer,
+						Namespace:  args.Namespace,
+						ResolveDir: args.ResolveDir,
+						Kind:       args.Kind,
+					})
+					if len(resolved.Errors) > 0 {
+						return api.OnResolveResult{Errors: resolved.Errors, Warnings: resolved.Warnings}, nil
+					}
+					if resolved.External {
+						return api.OnResolveResult{Path: args.Path, External: true}, nil
+					}
+					if resolved.Namespace != "file" {
+						return api.OnResolveResult{}, fmt.Errorf("%s resolved to %s in the %q namespace, which can't be read as an SVG component", args.Path, resolved.Path, resolved.Namespace)
+					}
+					return api.OnResolveResult{Path: resolved.Path, Namespace: "svg-component"}, nil
+				},
+			)
+
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: "svg-component"},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					markup, err := os.ReadFile(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+					encodedMarkup, err := json.Marshal(string(markup))
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+
+					contents := fmt.Sprintf(`import { createElement } from "react";
+const markup = %s;
+export default function SvgComponent(props) {
+  return createElement("span", { ...props, dangerouslySetInnerHTML: { __html: markup } });
+}
+`, encodedMarkup)
+					return api.OnLoadResult{
+						Contents:   &contents,
+						Loader:     api.LoaderJS,
+						ResolveDir: filepath.Dir(args.Path),
+						WatchFiles: []string{args.Path},
+					}, nil
+				},
+			)
+		},
+	}
+}
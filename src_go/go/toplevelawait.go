@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+const topLevelAwaitGuidance = "top-level await requires format esm and a compatible target"
+
+// ExplainTopLevelAwaitErrors adds guidance to esbuild's errors for a
+// top-level await it can't emit, which only say that it isn't supported.
+// esbuild can't lower top-level await, so the fix is always in the build
+// settings or the code, and the message says which. Other errors are
+// returned as is.
+func ExplainTopLevelAwaitErrors(errors []api.Message) []api.Message {
+	explained := make([]api.Message, len(errors))
+	for index, message := range errors {
+		switch {
+		case strings.HasPrefix(message.Text, "Top-level await is currently not supported with the"):
+			// The iife and cjs formats wrap the module in a function
+			message.Text += ". Note: " + topLevelAwaitGuidance + "; build with format \"esm\", or move the await into an async function"
+		case strings.HasPrefix(message.Text, "Top-level await is not available in the configured target environment"):
+			message.Text += ". Note: " + topLevelAwaitGuidance + "; raise the target to es2022 or to engines that support it, remove a \"top-level-await\": false from supported, or move the await into an async function"
+		}
+		explained[index] = message
+	}
+	return explained
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"unsafe"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+//export TransformWithSourceMap
+func TransformWithSourceMap(
+	rawSource *C.char,
+	rawSourcefile *C.char,
+	rawLoader *C.char,
+	rawInputSourceMap *C.char,
+) (returnCode *C.char, returnSourceMap *C.char, returnError *C.char) {
+	/*
+	 * Transforms code that was produced by an earlier step (like codegen) and
+	 * returns a sourcemap that points all the way back to that step's original
+	 * sources. rawInputSourceMap is the earlier step's map as JSON, or empty if
+	 * there isn't one.
+	 *
+	 * esbuild chains maps by reading a sourceMappingURL comment from its input,
+	 * so the input map is attached as an inline comment. Limitations of
+	 * esbuild's chaining:
+	 *   - Only one level is followed. If the input map itself came from a
+	 *     chain, it must already be flattened.
+	 *   - Mappings are resolved per segment, so columns inside a segment the
+	 *     input map doesn't cover fall back to the nearest mapped column.
+	 *   - "names" from the input map aren't carried over.
+	 */
+	source := C.GoString(rawSource)
+	sourcefile := C.GoString(rawSourcefile)
+
+	loaderName := C.GoString(rawLoader)
+	if loaderName == "" {
+		loaderName = "js"
+	}
+	loader, err := ParseLoader(loaderName)
+	if err != nil {
+		return nil, nil, C.CString(err.Error())
+	}
+
+	code, sourceMap, err := transformWithSourceMap(source, sourcefile, loader, C.GoString(rawInputSourceMap))
+	if err != nil {
+		return nil, nil, C.CString(err.Error())
+	}
+	return C.CString(code), C.CString(sourceMap), nil
+}
+
+func transformWithSourceMap(source string, sourcefile string, loader api.Loader, inputSourceMap string) (string, string, error) {
+	if inputSourceMap != "" {
+		source += "\n//# sourceMappingURL=data:application/json;base64," +
+			base64.StdEncoding.EncodeToString([]byte(inputSourceMap))
+	}
+
+	var transformErr error
+	key := transformCacheKey("sourcemap", strconv.Itoa(int(loader)), sourcefile, source)
+	code, sourceMap, _ := cachedTransform(key, func() (string, string, bool) {
+		result := api.Transform(source, api.TransformOptions{
+			Loader:     loader,
+			Sourcefile: sourcefile,
+			Sourcemap:  api.SourceMapExternal,
+		})
+		if len(result.Errors) > 0 {
+			header := fmt.Sprintf("Error transforming %s:\n\n", sourcefile)
+			transformErr = fmt.Errorf("%s", FormatBuildErrors(header, result.Errors))
+			return "", "", false
+		}
+		return string(result.Code), string(result.Map), true
+	})
+	if transformErr != nil {
+		return "", "", transformErr
+	}
+	return code, sourceMap, nil
+}
+
+// TransformOutput is the result of transforming one snippet in a batch.
+// Exactly one of Code and Error is set.
+type TransformOutput struct {
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+//export TransformMany
+func TransformMany(rawSources **C.char, rawLoaders **C.char, count C.int) (returnOutputs *C.char, returnError *C.char) {
+	/*
+	 * Transforms count snippets in one call, so tooling with many small
+	 * fragments crosses the boundary once instead of per snippet. rawSources
+	 * and rawLoaders are parallel arrays; an empty loader means "js".
+	 *
+	 * Snippets are transformed concurrently, one per CPU at a time. Returns a
+	 * JSON array of TransformOutput in the same order as the inputs, so a
+	 * failure in one snippet doesn't affect the others. Only invalid loader
+	 * names fail the whole call. Snippets already in the transform cache, when
+	 * SetTransformCacheSize has enabled it, aren't transformed again.
+	 */
+	sources := unsafe.Slice(rawSources, int(count))
+	loaderNames := unsafe.Slice(rawLoaders, int(count))
+
+	// Copy everything out of C memory and validate before starting any work
+	snippets := make([]string, len(sources))
+	loaders := make([]api.Loader, len(sources))
+	for index := range sources {
+		snippets[index] = C.GoString(sources[index])
+
+		loaderName := C.GoString(loaderNames[index])
+		if loaderName == "" {
+			loaderName = "js"
+		}
+		loader, err := ParseLoader(loaderName)
+		if err != nil {
+			return nil, C.CString(fmt.Sprintf("Invalid loader for snippet %d: %s", index, err))
+		}
+		loaders[index] = loader
+	}
+
+	payload, err := json.Marshal(transformMany(snippets, loaders))
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
+
+func transformMany(sources []string, loaders []api.Loader) []TransformOutput {
+	outputs := make([]TransformOutput, len(sources))
+	workers := make(chan struct{}, runtime.NumCPU())
+
+	var wg sync.WaitGroup
+	for index := range sources {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			key := transformCacheKey("snippet", strconv.Itoa(int(loaders[index])), sources[index])
+			outputs[index].Code, _, _ = cachedTransform(key, func() (string, string, bool) {
+				result := api.Transform(sources[index], api.TransformOptions{Loader: loaders[index]})
+				if len(result.Errors) > 0 {
+					header := fmt.Sprintf("Error transforming snippet %d:\n\n", index)
+					outputs[index].Error = FormatBuildErrors(header, result.Errors)
+					return "", "", false
+				}
+				return string(result.Code), "", true
+			})
+		}(index)
+	}
+	wg.Wait()
+
+	return outputs
+}
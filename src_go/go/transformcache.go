@@ -0,0 +1,190 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+)
+
+import "C"
+
+// TransformCache remembers the results of recent transforms, keyed by a hash
+// of the source and every option that affects the output, so transforming
+// an unchanged file again skips esbuild entirely. Only successful transforms
+// are stored. Once it holds capacity entries, the least recently used one is
+// dropped; a capacity of 0 disables caching.
+type TransformCache struct {
+	lock     sync.Mutex
+	capacity int
+	entries  map[[sha256.Size]byte]*list.Element
+	// Most recently used at the front
+	order  *list.List
+	hits   int
+	misses int
+}
+
+type transformCacheEntry struct {
+	key       [sha256.Size]byte
+	code      string
+	sourceMap string
+}
+
+// TransformCacheStats is a snapshot of the cache for tuning its capacity.
+type TransformCacheStats struct {
+	Entries  int `json:"entries"`
+	Capacity int `json:"capacity"`
+	Hits     int `json:"hits"`
+	Misses   int `json:"misses"`
+}
+
+// Shared by every transform export. Disabled until SetTransformCacheSize is
+// called.
+var transformCache = NewTransformCache(0)
+
+func NewTransformCache(capacity int) *TransformCache {
+	return &TransformCache{
+		capacity: capacity,
+		entries:  make(map[[sha256.Size]byte]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// transformCacheKey hashes the inputs of one transform. Each part is length
+// prefixed, so different splits of the same bytes can't collide.
+func transformCacheKey(parts ...string) [sha256.Size]byte {
+	hash := sha256.New()
+	for _, part := range parts {
+		length := len(part)
+		hash.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)})
+		hash.Write([]byte(part))
+	}
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+	return key
+}
+
+// Get returns the cached code and sourcemap for key, counting a hit or miss.
+// Misses aren't counted while the cache is disabled.
+func (cache *TransformCache) Get(key [sha256.Size]byte) (string, string, bool) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.capacity == 0 {
+		return "", "", false
+	}
+	element, exists := cache.entries[key]
+	if !exists {
+		cache.misses++
+		return "", "", false
+	}
+	cache.hits++
+	cache.order.MoveToFront(element)
+	entry := element.Value.(*transformCacheEntry)
+	return entry.code, entry.sourceMap, true
+}
+
+// Put stores a transform result, evicting the least recently used entries
+// past capacity.
+func (cache *TransformCache) Put(key [sha256.Size]byte, code string, sourceMap string) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if cache.capacity == 0 {
+		return
+	}
+	if element, exists := cache.entries[key]; exists {
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&transformCacheEntry{key: key, code: code, sourceMap: sourceMap})
+	cache.evict()
+}
+
+// SetCapacity changes the capacity, evicting immediately if it shrank.
+func (cache *TransformCache) SetCapacity(capacity int) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if capacity < 0 {
+		capacity = 0
+	}
+	cache.capacity = capacity
+	cache.evict()
+}
+
+// Clear drops every entry and resets the hit and miss counts.
+func (cache *TransformCache) Clear() {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.entries = make(map[[sha256.Size]byte]*list.Element)
+	cache.order.Init()
+	cache.hits = 0
+	cache.misses = 0
+}
+
+func (cache *TransformCache) Stats() TransformCacheStats {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	return TransformCacheStats{
+		Entries:  len(cache.entries),
+		Capacity: cache.capacity,
+		Hits:     cache.hits,
+		Misses:   cache.misses,
+	}
+}
+
+// evict drops entries from the back until the cache fits. The caller must
+// hold lock.
+func (cache *TransformCache) evict() {
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*transformCacheEntry).key)
+	}
+}
+
+// cachedTransform runs transform for the inputs hashed into key, unless the
+// shared cache already has its result. transform returns the code, the
+// sourcemap, and whether it succeeded; failures aren't cached.
+func cachedTransform(key [sha256.Size]byte, transform func() (string, string, bool)) (string, string, bool) {
+	if code, sourceMap, exists := transformCache.Get(key); exists {
+		return code, sourceMap, true
+	}
+	code, sourceMap, ok := transform()
+	if ok {
+		transformCache.Put(key, code, sourceMap)
+	}
+	return code, sourceMap, ok
+}
+
+//export SetTransformCacheSize
+func SetTransformCacheSize(size C.int) {
+	/*
+	 * Opts in to caching transform results in memory, keeping at most size of
+	 * them. Repeating a transform with the same source and options then
+	 * returns the cached result without running esbuild. 0, the default,
+	 * turns caching off; entries beyond a lowered size are dropped right away.
+	 */
+	transformCache.SetCapacity(int(size))
+}
+
+//export ClearTransformCache
+func ClearTransformCache() {
+	/*
+	 * Drops every cached transform result and resets the hit and miss counts.
+	 * The cache stays enabled at its current size.
+	 */
+	transformCache.Clear()
+}
+
+//export GetTransformCacheStats
+func GetTransformCacheStats() (returnStats *C.char) {
+	/*
+	 * Returns TransformCacheStats as JSON, for measuring the hit rate.
+	 */
+	payload, _ := json.Marshal(transformCache.Stats())
+	return C.CString(string(payload))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type tsconfigFile struct {
+	Extends         string `json:"extends"`
+	CompilerOptions struct {
+		BaseURL *string             `json:"baseUrl"`
+		Paths   map[string][]string `json:"paths"`
+	} `json:"compilerOptions"`
+}
+
+// TsconfigWithPaths returns a tsconfig for esbuild's TsconfigRaw that
+// extends tsconfigPath and adds overrides to its compilerOptions.paths,
+// replacing any of its entries with the same pattern. TypeScript replaces
+// paths as a whole when extending, so the file's own paths are copied over.
+// Override targets are written as they would be in tsconfigPath itself.
+//
+// esbuild places a raw tsconfig in workingDir, so without a baseUrl,
+// relative targets are rebased from the file's directory to workingDir. An
+// empty tsconfigPath produces a config with only the overrides.
+func TsconfigWithPaths(tsconfigPath string, workingDir string, overrides map[string][]string) (string, error) {
+	paths := map[string][]string{}
+	pathsDir := workingDir
+	hasBaseURL := false
+	if tsconfigPath != "" {
+		filePaths, filePathsDir, fileHasBaseURL, err := readTsconfigPaths(tsconfigPath)
+		if err != nil {
+			return "", err
+		}
+		for pattern, targets := range filePaths {
+			paths[pattern] = targets
+		}
+		pathsDir = filePathsDir
+		hasBaseURL = fileHasBaseURL
+		if pathsDir == "" {
+			pathsDir = filepath.Dir(tsconfigPath)
+		}
+	}
+	for pattern, targets := range overrides {
+		paths[pattern] = targets
+	}
+
+	if !hasBaseURL {
+		for pattern, targets := range paths {
+			rebased := make([]string, len(targets))
+			for index, target := range targets {
+				relativeTarget, err := filepath.Rel(workingDir, filepath.Join(pathsDir, target))
+				if err != nil {
+					return "", err
+				}
+				rebased[index] = "./" + filepath.ToSlash(relativeTarget)
+			}
+			paths[pattern] = rebased
+		}
+	}
+
+	config := map[string]interface{}{
+		"compilerOptions": map[string]interface{}{"paths": paths},
+	}
+	if tsconfigPath != "" {
+		config["extends"] = tsconfigPath
+	}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// readTsconfigPaths returns the paths in effect for a tsconfig, following
+// relative "extends" until a config defines them. Also returns the
+// directory of the config that defined them, which non-baseUrl targets are
+// relative to, and whether a baseUrl applies instead.
+func readTsconfigPaths(tsconfigPath string) (map[string][]string, string, bool, error) {
+	hasBaseURL := false
+	for visited := map[string]bool{}; !visited[tsconfigPath]; {
+		visited[tsconfigPath] = true
+
+		contents, err := os.ReadFile(tsconfigPath)
+		if err != nil {
+			return nil, "", false, err
+		}
+		var config tsconfigFile
+		if err := json.Unmarshal(stripJSONComments(contents), &config); err != nil {
+			return nil, "", false, fmt.Errorf("Invalid tsconfig %s: %s", tsconfigPath, err)
+		}
+
+		hasBaseURL = hasBaseURL || config.CompilerOptions.BaseURL != nil
+		if config.CompilerOptions.Paths != nil {
+			return config.CompilerOptions.Paths, filepath.Dir(tsconfigPath), hasBaseURL, nil
+		}
+
+		// Configs extended from packages can't define paths that point back
+		// into this project, so only relative ones are followed
+		if !strings.HasPrefix(config.Extends, ".") {
+			break
+		}
+		tsconfigPath = filepath.Join(filepath.Dir(tsconfigPath), config.Extends)
+		if filepath.Ext(tsconfigPath) != ".json" {
+			tsconfigPath += ".json"
+		}
+	}
+	return nil, "", hasBaseURL, nil
+}
+
+// stripJSONComments turns the JSON-with-comments tsconfig files are written
+// in into plain JSON by dropping comments and trailing commas.
+func stripJSONComments(contents []byte) []byte {
+	stripped := make([]byte, 0, len(contents))
+	for index := 0; index < len(contents); index++ {
+		char := contents[index]
+		switch {
+		case char == '"':
+			// Copy strings verbatim, including escaped quotes
+			end := index + 1
+			for end < len(contents) && contents[end] != '"' {
+				if contents[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(contents) {
+				end = len(contents) - 1
+			}
+			stripped = append(stripped, contents[index:end+1]...)
+			index = end
+		case char == '/' && index+1 < len(contents) && contents[index+1] == '/':
+			for index < len(contents) && contents[index] != '\n' {
+				index++
+			}
+			index--
+		case char == '/' && index+1 < len(contents) && contents[index+1] == '*':
+			end := strings.Index(string(contents[index+2:]), "*/")
+			if end == -1 {
+				return stripped
+			}
+			index += end + 3
+		case char == '}' || char == ']':
+			// Drop a trailing comma before the closing bracket
+			trimmed := strings.TrimRight(string(stripped), " \t\r\n")
+			if strings.HasSuffix(trimmed, ",") {
+				stripped = []byte(strings.TrimSuffix(trimmed, ","))
+			}
+			stripped = append(stripped, char)
+		default:
+			stripped = append(stripped, char)
+		}
+	}
+	return stripped
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// ValidateBundleOptions checks every option BundleAll parses and returns all
+// the problems it finds, each worded the same as the error BundleAll would
+// fail with. bundleAll runs this up front, so the two can't disagree. Files
+// aren't checked, since they can change between validating and building.
+func ValidateBundleOptions(options BundleOptions) []string {
+	problems := []string{}
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	entrypointCount := len(options.Entrypoints) + len(options.VirtualEntrypoints)
+	if entrypointCount == 0 {
+		check(fmt.Errorf("No entrypoints provided"))
+	}
+	check(ValidateVirtualEntrypoints(options.VirtualEntrypoints))
+	switch {
+	case options.Outdir == "" && options.Outfile == "":
+		check(fmt.Errorf("No output directory provided"))
+	case options.Outdir != "" && options.Outfile != "":
+		check(fmt.Errorf("outdir and outfile can't both be set"))
+	}
+	if options.Outfile != "" {
+		if entrypointCount > 1 {
+			check(fmt.Errorf("outfile needs exactly one entrypoint, got %d", entrypointCount))
+		}
+		if isEnabled(options.Splitting) {
+			check(fmt.Errorf("outfile can't be used with splitting, which needs an outdir for its chunks"))
+		}
+		if options.EntryNames != "" || options.ChunkNames != "" {
+			check(fmt.Errorf("entryNames and chunkNames need an outdir, since outfile names the output itself"))
+		}
+	}
+	if isEnabled(options.Splitting) && options.Format != "" && options.Format != "esm" {
+		check(fmt.Errorf("splitting is only supported for the esm format"))
+	}
+	if options.PriorMetafile != "" && (len(options.VendorPackages) > 0 || len(options.VirtualEntrypoints) > 0) {
+		check(fmt.Errorf("Incremental builds only support entrypoints on disk, not vendorPackages or virtual entrypoints"))
+	}
+	if len(options.VendorPackages) > 0 {
+		check(ValidateVendorPackages(options.VendorPackages))
+		if options.Outfile != "" || (options.Splitting != nil && !*options.Splitting) || (options.Format != "" && options.Format != "esm") {
+			check(fmt.Errorf("vendorPackages needs code splitting, which needs an outdir and the esm format"))
+		}
+		for _, entrypoint := range options.VirtualEntrypoints {
+			if entrypoint.Name == vendorEntrypointName {
+				check(fmt.Errorf("Virtual entrypoint %q is reserved for vendorPackages", vendorEntrypointName))
+			}
+		}
+	}
+
+	_, err := ParseFormat(options.Format)
+	check(err)
+	if options.GlobalName != "" {
+		if options.Format != "iife" {
+			check(fmt.Errorf("globalName needs the iife format"))
+		}
+		_, err = ParseGlobalName(options.GlobalName)
+		check(err)
+	}
+	check(ValidateAutoInit(options.AutoInit, options.GlobalName))
+	_, err = ParseBannerFooter("banner", options.Banner)
+	check(err)
+	_, err = ParseBannerFooter("footer", options.Footer)
+	check(err)
+	_, err = ParseJSX(options.JSX)
+	check(err)
+	_, err = ParseCharset(options.Charset)
+	check(err)
+	if options.MaxErrors < 0 {
+		check(fmt.Errorf("maxErrors can't be negative"))
+	}
+	if options.IOConcurrency < 0 {
+		check(fmt.Errorf("ioConcurrency can't be negative"))
+	}
+	if options.MangleProps != "" {
+		if _, err := regexp.Compile(options.MangleProps); err != nil {
+			check(fmt.Errorf("Invalid mangleProps: %s", err))
+		}
+	} else if len(options.MangleCache) > 0 {
+		check(fmt.Errorf("mangleCache needs mangleProps"))
+	}
+	check(ValidateJSXDev(options.JSX, options.JSXDev))
+	_, err = ParseLogOverrides(options.LogOverrides)
+	check(err)
+	_, _, err = ParseTarget(options.Target)
+	check(err)
+	if options.BrowserslistPath != "" && !options.UseBrowserslist {
+		check(fmt.Errorf("browserslistPath needs useBrowserslist"))
+	}
+	_, err = ValidateAliases(options.Aliases)
+	check(err)
+
+	for _, template := range []struct{ option, value string }{
+		{"entryNames", options.EntryNames},
+		{"chunkNames", options.ChunkNames},
+		{"assetNames", options.AssetNames},
+	} {
+		check(ValidateNameTemplate(template.option, template.value))
+	}
+
+	if options.SvgLoader != "" {
+		_, err = ParseLoader(options.SvgLoader)
+		check(err)
+	}
+	if options.WasmLoader != "" {
+		_, err = ParseWasmLoader(options.WasmLoader)
+		check(err)
+		if _, exists := options.Loaders[".wasm"]; exists {
+			check(fmt.Errorf("wasmLoader and a \".wasm\" entry in loaders can't both be set"))
+		}
+	}
+	if options.DefaultLoader != "" {
+		_, err = ParseLoader(options.DefaultLoader)
+		check(err)
+	}
+	for _, extension := range sortedKeys(options.Loaders) {
+		_, err = ParseLoader(options.Loaders[extension])
+		check(err)
+	}
+	if options.NodeBuiltins != "" {
+		_, err = NodeBuiltinsPlugin(options.NodeBuiltins)
+		check(err)
+	}
+	if options.SourceRoot != "" {
+		check(ValidateSourceRoot(options.SourceRoot))
+	}
+	if options.OutputCollisions != "" && options.OutputCollisions != "error" && options.OutputCollisions != "warn" {
+		check(fmt.Errorf("Invalid outputCollisions %q: expected \"error\" or \"warn\"", options.OutputCollisions))
+	}
+
+	if len(options.InlineScripts) > 0 && !options.EmitHtml {
+		check(fmt.Errorf("inlineScripts needs emitHtml, since they're only injected into the HTML pages"))
+	}
+	if options.Integrity && !options.EmitManifest {
+		check(fmt.Errorf("integrity needs emitManifest, since the digests are returned in the manifest"))
+	}
+	for _, inlineScript := range options.InlineScripts {
+		check(validateInlineScript(inlineScript))
+	}
+
+	for _, external := range options.Externals {
+		if strings.Count(external, "*") > 1 {
+			check(fmt.Errorf("External path %q cannot have more than one \"*\" wildcard", external))
+		}
+	}
+	for _, emptyModule := range options.EmptyModules {
+		if emptyModule == "" {
+			check(fmt.Errorf("emptyModules can't contain an empty module name"))
+		}
+	}
+	if defines, err := ExpandDefines(options.Defines, nil); err != nil {
+		check(err)
+	} else {
+		problems = append(problems, validateDefines(defines)...)
+	}
+
+	return problems
+}
+
+// validateDefines has esbuild parse the defines the way a build would, by
+// transforming an empty file with them.
+func validateDefines(defines map[string]string) []string {
+	if len(defines) == 0 {
+		return nil
+	}
+	result := api.Transform("", api.TransformOptions{Define: defines})
+	problems := make([]string, 0, len(result.Errors))
+	for _, message := range result.Errors {
+		problems = append(problems, message.Text)
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//export ValidateOptions
+func ValidateOptions(rawOptions *C.char) (returnProblems *C.char, returnError *C.char) {
+	/*
+	 * Checks BundleAll options without building or writing anything, and
+	 * returns a JSON array of every problem found, empty if the options are
+	 * valid. The config file, if any, is merged in first, as it would be for
+	 * the build. Only options JSON that can't be parsed at all is an error.
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+
+	problems := []string{}
+	resolvedOptions, _, err := ResolveBundleOptions(options)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		problems = ValidateBundleOptions(resolvedOptions)
+	}
+
+	payload, err := json.Marshal(problems)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
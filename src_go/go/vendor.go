@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Name of the synthetic entrypoint that pulls VendorPackages into their own
+// chunks
+const vendorEntrypointName = "vendor.js"
+
+// VendorEntrypoint builds the synthetic entry that re-exports every vendor
+// package in full. With code splitting, esbuild puts code reached from
+// several entrypoints into chunks shared between exactly that set of
+// entrypoints. Since app code is never reached from this entry, the vendor
+// packages always end up in chunks of their own, apart from shared app
+// code, and they keep their names across app changes.
+//
+// It's an approximation of a real vendor bundle:
+//   - Packages imported by different sets of pages land in different chunks,
+//     so there can be several vendor chunks, not one.
+//   - Re-exporting a whole package keeps code no page uses, which ends up in
+//     the vendor entry's own output rather than being tree-shaken.
+//   - A chunk's hash still changes when a page starts or stops importing a
+//     package, since that regroups the chunks.
+func VendorEntrypoint(packages []string) VirtualEntrypoint {
+	var contents strings.Builder
+	for index, pkg := range packages {
+		contents.WriteString(fmt.Sprintf("export * as vendor%d from %q;\n", index, pkg))
+	}
+	return VirtualEntrypoint{Name: vendorEntrypointName, Contents: contents.String()}
+}
+
+// ValidateVendorPackages checks that every vendor package is a bare package
+// name or subpath, like "react" or "react-dom/client".
+func ValidateVendorPackages(packages []string) error {
+	for _, pkg := range packages {
+		if pkg == "" || strings.HasPrefix(pkg, ".") || strings.HasPrefix(pkg, "/") {
+			return fmt.Errorf("Invalid vendor package %q: expected a package name like \"react\"", pkg)
+		}
+	}
+	return nil
+}
+
+// VendorOutputs lists the outputs, relative to the working directory like
+// the rest of the manifest, that contain code from any of the vendor
+// packages. Sourcemaps are left out.
+func VendorOutputs(metafile Metafile, packages []string) []string {
+	vendorPackages := make(map[string]bool, len(packages))
+	for _, pkg := range packages {
+		vendorPackages[packageRootName(pkg)] = true
+	}
+
+	outputs := []string{}
+	for outputPath, output := range metafile.Outputs {
+		if filepath.Ext(outputPath) == ".map" {
+			continue
+		}
+		for inputPath := range output.Inputs {
+			if vendorPackages[inputPackageName(inputPath)] {
+				outputs = append(outputs, outputPath)
+				break
+			}
+		}
+	}
+	sort.Strings(outputs)
+	return outputs
+}
+
+// packageRootName strips any subpath from an import, so "react-dom/client"
+// becomes "react-dom" and "@scope/pkg/utils" becomes "@scope/pkg".
+func packageRootName(importPath string) string {
+	segments := strings.Split(importPath, "/")
+	if strings.HasPrefix(importPath, "@") && len(segments) > 1 {
+		return segments[0] + "/" + segments[1]
+	}
+	return segments[0]
+}
+
+// inputPackageName returns the node_modules package a metafile input belongs
+// to, or "" for app code. See findPackageDir.
+func inputPackageName(inputPath string) string {
+	packageDir := filepath.ToSlash(findPackageDir("/" + inputPath))
+	if packageDir == "" {
+		return ""
+	}
+	return packageDir[strings.LastIndex(packageDir, "/node_modules/")+len("/node_modules/"):]
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unsafe"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// #include <stdint.h>
+// #include <stdlib.h>
+//
+// typedef int32_t (*module_loader_callback)(void* userData, const char* path, char** contents, int32_t* length);
+//
+// static inline int32_t invoke_module_loader_callback(module_loader_callback callback, void* userData, const char* path, char** contents, int32_t* length) {
+//     return callback(userData, path, contents, length);
+// }
+import "C"
+
+const defaultVirtualNamespace = "virtual"
+
+var virtualLoadersByExtension = map[string]api.Loader{
+	".css":  api.LoaderCSS,
+	".json": api.LoaderJSON,
+	".jsx":  api.LoaderJSX,
+	".ts":   api.LoaderTS,
+	".tsx":  api.LoaderTSX,
+}
+
+// VirtualModulePlugin serves the given packages from load instead of disk.
+// An import matches if it's one of modules or a subpath of one, so "pkg"
+// covers both "pkg" and "pkg/utils.js". Relative imports between virtual
+// files stay virtual, and resolve from "pkg" as if it were "pkg/index.js".
+// Files are loaded as JS unless their extension says otherwise.
+func VirtualModulePlugin(namespace string, modules []string, load func(path string) ([]byte, error)) api.Plugin {
+	quotedModules := make([]string, len(modules))
+	moduleRoots := make(map[string]bool, len(modules))
+	for index, module := range modules {
+		quotedModules[index] = regexp.QuoteMeta(module)
+		moduleRoots[module] = true
+	}
+	moduleFilter := fmt.Sprintf(`^(%s)(/.*)?$`, strings.Join(quotedModules, "|"))
+
+	return api.Plugin{
+		Name: "virtual-modules",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(
+				api.OnResolveOptions{Filter: moduleFilter},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					return api.OnResolveResult{Path: args.Path, Namespace: namespace}, nil
+				},
+			)
+
+			build.OnResolve(
+				api.OnResolveOptions{Filter: `^\.\.?/`, Namespace: namespace},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					// A bare package name stands for the package's root
+					// directory, like an index file would
+					importerDir := path.Dir(args.Importer)
+					if moduleRoots[args.Importer] {
+						importerDir = args.Importer
+					}
+					resolvedPath := path.Join(importerDir, args.Path)
+					return api.OnResolveResult{Path: resolvedPath, Namespace: namespace}, nil
+				},
+			)
+
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: namespace},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					contents, err := load(args.Path)
+					if err != nil {
+						return api.OnLoadResult{}, err
+					}
+
+					loader, exists := virtualLoadersByExtension[path.Ext(args.Path)]
+					if !exists {
+						loader = api.LoaderJS
+					}
+					contentsString := string(contents)
+					return api.OnLoadResult{Contents: &contentsString, Loader: loader}, nil
+				},
+			)
+		},
+	}
+}
+
+//export BundleAllWithLoader
+func BundleAllWithLoader(
+	rawOptions *C.char,
+	callback C.module_loader_callback,
+	userData unsafe.Pointer,
+) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll, but every import of a package listed in the
+	 * "virtualModules" option is read from the host through callback rather
+	 * than from node_modules. "virtualNamespace" (default "virtual") names
+	 * the namespace they're reported under in errors and the metafile.
+	 *
+	 * callback receives the module path, like "pkg" or "pkg/utils.js", and
+	 * returns 0 with *contents set to a malloc()ed buffer of *length bytes,
+	 * which Go takes ownership of and frees. Any other return value means the
+	 * module doesn't exist. esbuild loads modules in parallel, so callback
+	 * may be called from several threads at once. userData is passed through.
+	 */
+	if callback == nil {
+		return nil, C.CString("No module loader callback provided")
+	}
+
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	if len(options.VirtualModules) == 0 {
+		return nil, C.CString("No virtualModules provided")
+	}
+
+	namespace := options.VirtualNamespace
+	if namespace == "" {
+		namespace = defaultVirtualNamespace
+	}
+
+	load := func(modulePath string) ([]byte, error) {
+		rawPath := C.CString(modulePath)
+		defer C.free(unsafe.Pointer(rawPath))
+
+		var contents *C.char
+		var length C.int32_t
+		status := C.invoke_module_loader_callback(callback, userData, rawPath, &contents, &length)
+		if status != 0 {
+			return nil, fmt.Errorf("Virtual module %s not found (status %d)", modulePath, status)
+		}
+		if contents == nil {
+			return []byte{}, nil
+		}
+		defer C.free(unsafe.Pointer(contents))
+		return C.GoBytes(unsafe.Pointer(contents), C.int(length)), nil
+	}
+	options.Plugins = append(options.Plugins, VirtualModulePlugin(namespace, options.VirtualModules, load))
+
+	result, err := bundleAll(options, writeBundleOutputs)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
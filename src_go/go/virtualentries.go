@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+const virtualEntryNamespace = "virtual-entry"
+
+// VirtualEntrypoint is an entry module generated in memory, like a route
+// table importing every page, that's built without writing it to disk.
+type VirtualEntrypoint struct {
+	// Slash-separated name like "routes/home.jsx". The extension picks the
+	// loader, and the rest is the output path, so this one is written to
+	// routes/home.js, naming templates permitting.
+	Name     string `json:"name"`
+	Contents string `json:"contents"`
+	// Directory the entry's relative imports resolve from, itself relative
+	// to the working directory. The working directory if empty.
+	ResolveDir string `json:"resolveDir"`
+}
+
+// ValidateVirtualEntrypoints checks that every name is a unique relative
+// path that stays inside the outdir.
+func ValidateVirtualEntrypoints(entrypoints []VirtualEntrypoint) error {
+	seen := make(map[string]bool, len(entrypoints))
+	for _, entrypoint := range entrypoints {
+		name := entrypoint.Name
+		if name == "" {
+			return fmt.Errorf("Virtual entrypoint names can't be empty")
+		}
+		cleanName := path.Clean(name)
+		if path.IsAbs(name) || cleanName != name || cleanName == "." || cleanName == ".." || strings.HasPrefix(cleanName, "../") {
+			return fmt.Errorf("Invalid virtual entrypoint %q: expected a relative path like \"routes/home.jsx\"", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("Virtual entrypoint %q is listed more than once", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// checkResolveDirExists fails early for a virtual entrypoint whose
+// ResolveDir is missing, which esbuild would only report as every relative
+// import in it failing to resolve.
+func checkResolveDirExists(entrypoint VirtualEntrypoint, workingDir string) error {
+	resolveDir := entrypoint.ResolveDir
+	if !filepath.IsAbs(resolveDir) {
+		resolveDir = filepath.Join(workingDir, resolveDir)
+	}
+	info, err := os.Stat(resolveDir)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return fmt.Errorf("Resolve directory of virtual entrypoint %q not found: %s", entrypoint.Name, entrypoint.ResolveDir)
+	}
+	return err
+}
+
+// virtualEntryPoints lists the entrypoints for esbuild, with output paths
+// that leave out the namespace esbuild would otherwise put in them.
+func virtualEntryPoints(entrypoints []VirtualEntrypoint) []api.EntryPoint {
+	entryPoints := make([]api.EntryPoint, len(entrypoints))
+	for index, entrypoint := range entrypoints {
+		entryPoints[index] = api.EntryPoint{
+			InputPath:  virtualEntryNamespace + ":" + entrypoint.Name,
+			OutputPath: strings.TrimSuffix(entrypoint.Name, path.Ext(entrypoint.Name)),
+		}
+	}
+	return entryPoints
+}
+
+// VirtualEntrypointsPlugin loads the virtual entrypoints' contents. Their
+// imports then resolve from ResolveDir like any file on disk's would.
+func VirtualEntrypointsPlugin(entrypoints []VirtualEntrypoint, workingDir string) api.Plugin {
+	entrypointsByName := make(map[string]VirtualEntrypoint, len(entrypoints))
+	for _, entrypoint := range entrypoints {
+		entrypointsByName[entrypoint.Name] = entrypoint
+	}
+
+	return api.Plugin{
+		Name: "virtual-entrypoints",
+		Setup: func(build api.PluginBuild) {
+			build.OnResolve(
+				api.OnResolveOptions{Filter: "^" + virtualEntryNamespace + ":"},
+				func(args api.OnResolveArgs) (api.OnResolveResult, error) {
+					return api.OnResolveResult{
+						Path:      strings.TrimPrefix(args.Path, virtualEntryNamespace+":"),
+						Namespace: virtualEntryNamespace,
+					}, nil
+				},
+			)
+
+			build.OnLoad(
+				api.OnLoadOptions{Filter: `.*`, Namespace: virtualEntryNamespace},
+				func(args api.OnLoadArgs) (api.OnLoadResult, error) {
+					entrypoint, exists := entrypointsByName[args.Path]
+					if !exists {
+						return api.OnLoadResult{}, fmt.Errorf("Virtual entrypoint %s not found", args.Path)
+					}
+
+					resolveDir := entrypoint.ResolveDir
+					if !filepath.IsAbs(resolveDir) {
+						resolveDir = filepath.Join(workingDir, resolveDir)
+					}
+					loader, exists := virtualLoadersByExtension[path.Ext(entrypoint.Name)]
+					if !exists {
+						loader = api.LoaderJS
+					}
+					return api.OnLoadResult{
+						Contents:   &entrypoint.Contents,
+						ResolveDir: resolveDir,
+						Loader:     loader,
+					}, nil
+				},
+			)
+		},
+	}
+}
+
+//export BundleAllWithEntrypoints
+func BundleAllWithEntrypoints(rawOptions *C.char, rawEntrypoints *C.char) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll, adding entrypoints whose source is passed in
+	 * rather than read from disk, for frameworks that generate entry modules.
+	 * rawEntrypoints is a JSON array like [{"name": "routes/home.jsx",
+	 * "contents": "import Home from './Home'; ...", "resolveDir": "app"}];
+	 * see VirtualEntrypoint. They're built alongside the "entrypoints"
+	 * option, which may be empty, and share chunks with them. The metafile
+	 * and manifest list them as "virtual-entry:<name>".
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	if err := json.Unmarshal([]byte(C.GoString(rawEntrypoints)), &options.VirtualEntrypoints); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid virtual entrypoints JSON: %s", err))
+	}
+
+	result, err := bundleAll(options, writeBundleOutputs)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
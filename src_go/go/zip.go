@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+import "C"
+
+// ZipBundleResult is a BundleResult for a build written to a zip archive.
+type ZipBundleResult struct {
+	BundleResult
+	// Size of the finished archive
+	ZipBytes int64 `json:"zipBytes"`
+}
+
+// WriteOutputZip writes the output files into a zip archive at zipPath, named
+// by their path relative to outdir, so extracting it recreates the output
+// directory. Entries are sorted and carry no timestamps, so the same outputs
+// always produce the same archive. The archive is written next to zipPath
+// and moved into place once complete, so a failed build never leaves a
+// partial one behind. Returns the archive's size.
+func WriteOutputZip(outputFiles []api.OutputFile, outdir string, zipPath string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(zipPath), 0755); err != nil {
+		return 0, err
+	}
+	partialPath := zipPath + ".partial"
+	archive, err := os.Create(partialPath)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(partialPath)
+	defer archive.Close()
+
+	writer := zip.NewWriter(archive)
+	for _, outputFile := range SortOutputFiles(outputFiles) {
+		name, err := filepath.Rel(outdir, outputFile.Path)
+		if err != nil {
+			return 0, err
+		}
+		name = filepath.ToSlash(name)
+		if strings.HasPrefix(name, "../") {
+			return 0, fmt.Errorf("Output %s is outside the output directory %s", outputFile.Path, outdir)
+		}
+
+		entry, err := writer.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate})
+		if err != nil {
+			return 0, err
+		}
+		if _, err := entry.Write(outputFile.Contents); err != nil {
+			return 0, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+
+	info, err := archive.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if err := archive.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(partialPath, zipPath); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+//export BundleAllToZip
+func BundleAllToZip(rawOptions *C.char, rawZipPath *C.char) (returnResult *C.char, returnError *C.char) {
+	/*
+	 * Builds like BundleAll but writes the outputs into a zip archive at
+	 * rawZipPath instead of the output directory, for deployment flows that
+	 * upload a single bundle.zip. The options' outdir still decides where
+	 * the outputs would go, which is what the archive's layout and the
+	 * manifest's paths are based on; nothing else is written there, except
+	 * NOTICES.txt when emitNotices is set.
+	 *
+	 * Returns a ZipBundleResult as JSON. The manifest is always included, as
+	 * if emitManifest were set.
+	 */
+	var options BundleOptions
+	if err := json.Unmarshal([]byte(C.GoString(rawOptions)), &options); err != nil {
+		return nil, C.CString(fmt.Sprintf("Invalid bundle options: %s", err))
+	}
+	options.EmitManifest = true
+	if options.CleanStale {
+		return nil, C.CString("cleanStale only applies to builds written to the outdir")
+	}
+
+	zipPath := C.GoString(rawZipPath)
+	if zipPath == "" {
+		return nil, C.CString("No zip path provided")
+	}
+
+	var zipBytes int64
+	emit := func(outputFiles []api.OutputFile, outdir string, _ *ioPool) error {
+		var err error
+		zipBytes, err = WriteOutputZip(outputFiles, outdir, zipPath)
+		return err
+	}
+
+	result, err := bundleAll(options, emit)
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+
+	payload, err := json.Marshal(ZipBundleResult{BundleResult: result, ZipBytes: zipBytes})
+	if err != nil {
+		return nil, C.CString(err.Error())
+	}
+	return C.CString(string(payload)), nil
+}
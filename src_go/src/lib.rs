@@ -6,8 +6,8 @@ include!(concat!(env!("OUT_DIR"), "/bindings.rs"));
 
 extern crate libc;
 
-use std::ffi::{c_int, CString};
-use std::sync::{mpsc, Arc};
+use std::ffi::{c_char, c_int, c_void, CStr, CString};
+use std::sync::{mpsc, Arc, Mutex};
 use std::thread;
 
 pub fn get_build_context(
@@ -15,12 +15,16 @@ pub fn get_build_context(
     node_modules_path: &str,
     environment: &str,
     live_reload_port: i32,
+    live_reload_host: &str,
     is_server: bool,
+    aliases: &str,
 ) -> Result<c_int, String> {
     let c_filename = CString::new(filename).unwrap();
     let c_node_modules_path = CString::new(node_modules_path).unwrap();
     let c_environment = CString::new(environment).unwrap();
+    let c_live_reload_host = CString::new(live_reload_host).unwrap();
     let is_server = if is_server { 1 } else { 0 };
+    let c_aliases = CString::new(aliases).unwrap();
 
     unsafe {
         let result = GetBuildContext(
@@ -28,7 +32,9 @@ pub fn get_build_context(
             c_node_modules_path.into_raw(),
             c_environment.into_raw(),
             live_reload_port,
+            c_live_reload_host.into_raw(),
             is_server,
+            c_aliases.into_raw(),
         );
         let id = result.r0;
         let error = result.r1;
@@ -45,6 +51,38 @@ pub fn get_build_context(
     }
 }
 
+pub fn get_build_contexts(
+    specs_json: &str,
+    node_modules_path: &str,
+    environment: &str,
+    live_reload_port: i32,
+    live_reload_host: &str,
+) -> Result<Vec<c_int>, String> {
+    let c_specs_json = CString::new(specs_json).unwrap();
+    let c_node_modules_path = CString::new(node_modules_path).unwrap();
+    let c_environment = CString::new(environment).unwrap();
+    let c_live_reload_host = CString::new(live_reload_host).unwrap();
+
+    let payload = unsafe {
+        let result = GetBuildContexts(
+            c_specs_json.into_raw(),
+            c_node_modules_path.into_raw(),
+            c_environment.into_raw(),
+            live_reload_port,
+            c_live_reload_host.into_raw(),
+        );
+        take_result(result.r0, result.r1)?
+    };
+
+    // The IDs come back as a flat JSON array of integers
+    Ok(payload
+        .trim_matches(|c| c == '[' || c == ']')
+        .split(',')
+        .filter(|id| !id.is_empty())
+        .map(|id| id.parse().unwrap())
+        .collect())
+}
+
 pub fn rebuild_context(context_ptr: c_int) -> Result<(), String> {
     unsafe {
         let error = RebuildContext(context_ptr);
@@ -60,151 +98,5421 @@ pub fn rebuild_context(context_ptr: c_int) -> Result<(), String> {
     }
 }
 
-type Callback = dyn Fn(c_int) + Send + Sync;
+/// Rebuilds like `rebuild_context`, returning whether the call shared a rebuild queued by a
+/// concurrent call instead of running its own.
+pub fn rebuild_context_coalesced(context_ptr: c_int) -> Result<bool, String> {
+    unsafe {
+        let result = RebuildContextCoalesced(context_ptr);
+        let coalesced = result.r0 == 1;
+        let error = result.r1;
 
-pub fn rebuild_contexts(ids: Vec<c_int>, callback: Arc<Box<Callback>>) -> Result<(), Vec<String>> {
-    let (tx, rx) = mpsc::channel();
-    let mut handles = Vec::new();
+        if error.is_null() {
+            Ok(coalesced)
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
 
-    for id in ids.clone().into_iter() {
-        let tx = tx.clone();
+/// Delays every rebuild by `debounce_ms` so requests from a burst of saves share it.
+pub fn set_rebuild_debounce(debounce_ms: i32) {
+    unsafe { SetRebuildDebounce(debounce_ms) }
+}
 
-        let handle = thread::spawn(move || {
-            unsafe {
-                let error_ptr = RebuildContext(id);
-                let result = if !error_ptr.is_null() {
-                    let error_cstr = CString::from_raw(error_ptr);
-                    let error_string = error_cstr
-                        .into_string()
-                        .unwrap_or_else(|_| String::from("Unknown error"));
-                    Some(error_string)
-                } else {
-                    None
-                };
+/// Rebuilds only the contexts whose entrypoints are in `paths`, in parallel.
+pub fn rebuild_entrypoints(paths: &[&str]) -> Result<(), String> {
+    let c_paths: Vec<CString> = paths
+        .iter()
+        .map(|path| CString::new(*path).unwrap())
+        .collect();
+    let mut path_ptrs: Vec<*mut c_char> = c_paths
+        .iter()
+        .map(|path| path.as_ptr() as *mut c_char)
+        .collect();
 
-                // Send both the ID and result to the main thread via channel
-                tx.send((id, result.clone())).unwrap();
-            }
-        });
-        handles.push(handle);
+    unsafe {
+        let error = RebuildEntrypoints(path_ptrs.as_mut_ptr(), path_ptrs.len() as c_int);
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
     }
+}
 
-    // Cleanup handles and collect errors
-    let mut errors = Vec::new();
+/// Rebuilds the contexts in `ids` for a batch of file changes (a JSON array of paths),
+/// but only if one of them has an extension in `reload_extensions_json`, like
+/// `[".ts", ".tsx"]`. `on_rebuilt` is called with each context that rebuilt cleanly.
+pub fn rebuild_contexts_for_changes<F>(
+    ids: &[c_int],
+    changes_json: &str,
+    reload_extensions_json: &str,
+    mut on_rebuilt: F,
+) -> Result<(), String>
+where
+    F: FnMut(c_int),
+{
+    unsafe extern "C" fn trampoline<F: FnMut(c_int)>(user_data: *mut c_void, id: i32) {
+        let on_rebuilt = &mut *(user_data as *mut F);
+        on_rebuilt(id);
+    }
 
-    // Collect results in the order they are completed
-    for _ in 0..ids.len() {
-        if let Ok((id, err)) = rx.recv() {
-            if let Some(error) = err {
-                errors.push(error);
-            } else {
-                // Call the callback with the ID
-                callback(id);
-            }
+    let mut ids = ids.to_vec();
+    let c_changes_json = CString::new(changes_json).unwrap();
+    let c_reload_extensions_json = CString::new(reload_extensions_json).unwrap();
+
+    unsafe {
+        let error = RebuildContextsForChanges(
+            ids.as_mut_ptr(),
+            ids.len() as c_int,
+            c_changes_json.into_raw(),
+            c_reload_extensions_json.into_raw(),
+            Some(trampoline::<F>),
+            &mut on_rebuilt as *mut F as *mut c_void,
+        );
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
         }
     }
+}
 
-    // Close out the workers
-    for handle in handles {
-        handle.join().unwrap();
+/// Starts esbuild's dev server for the context and returns the bound port. Pass 0 to
+/// let esbuild pick one.
+pub fn start_serve(context_ptr: c_int, port: c_int) -> Result<c_int, String> {
+    unsafe {
+        let result = StartServe(context_ptr, port);
+        let bound_port = result.r0;
+        let error = result.r1;
+
+        if error.is_null() {
+            Ok(bound_port)
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
     }
+}
 
-    if errors.is_empty() {
-        Ok(())
+pub fn stop_serve(context_ptr: c_int) -> Result<(), String> {
+    unsafe {
+        let error = StopServe(context_ptr);
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+/// Updates the context's defines and rebuilds it. Returns JSON with whether the context was
+/// recreated, the output changes, and how long the update and rebuild took.
+pub fn update_context_defines_and_rebuild(
+    context_ptr: c_int,
+    defines_json: &str,
+) -> Result<String, String> {
+    let c_defines_json = CString::new(defines_json).unwrap();
+
+    unsafe {
+        let result = UpdateContextDefinesAndRebuild(context_ptr, c_defines_json.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+pub fn update_context_defines(context_ptr: c_int, defines_json: &str) -> Result<(), String> {
+    let c_defines_json = CString::new(defines_json).unwrap();
+
+    unsafe {
+        let error = UpdateContextDefines(context_ptr, c_defines_json.into_raw());
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+// Converts a Go (payload, error) string pair into a Result, taking ownership of
+// whichever string was returned
+unsafe fn take_result(payload: *mut c_char, error: *mut c_char) -> Result<String, String> {
+    if error.is_null() {
+        let payload_str = CString::from_raw(payload);
+        Ok(payload_str.into_string().unwrap())
     } else {
-        Err(errors)
+        let error_str = CString::from_raw(error);
+        let error_string = error_str
+            .into_string()
+            .unwrap_or_else(|_| String::from("Unknown error"));
+        Err(error_string)
     }
 }
 
-pub fn remove_context(context_ptr: c_int) {
+pub fn bundle_all(options_json: &str) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
+
     unsafe {
-        RemoveContext(context_ptr);
+        let result = BundleAll(c_options_json.into_raw());
+        take_result(result.r0, result.r1)
     }
 }
 
-#[cfg(test)]
-mod tests {
-    use super::*;
-    use std::fs;
-    use tempfile::tempdir;
+/// Builds like `bundle_all`, but when `incremental` is set, only rebuilds the entrypoints whose
+/// inputs changed since the build that returned `prior_metafile`. The others keep that build's
+/// outputs and are listed under "reused". Without a prior metafile everything is built.
+pub fn bundle_all_incremental(
+    options_json: &str,
+    incremental: bool,
+    prior_metafile: Option<&str>,
+) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
+    let c_prior_metafile = prior_metafile.map(|metafile| CString::new(metafile).unwrap());
+    let incremental = if incremental { 1 } else { 0 };
 
-    #[test]
-    fn test_build_js() {
-        let temp_dir = tempdir().unwrap();
-        let js_file_path = temp_dir.path().join("ssr.js");
-        let output_file_path = temp_dir.path().join("ssr.js.out");
+    unsafe {
+        let result = BundleAllIncremental(
+            c_options_json.into_raw(),
+            incremental,
+            c_prior_metafile.map_or(std::ptr::null_mut(), CString::into_raw),
+        );
+        take_result(result.r0, result.r1)
+    }
+}
 
-        // Write a simple javascript function to a file in a tmp directory
-        let initial_js = r##"export const Index = () => "<INITIAL>";"##;
-        fs::write(&js_file_path, initial_js).unwrap();
+/// Builds like `bundle_all` but writes the outputs into a zip archive at `zip_path`,
+/// laid out relative to the outdir. The result adds "zipBytes" and always has a manifest.
+pub fn bundle_all_to_zip(options_json: &str, zip_path: &str) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
+    let c_zip_path = CString::new(zip_path).unwrap();
 
-        let context_id =
-            get_build_context(&js_file_path.to_str().unwrap(), "", "development", 0, true).unwrap();
-        assert_ne!(context_id, 0);
+    unsafe {
+        let result = BundleAllToZip(c_options_json.into_raw(), c_zip_path.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
 
-        rebuild_context(context_id).unwrap();
-        assert!(output_file_path.exists());
+/// Builds like `bundle_all`, adding entrypoints generated in memory. `entrypoints_json` is a
+/// JSON array of `{"name", "contents", "resolveDir"}` objects; imports in each resolve from
+/// its resolveDir, relative to the working directory, like a file on disk's would.
+pub fn bundle_all_with_entrypoints(
+    options_json: &str,
+    entrypoints_json: &str,
+) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
+    let c_entrypoints_json = CString::new(entrypoints_json).unwrap();
 
-        // Get the output file contents and check that <html> is in the output
-        let output = fs::read_to_string(&output_file_path).unwrap();
-        println!("Output 1: {}", output);
-        assert!(
-            output.contains("<INITIAL>"),
-            "Output does not contain expected <INITIAL> tag"
+    unsafe {
+        let result =
+            BundleAllWithEntrypoints(c_options_json.into_raw(), c_entrypoints_json.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Builds the same options once per format (a JSON array like `["esm", "cjs"]`), each
+/// into its own subdirectory of the outdir. Returns a JSON object of format to result.
+pub fn bundle_multi_format(options_json: &str, formats_json: &str) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
+    let c_formats_json = CString::new(formats_json).unwrap();
+
+    unsafe {
+        let result = BundleMultiFormat(c_options_json.into_raw(), c_formats_json.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Builds like `bundle_all` but streams outputs to `on_chunk` instead of writing them
+/// to disk. Called with (path, chunk, is_last) for each chunk, one file at a time.
+pub fn bundle_all_streaming<F>(
+    options_json: &str,
+    chunk_size: i32,
+    mut on_chunk: F,
+) -> Result<String, String>
+where
+    F: FnMut(&str, &[u8], bool),
+{
+    unsafe extern "C" fn trampoline<F: FnMut(&str, &[u8], bool)>(
+        user_data: *mut c_void,
+        path: *const c_char,
+        data: *const c_char,
+        length: i32,
+        is_last: i32,
+    ) {
+        let on_chunk = &mut *(user_data as *mut F);
+        let path = CStr::from_ptr(path).to_string_lossy();
+        let data = if length == 0 {
+            &[][..]
+        } else {
+            std::slice::from_raw_parts(data as *const u8, length as usize)
+        };
+        on_chunk(&path, data, is_last == 1);
+    }
+
+    let c_options_json = CString::new(options_json).unwrap();
+
+    unsafe {
+        let result = BundleAllStreaming(
+            c_options_json.into_raw(),
+            Some(trampoline::<F>),
+            &mut on_chunk as *mut F as *mut c_void,
+            chunk_size,
         );
+        take_result(result.r0, result.r1)
+    }
+}
 
-        // Update the file
-        let updated_js = r##"export const Index = () => "<UPDATED>";"##;
-        fs::write(&js_file_path, updated_js).unwrap();
+/// Builds like `bundle_all` but hands each output file to `on_file` as (path, contents)
+/// instead of writing it to disk. Files arrive one at a time ordered by path. Returning
+/// a non-zero status from `on_file` skips the remaining files and fails the build.
+pub fn bundle_all_with_callback<F>(options_json: &str, mut on_file: F) -> Result<String, String>
+where
+    F: FnMut(&str, &[u8]) -> i32,
+{
+    unsafe extern "C" fn trampoline<F: FnMut(&str, &[u8]) -> i32>(
+        user_data: *mut c_void,
+        path: *const c_char,
+        data: *const c_char,
+        length: i32,
+    ) -> i32 {
+        let on_file = &mut *(user_data as *mut F);
+        let path = CStr::from_ptr(path).to_string_lossy();
+        let data = if length == 0 {
+            &[][..]
+        } else {
+            std::slice::from_raw_parts(data as *const u8, length as usize)
+        };
+        on_file(&path, data)
+    }
 
-        rebuild_context(context_id).unwrap();
+    let c_options_json = CString::new(options_json).unwrap();
 
-        // Check that <div> is in the updated output
-        let updated_output = fs::read_to_string(&output_file_path).unwrap();
-        println!("Output 2: {}", output);
-        assert!(
-            updated_output.contains("<UPDATED>"),
-            "Updated output does not contain expected <UPDATED> tag"
+    unsafe {
+        let result = BundleAllWithCallback(
+            c_options_json.into_raw(),
+            Some(trampoline::<F>),
+            &mut on_file as *mut F as *mut c_void,
         );
+        take_result(result.r0, result.r1)
     }
+}
 
-    #[test]
-    fn test_rebuild_contexts() {
-        let temp_dir = tempdir().unwrap();
-        let js_file_path = temp_dir.path().join("ssr.js");
-        let output_file_path = temp_dir.path().join("ssr.js.out");
+/// Builds like `bundle_all` but loads the packages listed in the `virtualModules` option
+/// from `load_module` instead of disk. `load_module` gets paths like "pkg" or
+/// "pkg/utils.js" and returns `None` for modules that don't exist. esbuild loads modules
+/// in parallel, so it may be called from several threads at once.
+pub fn bundle_all_with_loader<F>(options_json: &str, load_module: F) -> Result<String, String>
+where
+    F: Fn(&str) -> Option<Vec<u8>> + Sync,
+{
+    unsafe extern "C" fn trampoline<F: Fn(&str) -> Option<Vec<u8>> + Sync>(
+        user_data: *mut c_void,
+        path: *const c_char,
+        contents: *mut *mut c_char,
+        length: *mut i32,
+    ) -> i32 {
+        let load_module = &*(user_data as *const F);
+        let path = CStr::from_ptr(path).to_string_lossy();
+        match load_module(&path) {
+            Some(bytes) => {
+                // Go takes ownership of the buffer and frees it
+                let buffer = libc::malloc(bytes.len().max(1)) as *mut c_char;
+                std::ptr::copy_nonoverlapping(bytes.as_ptr() as *const c_char, buffer, bytes.len());
+                *contents = buffer;
+                *length = bytes.len() as i32;
+                0
+            }
+            None => 1,
+        }
+    }
 
-        // Write a simple javascript function to a file in a tmp directory
-        let initial_js = r##"export const Index = () => "<INITIAL>";"##;
-        fs::write(&js_file_path, initial_js).unwrap();
+    let c_options_json = CString::new(options_json).unwrap();
 
-        let context_id =
-            get_build_context(&js_file_path.to_str().unwrap(), "", "development", 0, true).unwrap();
-        assert_ne!(context_id, 0);
+    unsafe {
+        let result = BundleAllWithLoader(
+            c_options_json.into_raw(),
+            Some(trampoline::<F>),
+            &load_module as *const F as *mut c_void,
+        );
+        take_result(result.r0, result.r1)
+    }
+}
 
-        rebuild_contexts(vec![context_id]).unwrap();
-        assert!(output_file_path.exists());
+/// Builds like `bundle_all` but passes each CSS output through `process` before it's
+/// written, as a hook for PostCSS or Tailwind. `process` gets the output's absolute path
+/// and contents and returns the new contents, or a nonzero status to fail the build.
+pub fn bundle_all_with_css_processor<F>(options_json: &str, process: F) -> Result<String, String>
+where
+    F: Fn(&str, &str) -> Result<String, i32>,
+{
+    unsafe extern "C" fn trampoline<F: Fn(&str, &str) -> Result<String, i32>>(
+        user_data: *mut c_void,
+        path: *const c_char,
+        contents: *const c_char,
+        length: i32,
+        output: *mut *mut c_char,
+        output_length: *mut i32,
+    ) -> i32 {
+        let process = &*(user_data as *const F);
+        let path = CStr::from_ptr(path).to_string_lossy();
+        let contents = std::slice::from_raw_parts(contents as *const u8, length as usize);
+        match process(&path, &String::from_utf8_lossy(contents)) {
+            Ok(processed) => {
+                // Go takes ownership of the buffer and frees it
+                let buffer = libc::malloc(processed.len().max(1)) as *mut c_char;
+                std::ptr::copy_nonoverlapping(
+                    processed.as_ptr() as *const c_char,
+                    buffer,
+                    processed.len(),
+                );
+                *output = buffer;
+                *output_length = processed.len() as i32;
+                0
+            }
+            Err(status) => status,
+        }
     }
 
-    #[test]
-    fn test_exception_thrown() {
-        let temp_dir = tempdir().unwrap();
-        let js_file_path = temp_dir.path().join("ssr.js");
-        let output_file_path = temp_dir.path().join("ssr.js.out");
+    let c_options_json = CString::new(options_json).unwrap();
 
-        // Write a simple javascript function to a file in a tmp directory
-        let initial_js = r##"export const Index INVALID SYNTAX () => "<INITIAL>";"##;
-        fs::write(&js_file_path, initial_js).unwrap();
+    unsafe {
+        let result = BundleAllWithCSSProcessor(
+            c_options_json.into_raw(),
+            Some(trampoline::<F>),
+            &process as *const F as *mut c_void,
+        );
+        take_result(result.r0, result.r1)
+    }
+}
 
-        let context_id =
-            get_build_context(&js_file_path.to_str().unwrap(), "", "development", 0, true).unwrap();
-        assert_ne!(context_id, 0);
+pub fn resolve_bundle_config(options_json: &str) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
 
-        let result = rebuild_context(context_id);
-        assert!(
-            result.is_err(),
-            "Expected an error during rebuild_context, but none occurred."
+    unsafe {
+        let result = ResolveBundleConfig(c_options_json.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Compares two sets of bundle options as BundleAll would resolve them and returns a
+/// JSON array of `{"option", "a", "b"}` for each option that differs.
+pub fn diff_options(options_a: &str, options_b: &str) -> Result<String, String> {
+    let c_options_a = CString::new(options_a).unwrap();
+    let c_options_b = CString::new(options_b).unwrap();
+
+    unsafe {
+        let result = DiffOptions(c_options_a.into_raw(), c_options_b.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Checks bundle options without building and returns a JSON array of every problem.
+pub fn validate_options(options_json: &str) -> Result<String, String> {
+    let c_options_json = CString::new(options_json).unwrap();
+
+    unsafe {
+        let result = ValidateOptions(c_options_json.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+pub fn transform_with_source_map(
+    source: &str,
+    sourcefile: &str,
+    loader: &str,
+    input_source_map: &str,
+) -> Result<(String, String), String> {
+    let c_source = CString::new(source).unwrap();
+    let c_sourcefile = CString::new(sourcefile).unwrap();
+    let c_loader = CString::new(loader).unwrap();
+    let c_input_source_map = CString::new(input_source_map).unwrap();
+
+    unsafe {
+        let result = TransformWithSourceMap(
+            c_source.into_raw(),
+            c_sourcefile.into_raw(),
+            c_loader.into_raw(),
+            c_input_source_map.into_raw(),
         );
-        assert!(!output_file_path.exists());
+        let code = take_result(result.r0, result.r2)?;
+        let source_map = CString::from_raw(result.r1).into_string().unwrap();
+        Ok((code, source_map))
+    }
+}
+
+/// Runs a tiny TSX transform to confirm the embedded esbuild works, for a startup check.
+/// Returns a diagnostic describing the failure otherwise.
+pub fn self_test() -> Result<(), String> {
+    unsafe {
+        let error = SelfTest();
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+/// Loads the given packages (a JSON array of import paths) and everything they import,
+/// so the first real build reads from a warm file cache. Returns a JSON report.
+pub fn prewarm_dependencies(
+    node_modules_path: &str,
+    packages_json: &str,
+) -> Result<String, String> {
+    let c_node_modules_path = CString::new(node_modules_path).unwrap();
+    let c_packages_json = CString::new(packages_json).unwrap();
+
+    unsafe {
+        let result =
+            PrewarmDependencies(c_node_modules_path.into_raw(), c_packages_json.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Transforms many snippets in one call. `sources` and `loaders` are parallel; an empty
+/// loader means "js". Returns a JSON array of {"code", "error"} in input order.
+pub fn transform_many(sources: &[&str], loaders: &[&str]) -> Result<String, String> {
+    assert_eq!(sources.len(), loaders.len());
+
+    let c_sources: Vec<CString> = sources
+        .iter()
+        .map(|source| CString::new(*source).unwrap())
+        .collect();
+    let c_loaders: Vec<CString> = loaders
+        .iter()
+        .map(|loader| CString::new(*loader).unwrap())
+        .collect();
+    let mut source_ptrs: Vec<*mut c_char> = c_sources
+        .iter()
+        .map(|source| source.as_ptr() as *mut c_char)
+        .collect();
+    let mut loader_ptrs: Vec<*mut c_char> = c_loaders
+        .iter()
+        .map(|loader| loader.as_ptr() as *mut c_char)
+        .collect();
+
+    unsafe {
+        let result = TransformMany(
+            source_ptrs.as_mut_ptr(),
+            loader_ptrs.as_mut_ptr(),
+            source_ptrs.len() as c_int,
+        );
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Caches up to `size` transform results in memory, so repeating a transform with the
+/// same source and options skips esbuild. 0, the default, disables the cache.
+pub fn set_transform_cache_size(size: c_int) {
+    unsafe { SetTransformCacheSize(size) }
+}
+
+/// Drops every cached transform result and resets the hit and miss counts.
+pub fn clear_transform_cache() {
+    unsafe { ClearTransformCache() }
+}
+
+/// Returns {"entries", "capacity", "hits", "misses"} for the transform cache as JSON.
+pub fn get_transform_cache_stats() -> String {
+    unsafe {
+        CString::from_raw(GetTransformCacheStats())
+            .into_string()
+            .unwrap()
+    }
+}
+
+pub fn analyze_unused_code(metafile: &str) -> Result<String, String> {
+    let c_metafile = CString::new(metafile).unwrap();
+
+    unsafe {
+        let result = AnalyzeUnusedCode(c_metafile.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+pub fn set_context_keep_outputs(context_ptr: c_int, keep_outputs: bool) -> Result<(), String> {
+    let keep_outputs = if keep_outputs { 1 } else { 0 };
+
+    unsafe {
+        let error = SetContextKeepOutputs(context_ptr, keep_outputs);
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+/// Makes failed rebuilds of the context report only their first error, plus a count of
+/// the rest.
+pub fn set_context_fail_fast(context_ptr: c_int, fail_fast: bool) -> Result<(), String> {
+    let fail_fast = if fail_fast { 1 } else { 0 };
+
+    unsafe {
+        let error = SetContextFailFast(context_ptr, fail_fast);
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+/// Caps how many errors a failed rebuild of the context formats, plus a count of the rest.
+/// 0 formats every error.
+pub fn set_context_max_errors(context_ptr: c_int, max_errors: c_int) -> Result<(), String> {
+    unsafe {
+        let error = SetContextMaxErrors(context_ptr, max_errors);
+        if error.is_null() {
+            Ok(())
+        } else {
+            let error_str = CString::from_raw(error);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+pub fn get_context_output(context_ptr: c_int, path: &str) -> Result<Vec<u8>, String> {
+    let c_path = CString::new(path).unwrap();
+
+    unsafe {
+        let result = GetContextOutput(context_ptr, c_path.into_raw());
+        if !result.r2.is_null() {
+            let error_str = CString::from_raw(result.r2);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            return Err(error_string);
+        }
+
+        // The buffer is allocated with malloc on the Go side
+        let contents =
+            std::slice::from_raw_parts(result.r0 as *const u8, result.r1 as usize).to_vec();
+        libc::free(result.r0);
+        Ok(contents)
+    }
+}
+
+/// Returns the Content-Type to serve an output with, by its path or extension, falling
+/// back to application/octet-stream.
+pub fn get_output_content_type(path: &str) -> String {
+    let c_path = CString::new(path).unwrap();
+
+    unsafe {
+        CString::from_raw(GetOutputContentType(c_path.into_raw()))
+            .into_string()
+            .unwrap()
+    }
+}
+
+/// Compares the metafiles of two `bundle_all` results and returns the size deltas
+/// between them as JSON.
+pub fn diff_build_metafiles(previous: &str, current: &str) -> Result<String, String> {
+    let c_previous = CString::new(previous).unwrap();
+    let c_current = CString::new(current).unwrap();
+
+    unsafe {
+        let result = DiffBuildMetafiles(c_previous.into_raw(), c_current.into_raw());
+        take_result(result.r0, result.r1)
+    }
+}
+
+pub fn get_context_output_changes(context_ptr: c_int) -> Result<String, String> {
+    unsafe {
+        let result = GetContextOutputChanges(context_ptr);
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// When enabled, a rebuild that fails inside esbuild itself rather than in the code
+/// being built recreates the context from its options and retries once.
+pub fn set_recreate_on_internal_error(enabled: bool) {
+    unsafe { SetRecreateOnInternalError(if enabled { 1 } else { 0 }) }
+}
+
+/// Returns the outcome of the context's most recent rebuild as JSON, or "null" if it
+/// hasn't been rebuilt, without triggering a rebuild.
+pub fn get_last_result(context_ptr: c_int) -> Result<String, String> {
+    unsafe {
+        let result = GetLastResult(context_ptr);
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Returns a JSON array of the absolute paths of every file the context's last successful
+/// rebuild read, optionally leaving out node_modules.
+pub fn get_context_inputs(
+    context_ptr: c_int,
+    exclude_node_modules: bool,
+) -> Result<String, String> {
+    unsafe {
+        let result = GetContextInputs(context_ptr, if exclude_node_modules { 1 } else { 0 });
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Returns the context's effective build options as JSON, with secret-looking defines
+/// redacted.
+pub fn describe_context(context_ptr: c_int) -> Result<String, String> {
+    unsafe {
+        let result = DescribeContext(context_ptr);
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Returns a JSON array of the defines an SSR context and a client context replace
+/// differently, other than the SSR-only ones, as `{"name", "ssr", "client"}` objects.
+pub fn compare_context_defines(
+    ssr_context_ptr: c_int,
+    client_context_ptr: c_int,
+) -> Result<String, String> {
+    unsafe {
+        let result = CompareContextDefines(ssr_context_ptr, client_context_ptr);
+        take_result(result.r0, result.r1)
+    }
+}
+
+/// Returns aggregate stats about the live build contexts as JSON.
+pub fn get_build_context_stats() -> Result<String, String> {
+    unsafe {
+        let result = GetBuildContextStats();
+        take_result(result.r0, result.r1)
+    }
+}
+
+type Callback = dyn Fn(c_int) + Send + Sync;
+
+pub fn rebuild_contexts(ids: Vec<c_int>, callback: Arc<Box<Callback>>) -> Result<(), Vec<String>> {
+    let (tx, rx) = mpsc::channel();
+    let mut handles = Vec::new();
+
+    for id in ids.clone().into_iter() {
+        let tx = tx.clone();
+
+        let handle = thread::spawn(move || {
+            unsafe {
+                let error_ptr = RebuildContext(id);
+                let result = if !error_ptr.is_null() {
+                    let error_cstr = CString::from_raw(error_ptr);
+                    let error_string = error_cstr
+                        .into_string()
+                        .unwrap_or_else(|_| String::from("Unknown error"));
+                    Some(error_string)
+                } else {
+                    None
+                };
+
+                // Send both the ID and result to the main thread via channel
+                tx.send((id, result.clone())).unwrap();
+            }
+        });
+        handles.push(handle);
+    }
+
+    // Cleanup handles and collect errors
+    let mut errors = Vec::new();
+
+    // Collect results in the order they are completed
+    for _ in 0..ids.len() {
+        if let Ok((id, err)) = rx.recv() {
+            if let Some(error) = err {
+                errors.push(error);
+            } else {
+                // Call the callback with the ID
+                callback(id);
+            }
+        }
+    }
+
+    // Close out the workers
+    for handle in handles {
+        handle.join().unwrap();
+    }
+
+    if errors.is_empty() {
+        Ok(())
+    } else {
+        Err(errors)
+    }
+}
+
+static EVICTION_CALLBACK: Mutex<Option<Arc<Box<Callback>>>> = Mutex::new(None);
+
+unsafe extern "C" fn on_context_evicted(_user_data: *mut c_void, id: i32) {
+    let callback = EVICTION_CALLBACK.lock().unwrap().clone();
+    if let Some(callback) = callback {
+        callback(id);
+    }
+}
+
+/// Caps the number of live contexts, disposing the least recently rebuilt ones when a
+/// new context goes over the limit. 0 removes the cap. `on_evict` is called with the
+/// ID of each evicted context so it can be recreated on demand.
+pub fn set_max_contexts(max_contexts: c_int, on_evict: Option<Arc<Box<Callback>>>) {
+    *EVICTION_CALLBACK.lock().unwrap() = on_evict;
+
+    unsafe {
+        SetMaxContexts(max_contexts, Some(on_context_evicted), std::ptr::null_mut());
+    }
+}
+
+pub fn remove_context(context_ptr: c_int) -> bool {
+    unsafe { RemoveContext(context_ptr) == 1 }
+}
+
+/// Removes the context, cancelling a rebuild that's still running instead of waiting
+/// for it. Returns whether a rebuild was interrupted.
+pub fn cancel_and_remove(context_ptr: c_int) -> Result<bool, String> {
+    unsafe {
+        let result = CancelAndRemove(context_ptr);
+        if result.r1.is_null() {
+            Ok(result.r0 == 1)
+        } else {
+            let error_str = CString::from_raw(result.r1);
+            let error_string = error_str
+                .into_string()
+                .unwrap_or_else(|_| String::from("Unknown error"));
+            Err(error_string)
+        }
+    }
+}
+
+/// Disposes every context that hasn't rebuilt in the last `seconds`, skipping ones
+/// busy rebuilding. Returns how many were disposed and a JSON array of their filenames.
+pub fn dispose_contexts_older_than(seconds: i32) -> (usize, String) {
+    unsafe {
+        let result = DisposeContextsOlderThan(seconds);
+        let filenames = CString::from_raw(result.r1)
+            .into_string()
+            .unwrap_or_else(|_| String::from("[]"));
+        (result.r0 as usize, filenames)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::collections::HashMap;
+    use std::fs;
+    use std::sync::{RwLock, RwLockReadGuard};
+    use tempfile::tempdir;
+
+    // Eviction is process-wide, so tests that cap the number of contexts take this
+    // exclusively while every other test that creates contexts shares it
+    static CONTEXT_CAP: RwLock<()> = RwLock::new(());
+
+    fn shared_contexts() -> RwLockReadGuard<'static, ()> {
+        CONTEXT_CAP
+            .read()
+            .unwrap_or_else(|error| error.into_inner())
+    }
+
+    // Likewise for the transform cache, which tests that count hits take exclusively
+    static TRANSFORM_CACHE: RwLock<()> = RwLock::new(());
+
+    fn shared_transform_cache() -> RwLockReadGuard<'static, ()> {
+        TRANSFORM_CACHE
+            .read()
+            .unwrap_or_else(|error| error.into_inner())
+    }
+
+    // Extract a top-level string value from a compact JSON payload, since
+    // the crate doesn't otherwise need a JSON parser
+    fn json_string_value(json: &str, key: &str) -> String {
+        let prefix = format!("\"{}\":\"", key);
+        let start = json.find(&prefix).expect("Key not found") + prefix.len();
+        let end = start + json[start..].find('"').unwrap();
+        json[start..end].to_string()
+    }
+
+    // Extract an object value by matching braces outside of strings
+    fn json_object_value(json: &str, key: &str) -> String {
+        let prefix = format!("\"{}\":{{", key);
+        let start = json.find(&prefix).expect("Key not found") + prefix.len() - 1;
+        let (mut depth, mut in_string, mut escaped) = (0, false, false);
+        for (offset, c) in json[start..].char_indices() {
+            match c {
+                _ if escaped => escaped = false,
+                '\\' if in_string => escaped = true,
+                '"' => in_string = !in_string,
+                '{' if !in_string => depth += 1,
+                '}' if !in_string => {
+                    depth -= 1;
+                    if depth == 0 {
+                        return json[start..start + offset + 1].to_string();
+                    }
+                }
+                _ => {}
+            }
+        }
+        panic!("Unterminated object for {}", key);
+    }
+
+    #[test]
+    fn test_build_js() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        let output_file_path = temp_dir.path().join("ssr.js.out");
+
+        // Write a simple javascript function to a file in a tmp directory
+        let initial_js = r##"export const Index = () => "<INITIAL>";"##;
+        fs::write(&js_file_path, initial_js).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+        assert_ne!(context_id, 0);
+
+        rebuild_context(context_id).unwrap();
+        assert!(output_file_path.exists());
+
+        // Get the output file contents and check that <html> is in the output
+        let output = fs::read_to_string(&output_file_path).unwrap();
+        println!("Output 1: {}", output);
+        assert!(
+            output.contains("<INITIAL>"),
+            "Output does not contain expected <INITIAL> tag"
+        );
+
+        // Update the file
+        let updated_js = r##"export const Index = () => "<UPDATED>";"##;
+        fs::write(&js_file_path, updated_js).unwrap();
+
+        rebuild_context(context_id).unwrap();
+
+        // Check that <div> is in the updated output
+        let updated_output = fs::read_to_string(&output_file_path).unwrap();
+        println!("Output 2: {}", output);
+        assert!(
+            updated_output.contains("<UPDATED>"),
+            "Updated output does not contain expected <UPDATED> tag"
+        );
+    }
+
+    #[test]
+    fn test_missing_entrypoint() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("missing.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        let error = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap_err();
+        assert_eq!(
+            error,
+            format!("Entrypoint not found: {}", js_file_path.to_str().unwrap())
+        );
+
+        // No context was registered for the missing file
+        let error = rebuild_entrypoints(&[js_file_path.to_str().unwrap()]).unwrap_err();
+        assert!(error.contains("No context for entrypoint"));
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"}}"##,
+            js_file_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        let error = bundle_all(&options).unwrap_err();
+        assert!(error.contains("Entrypoint not found"));
+        assert!(!outdir_path.exists());
+    }
+
+    #[test]
+    fn test_rebuild_contexts() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        let output_file_path = temp_dir.path().join("ssr.js.out");
+
+        // Write a simple javascript function to a file in a tmp directory
+        let initial_js = r##"export const Index = () => "<INITIAL>";"##;
+        fs::write(&js_file_path, initial_js).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+        assert_ne!(context_id, 0);
+
+        rebuild_contexts(vec![context_id], Arc::new(Box::new(|_| {}))).unwrap();
+        assert!(output_file_path.exists());
+    }
+
+    #[test]
+    fn test_rebuild_contexts_for_changes() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("page.tsx");
+        let output_file_path = temp_dir.path().join("page.tsx.out");
+        fs::write(&js_file_path, r##"export const Index = () => "<RELOAD>";"##).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+
+        let mut rebuilt = Vec::new();
+        let readme_path = temp_dir.path().join("README.md");
+        let changes = format!(r##"["{}"]"##, readme_path.to_str().unwrap());
+        rebuild_contexts_for_changes(&[context_id], &changes, r##"[".ts", "tsx"]"##, |id| {
+            rebuilt.push(id)
+        })
+        .unwrap();
+        assert!(rebuilt.is_empty());
+        assert!(!output_file_path.exists());
+
+        let changes = format!(
+            r##"["{}", "{}"]"##,
+            readme_path.to_str().unwrap(),
+            js_file_path.to_str().unwrap()
+        );
+        rebuild_contexts_for_changes(&[context_id], &changes, r##"[".ts", "tsx"]"##, |id| {
+            rebuilt.push(id)
+        })
+        .unwrap();
+        assert_eq!(rebuilt, vec![context_id]);
+        assert!(output_file_path.exists());
+
+        remove_context(context_id);
+    }
+
+    #[test]
+    fn test_rebuild_writes_atomically() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("client.js");
+        let output_path = temp_dir.path().join("client.js.out");
+
+        // Large enough that writing the output takes a while
+        let source = |marker: &str| -> String {
+            (0..20_000)
+                .map(|index| format!("console.log(\"{}\", {});\n", marker, index))
+                .collect()
+        };
+        fs::write(&js_file_path, source("<FIRST>")).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+        rebuild_context(context_id).unwrap();
+
+        let done = Arc::new(std::sync::atomic::AtomicBool::new(false));
+        let reader_done = done.clone();
+        let reader_path = output_path.clone();
+        let reader = thread::spawn(move || {
+            let mut reads = 0;
+            while !reader_done.load(std::sync::atomic::Ordering::SeqCst) {
+                // Every read sees a whole file, up to its last statement
+                let contents = fs::read_to_string(&reader_path).unwrap();
+                assert!(contents.ends_with("\", 19999);\n"));
+                reads += 1;
+            }
+            reads
+        });
+
+        for marker in ["<SECOND>", "<THIRD>", "<FOURTH>", "<FIFTH>"] {
+            fs::write(&js_file_path, source(marker)).unwrap();
+            rebuild_context(context_id).unwrap();
+        }
+        done.store(true, std::sync::atomic::Ordering::SeqCst);
+        assert!(reader.join().unwrap() > 0);
+
+        // The temporary files are all renamed into place
+        let leftovers: Vec<_> = fs::read_dir(temp_dir.path())
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+            .filter(|name| name.ends_with(".tmp"))
+            .collect();
+        assert!(leftovers.is_empty());
+        assert!(fs::read_to_string(&output_path)
+            .unwrap()
+            .contains("<FIFTH>"));
+    }
+
+    #[test]
+    fn test_cancel_and_remove() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+
+        // Large enough that the rebuild is usually still running when it's cancelled
+        let statements: String = (0..50_000)
+            .map(|index| format!("export const value{} = {{ index: {} }};\n", index, index))
+            .collect();
+        fs::write(&js_file_path, statements).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+
+        let rebuild = thread::spawn(move || rebuild_context(context_id));
+        thread::sleep(std::time::Duration::from_millis(20));
+        let interrupted = cancel_and_remove(context_id).unwrap();
+
+        // Either way the context is gone. An interrupted rebuild fails, and
+        // one that hadn't started yet finds the context removed.
+        let result = rebuild.join().unwrap();
+        if interrupted {
+            assert!(result.unwrap_err().contains("canceled"));
+        } else if let Err(error) = result {
+            assert!(error.contains(&format!("Context {} was removed", context_id)));
+        }
+        assert!(!remove_context(context_id));
+        assert!(cancel_and_remove(context_id)
+            .unwrap_err()
+            .contains("does not exist"));
+    }
+
+    #[test]
+    fn test_describe_context() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        fs::write(
+            &js_file_path,
+            r##"export const Index = () => "<DESCRIBE>";"##,
+        )
+        .unwrap();
+
+        let specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "target": "es2020,node18"}}]"##,
+            js_file_path.to_str().unwrap()
+        );
+        let ids = get_build_contexts(&specs, "", "development", 0, "").unwrap();
+        update_context_defines(
+            ids[0],
+            r##"{"process.env.NODE_ENV": "\"development\"", "process.env.API_KEY": "\"sk-12345\""}"##,
+        )
+        .unwrap();
+
+        let description = describe_context(ids[0]).unwrap();
+        assert!(description.contains(r##""format":"iife""##));
+        assert!(description.contains(r##""target":"es2020","engines":["node18"]"##));
+        assert!(description.contains(r##"".tsx":"tsx""##));
+        assert!(description.contains(r##""process.env.NODE_ENV":"\"development\"""##));
+        assert!(description.contains(r##""process.env.API_KEY":"\"[redacted]\"""##));
+        assert!(!description.contains("sk-12345"));
+
+        remove_context(ids[0]);
+        assert!(describe_context(ids[0]).is_err());
+    }
+
+    #[test]
+    fn test_exception_thrown() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        let output_file_path = temp_dir.path().join("ssr.js.out");
+
+        // Write a simple javascript function to a file in a tmp directory
+        let initial_js = r##"export const Index INVALID SYNTAX () => "<INITIAL>";"##;
+        fs::write(&js_file_path, initial_js).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+        assert_ne!(context_id, 0);
+
+        let result = rebuild_context(context_id);
+        assert!(
+            result.is_err(),
+            "Expected an error during rebuild_context, but none occurred."
+        );
+        assert!(!output_file_path.exists());
+    }
+
+    #[test]
+    fn test_update_context_defines() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        let output_file_path = temp_dir.path().join("ssr.js.out");
+
+        let initial_js = r##"export const Index = () => `<${process.env.NODE_ENV}>`;"##;
+        fs::write(&js_file_path, initial_js).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+
+        rebuild_context(context_id).unwrap();
+        let output = fs::read_to_string(&output_file_path).unwrap();
+        assert!(
+            output.contains("development"),
+            "Output does not contain the initial environment"
+        );
+
+        update_context_defines(
+            context_id,
+            r##"{"process.env.NODE_ENV": "\"production\""}"##,
+        )
+        .unwrap();
+
+        rebuild_context(context_id).unwrap();
+        let updated_output = fs::read_to_string(&output_file_path).unwrap();
+        assert!(
+            updated_output.contains("production") && !updated_output.contains("development"),
+            "Updated output does not reflect the new environment"
+        );
+
+        // Updates can reference the context's existing defines
+        update_context_defines(
+            context_id,
+            r##"{"process.env.NODE_ENV": "\"${process.env.SSR_RENDERING}-staging\""}"##,
+        )
+        .unwrap();
+        rebuild_context(context_id).unwrap();
+        let updated_output = fs::read_to_string(&output_file_path).unwrap();
+        assert!(updated_output.contains("true-staging"));
+    }
+
+    #[test]
+    fn test_update_context_defines_and_rebuild() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("flags.js");
+        let output_file_path = temp_dir.path().join("flags.js.out");
+        fs::write(
+            &js_file_path,
+            r##"export const newNavigation = FEATURE_NEW_NAV;"##,
+        )
+        .unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+
+        let result =
+            update_context_defines_and_rebuild(context_id, r##"{"FEATURE_NEW_NAV": "false"}"##)
+                .unwrap();
+        assert!(result.contains(r##""recreated":true"##));
+        assert!(fs::read_to_string(&output_file_path)
+            .unwrap()
+            .contains("newNavigation = false"));
+
+        let result =
+            update_context_defines_and_rebuild(context_id, r##"{"FEATURE_NEW_NAV": "true"}"##)
+                .unwrap();
+        assert!(result.contains(r##""recreated":true"##));
+        assert!(result.contains(&format!(
+            r##""changed":["{}"]"##,
+            output_file_path.to_str().unwrap()
+        )));
+        assert!(fs::read_to_string(&output_file_path)
+            .unwrap()
+            .contains("newNavigation = true"));
+
+        // An unchanged value keeps the context and its incremental state
+        let result =
+            update_context_defines_and_rebuild(context_id, r##"{"FEATURE_NEW_NAV": "true"}"##)
+                .unwrap();
+        assert!(result.contains(r##""recreated":false"##));
+        assert!(result.contains(r##""changed":[]"##));
+        assert!(result.contains(r##""updateMs":"##));
+        assert!(result.contains(r##""rebuildMs":"##));
+
+        let error = update_context_defines_and_rebuild(context_id, "not json").unwrap_err();
+        assert!(error.starts_with("Invalid defines JSON"));
+        let error = update_context_defines_and_rebuild(-1, "{}").unwrap_err();
+        assert!(error.contains("does not exist"));
+    }
+
+    #[test]
+    fn test_compare_context_defines() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let ssr_path = temp_dir.path().join("page_ssr.js");
+        let client_path = temp_dir.path().join("page_client.js");
+        for path in [&ssr_path, &client_path] {
+            fs::write(path, r##"export const api = process.env.API_URL;"##).unwrap();
+        }
+
+        let ids = get_build_contexts(
+            &format!(
+                r##"[{{"path": "{}", "isSSR": true}}, {{"path": "{}", "isSSR": false}}]"##,
+                ssr_path.to_str().unwrap(),
+                client_path.to_str().unwrap()
+            ),
+            "",
+            "development",
+            0,
+            "",
+        )
+        .unwrap();
+        let (ssr_id, client_id) = (ids[0], ids[1]);
+
+        // SSR_RENDERING and global differ by design
+        assert_eq!(compare_context_defines(ssr_id, client_id).unwrap(), "[]");
+
+        let shared = r##"{"process.env.API_URL": "\"https://api.example.com\"", "process.env.API_KEY": "\"one\""}"##;
+        update_context_defines(ssr_id, shared).unwrap();
+        update_context_defines(client_id, shared).unwrap();
+        assert_eq!(compare_context_defines(ssr_id, client_id).unwrap(), "[]");
+
+        update_context_defines(
+            client_id,
+            r##"{"process.env.API_URL": "\"http://localhost:8000\"", "process.env.API_KEY": "\"two\"", "DEBUG": "true"}"##,
+        )
+        .unwrap();
+        let mismatches = compare_context_defines(ssr_id, client_id).unwrap();
+        assert_eq!(
+            mismatches,
+            r##"[{"name":"DEBUG","ssr":null,"client":"true"},{"name":"process.env.API_KEY","ssr":"\"[redacted]\"","client":"\"[redacted]\""},{"name":"process.env.API_URL","ssr":"\"https://api.example.com\"","client":"\"http://localhost:8000\""}]"##
+        );
+
+        let error = compare_context_defines(ssr_id, -1).unwrap_err();
+        assert!(error.contains("Context with ID -1 does not exist"));
+    }
+
+    #[test]
+    fn test_rebuild_context_coalesced() {
+        // The debounce is process-wide, so keep other context tests from waiting on it
+        let _contexts = CONTEXT_CAP
+            .write()
+            .unwrap_or_else(|error| error.into_inner());
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("burst.js");
+        fs::write(&js_file_path, r##"console.log("<BURST>");"##).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+        let rebuilds = || {
+            let description = describe_context(context_id).unwrap();
+            let start = description.find(r##""rebuilds":"##).unwrap() + r##""rebuilds":"##.len();
+            let end = start + description[start..].find([',', '}']).unwrap();
+            description[start..end].parse::<usize>().unwrap()
+        };
+
+        // Without a burst, every call builds
+        assert!(!rebuild_context_coalesced(context_id).unwrap());
+        assert!(!rebuild_context_coalesced(context_id).unwrap());
+        assert_eq!(rebuilds(), 2);
+
+        set_rebuild_debounce(200);
+        let handles: Vec<_> = (0..8)
+            .map(|_| std::thread::spawn(move || rebuild_context_coalesced(context_id)))
+            .collect();
+        let results: Vec<bool> = handles
+            .into_iter()
+            .map(|handle| handle.join().unwrap().unwrap())
+            .collect();
+        set_rebuild_debounce(0);
+
+        let coalesced = results.iter().filter(|coalesced| **coalesced).count();
+        assert!(coalesced > 0);
+        assert_eq!(rebuilds(), 2 + results.len() - coalesced);
+        assert!(rebuilds() < 2 + results.len());
+
+        assert!(rebuild_context_coalesced(-1)
+            .unwrap_err()
+            .contains("Context with ID -1 does not exist"));
+    }
+
+    #[test]
+    fn test_rebuild_queued_behind_removal() {
+        // The debounce is process-wide, so keep other context tests from waiting on it
+        let _contexts = CONTEXT_CAP
+            .write()
+            .unwrap_or_else(|error| error.into_inner());
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("removed.js");
+        fs::write(&js_file_path, r##"console.log("<REMOVED>");"##).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+        rebuild_context(context_id).unwrap();
+
+        // The rebuild is still debouncing when the context is removed
+        set_rebuild_debounce(200);
+        let rebuild = thread::spawn(move || rebuild_context(context_id));
+        thread::sleep(std::time::Duration::from_millis(50));
+        assert!(remove_context(context_id));
+        let error = rebuild.join().unwrap().unwrap_err();
+        set_rebuild_debounce(0);
+
+        assert_eq!(error, format!("Context {} was removed", context_id));
+    }
+
+    #[test]
+    fn test_live_reload_host_define() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+
+        let build = |name: &str, live_reload_host: &str| {
+            let js_file_path = temp_dir.path().join(name);
+            fs::write(
+                &js_file_path,
+                r##"export const host = process.env.LIVE_RELOAD_HOST;"##,
+            )
+            .unwrap();
+
+            let context_id = get_build_context(
+                js_file_path.to_str().unwrap(),
+                "",
+                "development",
+                5015,
+                live_reload_host,
+                false,
+                "",
+            )
+            .unwrap();
+            rebuild_context(context_id).unwrap();
+            remove_context(context_id);
+            fs::read_to_string(temp_dir.path().join(format!("{}.out", name))).unwrap()
+        };
+
+        let output = build("remote.js", "192.168.1.20");
+        assert!(output.contains(r##"var host = "192.168.1.20";"##));
+
+        let output = build("local.js", "");
+        assert!(output.contains(r##"var host = "localhost";"##));
+    }
+
+    #[test]
+    fn test_bundle_all() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(&entrypoint_path, r##"console.log("<BUNDLED>");"##).unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+        assert!(result.contains("\"metafile\""));
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<BUNDLED>"));
+    }
+
+    #[test]
+    fn test_bundle_all_cjs() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("migrate.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            &entrypoint_path,
+            r##"
+            const path = require("path");
+            module.exports = { root: path.join(__dirname, "<MIGRATIONS>") };
+            "##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "format": "cjs"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        // Node builtins stay as runtime requires and __dirname isn't rewritten
+        let output = fs::read_to_string(outdir_path.join("migrate.js")).unwrap();
+        assert!(output.contains(r##"require("path")"##));
+        assert!(output.contains("__dirname"));
+        assert!(output.contains("module.exports"));
+        assert!(!output.contains("export {"));
+
+        let invalid_options = options.replace(r##""cjs""##, r##""amd""##);
+        assert!(bundle_all(&invalid_options)
+            .unwrap_err()
+            .contains("Unknown format"));
+    }
+
+    #[test]
+    fn test_self_test() {
+        assert_eq!(self_test(), Ok(()));
+    }
+
+    #[test]
+    fn test_prewarm_dependencies() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let package_path = node_modules_path.join("left-pad");
+        fs::create_dir_all(&package_path).unwrap();
+        fs::write(package_path.join("index.js"), "module.exports = () => {};").unwrap();
+
+        let report = prewarm_dependencies(
+            node_modules_path.to_str().unwrap(),
+            r##"["left-pad", "missing-package"]"##,
+        )
+        .unwrap();
+        assert!(report.contains(r##""resolved":["left-pad"]"##));
+        assert!(report.contains(r##""unresolved":["missing-package"]"##));
+        assert!(report.contains(r##""errors":[]"##));
+    }
+
+    #[test]
+    fn test_bundle_all_with_callback() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(&entrypoint_path, r##"console.log("<UPLOADED>");"##).unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+
+        let mut files: HashMap<String, Vec<u8>> = HashMap::new();
+        bundle_all_with_callback(&options, |path, data| {
+            files.insert(path.to_string(), data.to_vec());
+            0
+        })
+        .unwrap();
+
+        let script_path = outdir_path.join("page.js");
+        let script = String::from_utf8(files[script_path.to_str().unwrap()].clone()).unwrap();
+        assert!(script.contains("<UPLOADED>"));
+        assert!(files.contains_key(&format!("{}.map", script_path.to_str().unwrap())));
+        assert!(!outdir_path.exists());
+
+        // A failing callback stops the build
+        let mut calls = 0;
+        let error = bundle_all_with_callback(&options, |_, _| {
+            calls += 1;
+            7
+        })
+        .unwrap_err();
+        assert!(error.contains("with status 7"));
+        assert_eq!(calls, 1);
+
+        // Nothing is written, so a previous build on disk is no concern of this one
+        bundle_all(&format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "hashNames": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        ))
+        .unwrap();
+        let error = bundle_all_with_callback(
+            &options.replace(r##""production""##, r##""production", "cleanStale": true"##),
+            |_, _| 0,
+        )
+        .unwrap_err();
+        assert!(error.contains("cleanStale only applies to builds written to the outdir"));
+        assert_eq!(fs::read_dir(&outdir_path).unwrap().count(), 2);
+
+        let error = bundle_all_with_callback(
+            &options.replace(
+                r##""production""##,
+                r##""production", "emitNotices": true"##,
+            ),
+            |_, _| 0,
+        )
+        .unwrap_err();
+        assert!(error.contains("emitNotices only applies to builds written to the outdir"));
+        assert!(!outdir_path.join("NOTICES.txt").exists());
+    }
+
+    #[test]
+    fn test_bundle_all_check_interop() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::create_dir_all(node_modules_path.join("cjs-pkg")).unwrap();
+        fs::write(
+            node_modules_path.join("cjs-pkg/index.js"),
+            "exports.pad = (value) => value;",
+        )
+        .unwrap();
+        fs::create_dir_all(node_modules_path.join("esm-pkg")).unwrap();
+        fs::write(
+            node_modules_path.join("esm-pkg/package.json"),
+            r##"{"type": "module"}"##,
+        )
+        .unwrap();
+        fs::write(
+            node_modules_path.join("esm-pkg/index.js"),
+            "export const trim = (value) => value;",
+        )
+        .unwrap();
+
+        fs::write(
+            &entrypoint_path,
+            r##"
+            import { pad } from "cjs-pkg";
+            import { trim } from "esm-pkg";
+            console.log(pad("a"), trim("b"));
+            "##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "nodeModulesPath": "{}", "environment": "production", "absWorkingDir": "{}", "checkInterop": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            node_modules_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+        assert!(result.contains(r##"named import of \"cjs-pkg\""##));
+        assert!(!result.contains(r##"\"esm-pkg\""##));
+    }
+
+    #[test]
+    fn test_bundle_all_minify_css() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            temp_dir.path().join("page.css"),
+            "body {\n  color: red;\n}\n",
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import "./page.css"; const message = "<MINIFIED>"; console.log(message);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "minify": true, "minifyCss": false}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        let script = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(script.contains("<MINIFIED>"));
+        assert!(!script.contains("message"));
+
+        let stylesheet = fs::read_to_string(outdir_path.join("page.css")).unwrap();
+        assert!(stylesheet.contains("body {\n  color: red;\n}"));
+
+        // The stylesheet's sourcemap still points at the original file
+        let source_map = fs::read_to_string(outdir_path.join("page.css.map")).unwrap();
+        assert!(source_map.contains("../page.css"));
+    }
+
+    #[test]
+    fn test_bundle_all_log_overrides() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(&entrypoint_path, "console.log({ a: 1, a: 2 });").unwrap();
+
+        let options = |overrides: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "logOverrides": {}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                overrides
+            )
+        };
+
+        let result = bundle_all(&options("{}")).unwrap();
+        assert!(result.contains("[duplicate-object-key]"));
+
+        let result = bundle_all(&options(r##"{"duplicate-object-key": "silent"}"##)).unwrap();
+        assert!(!result.contains("duplicate-object-key"));
+
+        let error = bundle_all(&options(r##"{"duplicate-object-key": "loud"}"##)).unwrap_err();
+        assert!(error.contains("Invalid log level"));
+    }
+
+    #[test]
+    fn test_rebuild_entrypoints() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let changed_path = temp_dir.path().join("changed.js");
+        let untouched_path = temp_dir.path().join("untouched.js");
+
+        fs::write(
+            &changed_path,
+            r##"export const Index = () => "<CHANGED>";"##,
+        )
+        .unwrap();
+        fs::write(
+            &untouched_path,
+            r##"export const Index = () => "<UNTOUCHED>";"##,
+        )
+        .unwrap();
+
+        for path in [&changed_path, &untouched_path] {
+            get_build_context(path.to_str().unwrap(), "", "development", 0, "", true, "").unwrap();
+        }
+
+        rebuild_entrypoints(&[changed_path.to_str().unwrap()]).unwrap();
+        assert!(temp_dir.path().join("changed.js.out").exists());
+        assert!(!temp_dir.path().join("untouched.js.out").exists());
+
+        let missing_path = temp_dir.path().join("missing.js");
+        let error = rebuild_entrypoints(&[
+            changed_path.to_str().unwrap(),
+            missing_path.to_str().unwrap(),
+        ])
+        .unwrap_err();
+        assert!(error.contains("No context for entrypoint"));
+    }
+
+    #[test]
+    fn test_rebuild_entrypoints_errors() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let paths: Vec<_> = ["first.js", "second.js"]
+            .iter()
+            .map(|name| temp_dir.path().join(name))
+            .collect();
+        for path in &paths {
+            fs::write(path, r##"export const Index = () => "<PAGE>";"##).unwrap();
+        }
+        let ids: Vec<_> = paths
+            .iter()
+            .map(|path| {
+                get_build_context(path.to_str().unwrap(), "", "development", 0, "", true, "")
+                    .unwrap()
+            })
+            .collect();
+        let rebuilds = |context_id| {
+            let description = describe_context(context_id).unwrap();
+            let start = description.find(r##""rebuilds":"##).unwrap() + r##""rebuilds":"##.len();
+            let end = start + description[start..].find([',', '}']).unwrap();
+            description[start..end].parse::<usize>().unwrap()
+        };
+
+        // A path listed twice still rebuilds its context once
+        let first = paths[0].to_str().unwrap();
+        rebuild_entrypoints(&[first, first]).unwrap();
+        assert_eq!(rebuilds(ids[0]), 1);
+        assert_eq!(rebuilds(ids[1]), 0);
+
+        // Each context's errors are kept apart by a blank line
+        for path in &paths {
+            fs::write(path, r##"export const Index = () => "<BROKEN PAGE>;"##).unwrap();
+        }
+        let error = rebuild_entrypoints(&[first, paths[1].to_str().unwrap()]).unwrap_err();
+        let second = error.rfind("Error rebuilding").unwrap();
+        assert!(error[..second].ends_with("Unterminated string literal\n\n"));
+        assert!(error.ends_with("Unterminated string literal"));
+    }
+
+    #[test]
+    fn test_bundle_all_manifest() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("index.ts");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            &entrypoint_path,
+            "export const add = (a: number, b: number): number => a + b;",
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "absWorkingDir": "{}", "emitManifest": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+        assert!(result.contains(
+            r##""entries":[{"source":"index.ts","output":"dist/index.js","declaration":"dist/index.d.ts"}]"##
+        ));
+    }
+
+    #[test]
+    fn test_bundle_all_chunk_names() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            temp_dir.path().join("route.js"),
+            r##"export const render = () => "<ROUTE>";"##,
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import("./route.js").then((route) => console.log(route.render()));"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "absWorkingDir": "{}", "chunkNames": "chunks/[name]-[hash]", "emitManifest": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+
+        let chunks: Vec<String> = fs::read_dir(outdir_path.join("chunks"))
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+            .filter(|name| name.ends_with(".js"))
+            .collect();
+        assert_eq!(chunks.len(), 1);
+        assert!(chunks[0].starts_with("route-"));
+
+        assert!(result.contains(&format!(
+            r##""dynamicImports":{{"dist/page.js":["dist/chunks/{}"]}}"##,
+            chunks[0]
+        )));
+    }
+
+    #[test]
+    fn test_bundle_all_size_budget() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(&entrypoint_path, r##"console.log("<BUDGETED>");"##).unwrap();
+
+        let options = |budgets: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "minify": true, {}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                budgets
+            )
+        };
+
+        // Measure the output without a budget first
+        bundle_all(&options(r##""maxOutputBytes": 0"##)).unwrap();
+        let size = fs::metadata(outdir_path.join("page.js")).unwrap().len();
+        fs::remove_dir_all(&outdir_path).unwrap();
+
+        bundle_all(&options(&format!(r##""maxOutputBytes": {}"##, size))).unwrap();
+
+        let error =
+            bundle_all(&options(&format!(r##""maxOutputBytes": {}"##, size - 1))).unwrap_err();
+        assert!(error.contains("Output size budget exceeded"));
+        assert!(error.contains(&format!(
+            "is {} bytes, over its budget of {}",
+            size,
+            size - 1
+        )));
+
+        // A per-entrypoint budget takes precedence over the global one
+        let entry_budget = format!(
+            r##""maxOutputBytes": 1, "entryBudgets": {{"{}": {}}}"##,
+            entrypoint_path.to_str().unwrap(),
+            size
+        );
+        bundle_all(&options(&entry_budget)).unwrap();
+    }
+
+    #[test]
+    fn test_transform_many() {
+        let _transform_cache = shared_transform_cache();
+        let sources: Vec<String> = (0..20)
+            .map(|index| format!("const value: number = {};", index))
+            .collect();
+        let mut sources: Vec<&str> = sources.iter().map(|source| source.as_str()).collect();
+        let mut loaders = vec!["ts"; sources.len()];
+        sources.push("const = ;");
+        loaders.push("");
+
+        let outputs = transform_many(&sources, &loaders).unwrap();
+
+        // Results line up with their inputs, and a bad snippet only fails itself
+        for index in 0..20 {
+            assert!(outputs.contains(&format!(r##"{{"code":"const value = {};\n"}}"##, index)));
+        }
+        let first = outputs.find("const value = 0;").unwrap();
+        let last = outputs.find("const value = 19;").unwrap();
+        assert!(first < last);
+        assert!(outputs.contains("Error transforming snippet 20"));
+
+        let error = transform_many(&["1"], &["python"]).unwrap_err();
+        assert!(error.contains("Invalid loader for snippet 0"));
+    }
+
+    #[test]
+    fn test_bundle_all_abs_metafile_paths() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            temp_dir.path().join("util.js"),
+            "export const id = (x) => x;",
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import { id } from "./util.js"; console.log(id("<ABSOLUTE>"));"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "absWorkingDir": "{}", "emitManifest": true, "absMetafilePaths": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+
+        let util_path = temp_dir.path().join("util.js");
+        let output_path = outdir_path.join("page.js");
+        assert!(result.contains(&format!(r##""{}":{{"##, entrypoint_path.to_str().unwrap())));
+        assert!(result.contains(&format!(r##""path":"{}""##, util_path.to_str().unwrap())));
+        assert!(result.contains(&format!(
+            r##""entryPoint":"{}""##,
+            entrypoint_path.to_str().unwrap()
+        )));
+        assert!(result.contains(&format!(
+            r##""source":"{}","output":"{}""##,
+            entrypoint_path.to_str().unwrap(),
+            output_path.to_str().unwrap()
+        )));
+        assert!(!result.contains(r##""dist/page.js""##));
+    }
+
+    #[test]
+    fn test_bundle_multi_format() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("index.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            &entrypoint_path,
+            r##"export const greet = (name) => `<GREET> ${name}`;"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "absWorkingDir": "{}"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_multi_format(&options, r##"["esm", "cjs"]"##).unwrap();
+        assert!(result.contains(r##""output":"dist/esm/index.js""##));
+        assert!(result.contains(r##""output":"dist/cjs/index.js""##));
+
+        let esm_output = fs::read_to_string(outdir_path.join("esm/index.js")).unwrap();
+        assert!(esm_output.contains("export {"));
+        assert!(!esm_output.contains("module.exports"));
+
+        let cjs_output = fs::read_to_string(outdir_path.join("cjs/index.js")).unwrap();
+        assert!(cjs_output.contains("module.exports"));
+        assert!(!cjs_output.contains("export {"));
+
+        let error = bundle_multi_format(&options, r##"["esm", "esm"]"##).unwrap_err();
+        assert!(error.contains("listed more than once"));
+    }
+
+    #[test]
+    fn test_bundle_all_with_loader() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            &entrypoint_path,
+            r##"import { greet } from "overlay-pkg"; console.log(greet());"##,
+        )
+        .unwrap();
+
+        let modules: HashMap<&str, &str> = HashMap::from([
+            ("overlay-pkg", r##"export { greet } from "./greet.ts";"##),
+            (
+                "overlay-pkg/greet.ts",
+                r##"export const greet = (): string => "<IN_MEMORY_MODULE>";"##,
+            ),
+        ]);
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "virtualModules": ["overlay-pkg"]}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        bundle_all_with_loader(&options, |path| {
+            modules
+                .get(path)
+                .map(|contents| contents.as_bytes().to_vec())
+        })
+        .unwrap();
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<IN_MEMORY_MODULE>"));
+
+        let error = bundle_all_with_loader(&options, |_| None).unwrap_err();
+        assert!(error.contains("Virtual module overlay-pkg not found"));
+    }
+
+    #[test]
+    fn test_bundle_all_with_css_processor() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("styles.css"),
+            ".banner { content: \"<tailwind>\"; color: red; }",
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"import "./styles.css"; console.log("<page>");"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["page.js"], "outdir": "dist", "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        );
+        let processed = Mutex::new(Vec::new());
+        bundle_all_with_css_processor(&options, |path, contents| {
+            processed.lock().unwrap().push(path.to_string());
+            Ok(contents.replace("<tailwind>", "<TAILWIND>"))
+        })
+        .unwrap();
+
+        // Only the stylesheet goes through the hook
+        let processed = processed.into_inner().unwrap();
+        assert_eq!(processed.len(), 1);
+        assert!(processed[0].ends_with("page.css"));
+        let css = fs::read_to_string(temp_dir.path().join("dist/page.css")).unwrap();
+        assert!(css.contains("<TAILWIND>") && !css.contains("<tailwind>"));
+        let js = fs::read_to_string(temp_dir.path().join("dist/page.js")).unwrap();
+        assert!(js.contains("<page>"));
+
+        let error = bundle_all_with_css_processor(&options, |_, _| Err(3)).unwrap_err();
+        assert!(error.contains("CSS processor failed for"));
+        assert!(error.contains("with status 3"));
+    }
+
+    #[test]
+    fn test_bundle_all_asset_names() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::create_dir_all(temp_dir.path().join("images")).unwrap();
+        fs::write(temp_dir.path().join("images/logo.png"), b"\x89PNG<LOGO>").unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import logo from "./images/logo.png"; console.log(logo);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "absWorkingDir": "{}", "loaders": {{".png": "file"}}, "assetNames": "assets/[dir]/[name]-[hash]", "emitManifest": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+
+        let assets: Vec<String> = fs::read_dir(outdir_path.join("assets/images"))
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+            .collect();
+        assert_eq!(assets.len(), 1);
+        assert!(assets[0].starts_with("logo-") && assets[0].ends_with(".png"));
+
+        assert!(result.contains(&format!(
+            r##""assets":{{"images/logo.png":"dist/assets/images/{}"}}"##,
+            assets[0]
+        )));
+    }
+
+    #[test]
+    fn test_bundle_all_copy_loader() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+
+        // Not valid UTF-8, so any transform or re-encoding would show
+        let wasm_bytes: &[u8] = b"\x00asm\x01\x00\x00\x00\xff\xfe<WASM>";
+        let worker_source = "self.onmessage=(e)=>postMessage(e.data*2)//<WORKER>\n";
+        fs::write(temp_dir.path().join("math.wasm"), wasm_bytes).unwrap();
+        fs::write(temp_dir.path().join("calc.worker.js"), worker_source).unwrap();
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"import wasm from "./math.wasm"; import worker from "./calc.worker.js"; console.log(wasm, worker);"##,
+        )
+        .unwrap();
+
+        let result = bundle_all(&format!(
+            r##"{{"entrypoints": ["page.js"], "outdir": "dist", "environment": "production", "minify": true, "absWorkingDir": "{}", "loaders": {{".wasm": "copy", ".worker.js": "copy"}}, "assetNames": "assets/[name]-[hash]", "emitManifest": true}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap();
+
+        let mut assets: Vec<String> = fs::read_dir(outdir_path.join("assets"))
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+            .collect();
+        assets.sort();
+        assert_eq!(assets.len(), 2);
+        assert!(assets[0].starts_with("calc.worker-") && assets[0].ends_with(".js"));
+        assert!(assets[1].starts_with("math-") && assets[1].ends_with(".wasm"));
+
+        // Copied byte for byte, even through minification
+        assert_eq!(
+            fs::read(outdir_path.join("assets").join(&assets[0])).unwrap(),
+            worker_source.as_bytes()
+        );
+        assert_eq!(
+            fs::read(outdir_path.join("assets").join(&assets[1])).unwrap(),
+            wasm_bytes
+        );
+
+        // The bundle points at the copies, and both are listed as assets
+        let bundle = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(bundle.contains(&format!("./assets/{}", assets[0])));
+        assert!(bundle.contains(&format!("./assets/{}", assets[1])));
+        assert!(result.contains(&format!(
+            r##""assets":{{"calc.worker.js":"dist/assets/{}","math.wasm":"dist/assets/{}"}}"##,
+            assets[0], assets[1]
+        )));
+    }
+
+    #[test]
+    fn test_bundle_all_wasm_loader() {
+        let temp_dir = tempdir().unwrap();
+        // The smallest valid module: the magic number and version
+        let wasm_bytes: &[u8] = b"\x00asm\x01\x00\x00\x00";
+        fs::write(temp_dir.path().join("add.wasm"), wasm_bytes).unwrap();
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"import add from "./add.wasm"; console.log("<WASM>", add);"##,
+        )
+        .unwrap();
+
+        let build = |strategy: &str, extra: &str| {
+            let outdir = temp_dir.path().join(strategy);
+            let _ = fs::remove_dir_all(&outdir);
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["page.js"], "outdir": "{}", "format": "esm", "absWorkingDir": "{}", "assetNames": "[name]-[hash]", "emitManifest": true{}}}"##,
+                strategy,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            ))
+        };
+        let emitted_wasm = |strategy: &str| -> Vec<String> {
+            fs::read_dir(temp_dir.path().join(strategy))
+                .unwrap()
+                .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+                .filter(|name| name.ends_with(".wasm"))
+                .collect()
+        };
+
+        // Fetched strategies emit the module and list it in the manifest
+        for strategy in ["file", "copy"] {
+            let result = build(strategy, &format!(r##", "wasmLoader": "{}""##, strategy)).unwrap();
+            let emitted = emitted_wasm(strategy);
+            assert_eq!(emitted.len(), 1, "{}", strategy);
+            assert_eq!(
+                fs::read(temp_dir.path().join(strategy).join(&emitted[0])).unwrap(),
+                wasm_bytes
+            );
+            assert!(result.contains(&format!(
+                r##""assets":{{"add.wasm":"{}/{}"}}"##,
+                strategy, emitted[0]
+            )));
+            let bundle =
+                fs::read_to_string(temp_dir.path().join(strategy).join("page.js")).unwrap();
+            assert!(bundle.contains(&format!("./{}", emitted[0])));
+        }
+
+        // Embedding inlines the bytes and emits nothing else
+        let result = build("binary", r##", "wasmLoader": "binary""##).unwrap();
+        assert!(emitted_wasm("binary").is_empty());
+        assert!(result.contains(r##""assets":{}"##));
+        let bundle = fs::read_to_string(temp_dir.path().join("binary/page.js")).unwrap();
+        assert!(bundle.contains("AGFzbQEAAAA="));
+
+        // Without a strategy .wasm stays unregistered
+        assert!(build("none", "")
+            .unwrap_err()
+            .contains(r##"No loader is configured for ".wasm" files"##));
+        assert!(build("none", r##", "wasmLoader": "dataurl""##)
+            .unwrap_err()
+            .contains(r##"Unknown wasm loader "dataurl""##));
+        assert!(build(
+            "none",
+            r##", "wasmLoader": "file", "loaders": {".wasm": "copy"}"##
+        )
+        .unwrap_err()
+        .contains("can't both be set"));
+    }
+
+    #[test]
+    fn test_start_serve() {
+        use std::io::{Read, Write};
+        use std::net::TcpStream;
+
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("client.js");
+        fs::write(&js_file_path, r##"console.log("<SERVED>");"##).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+        let port = start_serve(context_id, 0).unwrap();
+        assert!(port > 0);
+        assert!(start_serve(context_id, 0)
+            .unwrap_err()
+            .contains("already serving"));
+
+        // Outputs are served from memory, relative to the output directory
+        let mut stream = TcpStream::connect(("127.0.0.1", port as u16)).unwrap();
+        stream
+            .write_all(
+                b"GET /client.js.out HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+            )
+            .unwrap();
+        let mut response = String::new();
+        stream.read_to_string(&mut response).unwrap();
+        assert!(response.starts_with("HTTP/1.1 200"));
+        assert!(response.contains("<SERVED>"));
+        assert!(!temp_dir.path().join("client.js.out").exists());
+
+        stop_serve(context_id).unwrap();
+        assert!(TcpStream::connect(("127.0.0.1", port as u16)).is_err());
+
+        // The recreated context still rebuilds
+        rebuild_context(context_id).unwrap();
+        assert!(temp_dir.path().join("client.js.out").exists());
+    }
+
+    #[test]
+    fn test_bundle_all_mjs_cjs_dependencies() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let package_path = node_modules_path.join("modern-dep");
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        // An extensionless main only resolves once .mjs is a resolve extension
+        fs::create_dir_all(package_path.join("lib")).unwrap();
+        fs::write(
+            package_path.join("package.json"),
+            r##"{"main": "./lib/index"}"##,
+        )
+        .unwrap();
+        fs::write(
+            package_path.join("lib/index.mjs"),
+            r##"export { format } from "./format.cjs"; export const name = "<MJS>";"##,
+        )
+        .unwrap();
+        fs::write(
+            package_path.join("lib/format.cjs"),
+            r##"module.exports.format = (value) => `<CJS> ${value}`;"##,
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import { format, name } from "modern-dep"; console.log(format(name));"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "nodeModulesPath": "{}", "environment": "production"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            node_modules_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<MJS>"));
+        assert!(output.contains("<CJS>"));
+    }
+
+    #[test]
+    fn test_bundle_all_build_info_defines() {
+        let temp_dir = tempdir().unwrap();
+        let git_dir = temp_dir.path().join(".git");
+        let src_dir = temp_dir.path().join("src");
+        let entrypoint_path = src_dir.join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        // A fixture repository laid out the way git writes it, with the branch
+        // packed by gc and the working directory in a subdirectory
+        let packed_sha = "8f4e2c1b9a7d6e5f4c3b2a1908f7e6d5c4b3a291";
+        fs::create_dir_all(git_dir.join("refs/heads")).unwrap();
+        fs::create_dir_all(&src_dir).unwrap();
+        fs::write(git_dir.join("HEAD"), "ref: refs/heads/main\n").unwrap();
+        fs::write(
+            git_dir.join("packed-refs"),
+            format!(
+                "# pack-refs with: peeled fully-peeled sorted \n{} refs/heads/main\n",
+                packed_sha
+            ),
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"export const sha = process.env.GIT_SHA; export const builtAt = process.env.BUILD_TIME;"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "absWorkingDir": "{}", "environment": "production", "defineGitSha": true, "defineBuildTime": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            src_dir.to_str().unwrap()
+        );
+        let build = || {
+            bundle_all(&options).unwrap();
+            fs::read_to_string(outdir_path.join("page.js")).unwrap()
+        };
+
+        let output = build();
+        assert!(output.contains(&format!(r##"var sha = "{}";"##, packed_sha)));
+        assert!(output.contains(r##"var builtAt = "20"##));
+
+        // A loose ref is newer than the packed one
+        let loose_sha = "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567";
+        fs::write(git_dir.join("refs/heads/main"), format!("{}\n", loose_sha)).unwrap();
+        let output = build();
+        assert!(output.contains(&format!(r##"var sha = "{}";"##, loose_sha)));
+    }
+
+    #[test]
+    fn test_bundle_all_emit_html() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let template_path = temp_dir.path().join("template.html");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(temp_dir.path().join("style.css"), "body { color: red; }").unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import "./style.css"; console.log("<HTML>");"##,
+        )
+        .unwrap();
+        fs::write(
+            &template_path,
+            "<html><head><title>App</title></head><body><div id=\"root\"></div></body></html>",
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "hashNames": true, "emitHtml": true, "htmlTemplate": "{}"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            template_path.to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+
+        let output_named = |extension: &str| -> String {
+            fs::read_dir(&outdir_path)
+                .unwrap()
+                .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+                .find(|name| name.starts_with("page-") && name.ends_with(extension))
+                .unwrap()
+        };
+        let script_name = output_named(".js");
+        let style_name = output_named(".css");
+
+        let page_path = outdir_path.join("page.html");
+        assert!(result.contains(page_path.to_str().unwrap()));
+        assert_eq!(
+            fs::read_to_string(&page_path).unwrap(),
+            format!(
+                "<html><head><title>App</title><link rel=\"stylesheet\" href=\"{}\">\n</head><body><div id=\"root\"></div><script type=\"module\" src=\"{}\"></script>\n</body></html>",
+                style_name, script_name
+            )
+        );
+    }
+
+    #[test]
+    fn test_bundle_all_emit_html_collisions() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+        let entrypoint_paths: Vec<_> = ["admin", "shop"]
+            .iter()
+            .map(|dir| {
+                fs::create_dir_all(temp_dir.path().join(dir)).unwrap();
+                let path = temp_dir.path().join(dir).join("index.js");
+                fs::write(&path, format!(r##"console.log("<{}>");"##, dir)).unwrap();
+                path
+            })
+            .collect();
+
+        let options = |entry_names: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}", "{}"], "outdir": "{}", "entryNames": "{}", "emitHtml": true}}"##,
+                entrypoint_paths[0].to_str().unwrap(),
+                entrypoint_paths[1].to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                entry_names
+            )
+        };
+
+        // The hash keeps the scripts apart, but both pages would be index.html
+        let error = bundle_all(&options("[name]-[hash]")).unwrap_err();
+        assert!(error.contains("would all be written to index.html"));
+        assert!(!outdir_path.exists());
+
+        // Keeping each entrypoint's directory keeps their pages apart too
+        bundle_all(&options("[dir]/[name]-[hash]")).unwrap();
+        for dir in ["admin", "shop"] {
+            let page = fs::read_to_string(outdir_path.join(dir).join("index.html")).unwrap();
+            assert!(page.contains("src=\"index-"));
+        }
+    }
+
+    #[test]
+    fn test_bundle_all_source_root() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(temp_dir.path().join("style.css"), "body { color: red; }").unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import "./style.css"; console.log("<SOURCE_ROOT>");"##,
+        )
+        .unwrap();
+
+        let options = |source_root: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "minifyCss": true, "sourceRoot": "{}"}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                source_root
+            )
+        };
+        bundle_all(&options("/app/src/")).unwrap();
+
+        // The CSS map is re-created when restyling, so check that one too
+        for map_name in ["page.js.map", "page.css.map"] {
+            let source_map = fs::read_to_string(outdir_path.join(map_name)).unwrap();
+            assert!(source_map.contains(r##""sourceRoot": "/app/src/""##));
+        }
+
+        let error = bundle_all(&options("/app/src/?v=1")).unwrap_err();
+        assert!(error.contains("Invalid sourceRoot"));
+    }
+
+    #[test]
+    fn test_bundle_all_default_loader() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(temp_dir.path().join("notes.md"), "# <NOTES>").unwrap();
+        fs::write(temp_dir.path().join("data.json"), r##"{"key": "<JSON>"}"##).unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import notes from "./notes.md"; import data from "./data.json"; console.log(notes, data.key);"##,
+        )
+        .unwrap();
+
+        let options = |extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                extra
+            )
+        };
+
+        // Unset, unknown extensions still fail the build
+        let error = bundle_all(&options("")).unwrap_err();
+        assert!(error.contains("No loader is configured for \".md\" files"));
+
+        let error = bundle_all(&options(r##", "defaultLoader": "markdown""##)).unwrap_err();
+        assert!(error.contains("Unknown loader \"markdown\""));
+
+        // Known extensions keep their own loader
+        bundle_all(&options(r##", "defaultLoader": "text""##)).unwrap();
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains(r##""# <NOTES>""##));
+        assert!(output.contains(r##"key: "<JSON>""##));
+    }
+
+    #[test]
+    fn test_bundle_all_jsx_side_effects() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.jsx");
+        let outdir_path = temp_dir.path().join("dist");
+
+        // The element is only created for what Register does when it's called
+        fs::write(
+            &entrypoint_path,
+            r##"
+const React = { createElement: (component) => component() };
+function Register() { window.registered = "<REGISTERED>"; }
+<Register />;
+"##,
+        )
+        .unwrap();
+
+        let build = |extra: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "minify": true{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                extra
+            ))
+            .unwrap();
+            fs::read_to_string(outdir_path.join("page.js")).unwrap()
+        };
+
+        assert!(!build("").contains("<REGISTERED>"));
+        assert!(build(r##", "jsxSideEffects": true"##).contains("<REGISTERED>"));
+    }
+
+    // Name, CRC-32, and uncompressed size of each entry in a zip's central directory
+    fn zip_entries(archive: &[u8]) -> Vec<(String, u32, u32)> {
+        let read_u16 = |at: usize| u16::from_le_bytes([archive[at], archive[at + 1]]) as usize;
+        let read_u32 = |at: usize| u32::from_le_bytes(archive[at..at + 4].try_into().unwrap());
+
+        let end = (0..archive.len() - 3)
+            .rev()
+            .find(|&at| read_u32(at) == 0x06054b50)
+            .unwrap();
+        let mut at = read_u32(end + 16) as usize;
+        (0..read_u16(end + 10))
+            .map(|_| {
+                assert_eq!(read_u32(at), 0x02014b50);
+                let name_length = read_u16(at + 28);
+                let name = String::from_utf8(archive[at + 46..at + 46 + name_length].to_vec());
+                let entry = (name.unwrap(), read_u32(at + 16), read_u32(at + 24));
+                at += 46 + name_length + read_u16(at + 30) + read_u16(at + 32);
+                entry
+            })
+            .collect()
+    }
+
+    fn crc32(contents: &[u8]) -> u32 {
+        let mut crc = !0u32;
+        for byte in contents {
+            crc ^= *byte as u32;
+            for _ in 0..8 {
+                crc = if crc & 1 == 1 {
+                    (crc >> 1) ^ 0xedb88320
+                } else {
+                    crc >> 1
+                };
+            }
+        }
+        !crc
+    }
+
+    #[test]
+    fn test_bundle_all_to_zip() {
+        let temp_dir = tempdir().unwrap();
+        fs::create_dir(temp_dir.path().join("pages")).unwrap();
+        fs::write(
+            temp_dir.path().join("shared.js"),
+            r##"export const shared = "<SHARED>";"##,
+        )
+        .unwrap();
+        for page in ["home", "about"] {
+            fs::write(
+                temp_dir.path().join("pages").join(format!("{}.js", page)),
+                r##"import { shared } from "../shared.js"; console.log(shared);"##,
+            )
+            .unwrap();
+        }
+
+        let options = |outdir: &str| {
+            format!(
+                r##"{{"entrypoints": ["pages/home.js", "pages/about.js"], "outdir": "{}", "environment": "production", "hashNames": true, "chunkNames": "chunks/[name]-[hash]", "absWorkingDir": "{}"}}"##,
+                outdir,
+                temp_dir.path().to_str().unwrap()
+            )
+        };
+
+        bundle_all(&options("dist")).unwrap();
+        let zip_path = temp_dir.path().join("artifacts").join("bundle.zip");
+        let result = bundle_all_to_zip(&options("dist-zip"), zip_path.to_str().unwrap()).unwrap();
+
+        // Nothing but the archive is written
+        assert!(!temp_dir.path().join("dist-zip").exists());
+        let archive = fs::read(&zip_path).unwrap();
+        assert!(result.contains(&format!(r##""zipBytes":{}"##, archive.len())));
+        assert!(result.contains(
+            r##""manifest":{"entries":[{"source":"pages/about.js","output":"dist-zip/about-"##
+        ));
+
+        // Same files, with the same contents, as the normal build
+        let mut expected = vec![];
+        for directory in ["", "chunks"] {
+            for entry in fs::read_dir(temp_dir.path().join("dist").join(directory)).unwrap() {
+                let path = entry.unwrap().path();
+                if path.is_file() {
+                    let contents = fs::read(&path).unwrap();
+                    let name = path.strip_prefix(temp_dir.path().join("dist")).unwrap();
+                    expected.push((
+                        name.to_str().unwrap().to_string(),
+                        crc32(&contents),
+                        contents.len() as u32,
+                    ));
+                }
+            }
+        }
+        expected.sort();
+        assert!(expected
+            .iter()
+            .any(|(name, _, _)| name.starts_with("chunks/chunk-")));
+        assert_eq!(zip_entries(&archive), expected);
+
+        let error = bundle_all_to_zip(&options("dist-zip"), "").unwrap_err();
+        assert!(error.contains("No zip path provided"));
+    }
+
+    #[test]
+    fn test_bundle_all_color_errors() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(&entrypoint_path, r##"console.log("<BROKEN>""##).unwrap();
+
+        let options = |extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                extra
+            )
+        };
+
+        let error = bundle_all(&options("")).unwrap_err();
+        assert!(error.contains("Error in file"));
+        assert!(!error.contains("\x1b["));
+
+        // esbuild's formatter quotes the offending line
+        let error = bundle_all(&options(r##", "colorErrors": true"##)).unwrap_err();
+        assert!(error.starts_with("Error bundling:"));
+        assert!(error.contains("\x1b["));
+        assert!(error.contains(r##"console.log("<BROKEN>""##));
+    }
+
+    #[test]
+    fn test_bundle_all_empty_modules() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::create_dir_all(node_modules_path.join("debug-panel")).unwrap();
+        fs::write(
+            node_modules_path.join("debug-panel/index.js"),
+            r##"export const mount = () => document.body.append("<DEBUG_PANEL>");"##,
+        )
+        .unwrap();
+        fs::write(
+            node_modules_path.join("debug-panel/styles.css"),
+            ".panel { color: red; }",
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import { mount } from "debug-panel"; import "debug-panel/styles.css"; if (mount) mount(); console.log("<APP>");"##,
+        )
+        .unwrap();
+
+        let build = |extra: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "nodeModulesPath": "{}", "environment": "production", "minify": true{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                node_modules_path.to_str().unwrap(),
+                extra
+            ))
+        };
+
+        build("").unwrap();
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<DEBUG_PANEL>"));
+        assert!(outdir_path.join("page.css").exists());
+
+        // The package and its subpaths are stripped, including the code that only runs
+        // when it's present
+        fs::remove_dir_all(&outdir_path).unwrap();
+        build(r##", "emptyModules": ["debug-panel"]"##).unwrap();
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(!output.contains("<DEBUG_PANEL>"));
+        assert!(!output.contains("mount"));
+        assert!(output.contains("<APP>"));
+        assert!(!outdir_path.join("page.css").exists());
+
+        let error = build(r##", "emptyModules": [""]"##).unwrap_err();
+        assert!(error.contains("emptyModules can't contain an empty module name"));
+    }
+
+    #[test]
+    fn test_bundle_all_outfile() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("shared.js"),
+            r##"export const shared = "<SHARED>";"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("worker.js"),
+            r##"import("./shared.js").then(({ shared }) => postMessage(shared));"##,
+        )
+        .unwrap();
+
+        let build = |extra: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["worker.js"], "environment": "production", "production": true, "absWorkingDir": "{}"{}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            ))
+        };
+
+        // Exactly the requested path, even with hashed names from the production preset,
+        // and the dynamic import is inlined rather than split into a chunk
+        let result = build(r##", "outfile": "build/worker-v2.js""##).unwrap();
+        let outfile_path = temp_dir.path().join("build/worker-v2.js");
+        let mut written: Vec<String> = fs::read_dir(temp_dir.path().join("build"))
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+            .collect();
+        written.sort();
+        assert_eq!(written, vec!["worker-v2.js", "worker-v2.js.map"]);
+        assert!(fs::read_to_string(&outfile_path)
+            .unwrap()
+            .contains("<SHARED>"));
+        assert!(result.contains(&format!(r##""{}""##, outfile_path.to_str().unwrap())));
+
+        let error = build(r##", "outfile": "build/worker.js", "outdir": "dist""##).unwrap_err();
+        assert!(error.contains("outdir and outfile can't both be set"));
+
+        let error = build(r##", "outfile": "build/worker.js", "splitting": true"##).unwrap_err();
+        assert!(error.contains("outfile can't be used with splitting"));
+
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": ["worker.js", "shared.js"], "outfile": "build/worker.js", "environment": "production", "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert!(error.contains("outfile needs exactly one entrypoint, got 2"));
+    }
+
+    #[test]
+    fn test_bundle_all_polyfills() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(
+            &entrypoint_path,
+            r##"console.log(structuredClone({ key: "<CLONED>" }));"##,
+        )
+        .unwrap();
+
+        let build = |target: &str, extra: &str| {
+            let result = bundle_all(&format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "target": "{}"{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                target,
+                extra
+            ))
+            .unwrap();
+            let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+            (result, output)
+        };
+
+        // Safari 15 predates structuredClone, so it's injected ahead of the entry's code
+        let (result, output) = build("chrome100,safari15", r##", "polyfills": true"##);
+        assert!(result
+            .contains(r##""polyfills":["Array.prototype.at","Object.hasOwn","structuredClone"]"##));
+        let polyfill_index = output.find(".structuredClone = function(").unwrap();
+        assert!(polyfill_index < output.find("<CLONED>").unwrap());
+
+        // Every engine in a newer target already has them
+        let (result, output) = build("chrome120,safari17", r##", "polyfills": true"##);
+        assert!(!result.contains(r##""polyfills""##));
+        assert!(!output.contains(".structuredClone = function("));
+
+        // Without engines, the language target decides
+        let (result, _) = build("es2020", r##", "polyfills": true"##);
+        assert!(result.contains(r##""structuredClone"]"##));
+
+        // The polyfills themselves are ES5, so the oldest targets can take them
+        for target in ["es5", "ie11"] {
+            let (result, output) = build(target, r##", "polyfills": true"##);
+            assert!(result.contains(
+                r##""polyfills":["Array.prototype.at","Object.hasOwn","structuredClone"]"##
+            ));
+            assert!(output.contains(".structuredClone = function("));
+            assert!(
+                !output.contains("=>") && !output.contains("const ") && !output.contains("let ")
+            );
+        }
+
+        // Off unless requested
+        let (_, output) = build("chrome90", "");
+        assert!(!output.contains(".structuredClone = function("));
+    }
+
+    #[test]
+    fn test_bundle_all_node_builtins() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            &entrypoint_path,
+            r##"import { readFileSync } from "node:fs"; export const read = () => readFileSync("<NODE>");"##,
+        )
+        .unwrap();
+
+        let options = |extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                extra
+            )
+        };
+        let output = || fs::read_to_string(outdir_path.join("page.js")).unwrap();
+
+        // Unset keeps esbuild's behavior, which can't resolve builtins for browsers
+        let error = bundle_all(&options("")).unwrap_err();
+        assert!(error.contains("node:fs"));
+
+        bundle_all(&options(r##", "nodeBuiltins": "external""##)).unwrap();
+        assert!(output().contains(r##"import { readFileSync } from "node:fs";"##));
+
+        bundle_all(&options(r##", "nodeBuiltins": "empty""##)).unwrap();
+        assert!(!output().contains(r##"from "node:fs""##));
+        assert!(output().contains("module.exports = {};"));
+
+        let error = bundle_all(&options(r##", "nodeBuiltins": "polyfill""##)).unwrap_err();
+        assert!(error.contains("Unknown nodeBuiltins"));
+    }
+
+    #[test]
+    fn test_bundle_all_tsconfig_paths_override() {
+        let temp_dir = tempdir().unwrap();
+        let src_dir = temp_dir.path().join("src");
+        let app_dir = temp_dir.path().join("app");
+        let tsconfig_path = temp_dir.path().join("tsconfig.json");
+        let entrypoint_path = app_dir.join("page.ts");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::create_dir_all(&src_dir).unwrap();
+        fs::create_dir_all(&app_dir).unwrap();
+        fs::write(
+            src_dir.join("api.ts"),
+            r##"export const api = "<REAL_API>";"##,
+        )
+        .unwrap();
+        fs::write(
+            src_dir.join("mock-api.ts"),
+            r##"export const api = "<MOCK_API>";"##,
+        )
+        .unwrap();
+        fs::write(
+            src_dir.join("util.ts"),
+            r##"export const util = "<UTIL>";"##,
+        )
+        .unwrap();
+        fs::write(
+            &tsconfig_path,
+            r##"{
+  // Targets are relative to this file, not the working directory
+  "compilerOptions": {
+    "paths": {
+      "@api": ["./src/api.ts"],
+      "@util": ["./src/util.ts"], /* trailing comma */
+    },
+  },
+}"##,
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import { api } from "@api"; import { util } from "@util"; console.log(api, util);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["page.ts"], "outdir": "{}", "environment": "production", "tsconfig": "{}", "absWorkingDir": "{}", "tsconfigPaths": {{"@api": ["./src/mock-api.ts"]}}}}"##,
+            outdir_path.to_str().unwrap(),
+            tsconfig_path.to_str().unwrap(),
+            app_dir.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<MOCK_API>"));
+        assert!(!output.contains("<REAL_API>"));
+        assert!(output.contains("<UTIL>"));
+    }
+
+    #[test]
+    fn test_validate_options() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+
+        let problems = validate_options(
+            r##"{"entrypoints": ["page.js"], "target": "es2020,netscape4", "loaders": {".md": "markdown"}, "defines": {"API_URL": "not valid("}, "externals": ["@scope/*/*"]}"##,
+        )
+        .unwrap();
+        assert!(problems.contains("No output directory provided"));
+        assert!(problems.contains(r##"Unknown target engine \"netscape\""##));
+        assert!(problems.contains(r##"Unknown loader \"markdown\""##));
+        assert!(problems.contains("Invalid define value"));
+        assert!(problems.contains("cannot have more than one"));
+
+        let problems = validate_options(&format!(
+            r##"{{"entrypoints": ["page.js"], "outdir": "{}", "target": "es2020", "defines": {{"API_URL": "\"https://example.com\""}}}}"##,
+            outdir_path.to_str().unwrap()
+        ))
+        .unwrap();
+        assert_eq!(problems, "[]");
+        assert!(!outdir_path.exists());
+
+        assert!(validate_options("not json").is_err());
+    }
+
+    #[test]
+    fn test_bundle_all_inline_script_hashes() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(&entrypoint_path, r##"console.log(window.__BOOT__);"##).unwrap();
+
+        let options = |extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production", "inlineScripts": ["window.__BOOT__ = \"<CSP>\";"]{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                extra
+            )
+        };
+
+        // Hash of the script text, from `openssl dgst -sha256 -binary | base64`
+        let result = bundle_all(&options(r##", "emitHtml": true"##)).unwrap();
+        assert!(result.contains(
+            r##""scriptHashes":["sha256-f8mBDGw9SceCeyw4hA5zeON4kj6u5Z2zJSQQf+J4ZYs="]"##
+        ));
+
+        let page = fs::read_to_string(outdir_path.join("page.html")).unwrap();
+        assert!(page.contains(
+            r##"<script>window.__BOOT__ = "<CSP>";</script>
+<script type="module" src="page.js"></script>"##
+        ));
+
+        let error = bundle_all(&options("")).unwrap_err();
+        assert!(error.contains("inlineScripts needs emitHtml"));
+    }
+
+    #[test]
+    fn test_bundle_all_integrity() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"console.log("<SRI>");"##,
+        )
+        .unwrap();
+
+        let options = |extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["page.js"], "outdir": "{}", "environment": "production", "minify": true, "hashNames": true, "absWorkingDir": "{}", "integrity": true{}}}"##,
+                outdir_path.to_str().unwrap(),
+                temp_dir.path().to_str().unwrap(),
+                extra
+            )
+        };
+
+        let result = bundle_all(&options(r##", "emitManifest": true"##)).unwrap();
+        let manifest = json_object_value(&result, "manifest");
+        let integrity = json_object_value(&manifest, "integrity");
+
+        // Keyed by the hashed filename that's actually served, with the digest of the file
+        // as written, from `openssl dgst -sha384 -binary | base64`
+        let output = fs::read_dir(&outdir_path)
+            .unwrap()
+            .map(|entry| entry.unwrap().path())
+            .find(|path| path.extension().unwrap() == "js")
+            .unwrap();
+        let output_name = output.file_name().unwrap().to_str().unwrap();
+        assert!(output_name.starts_with("page-"));
+        assert_eq!(
+            fs::read_to_string(&output).unwrap(),
+            "console.log(\"<SRI>\");\n"
+        );
+        assert!(integrity.contains(&format!(
+            r##""dist/{}":"sha384-a6uM7KpkLo/SHWT4zDOkM1sjji+fHtPdHrKHh0+ga3Fo1rwFF1eYzhvgmi1WkKkT""##,
+            output_name
+        )));
+        assert!(integrity.contains(&format!(r##""dist/{}.map":"sha384-"##, output_name)));
+
+        let error = bundle_all(&options("")).unwrap_err();
+        assert!(error.contains("integrity needs emitManifest"));
+    }
+
+    #[test]
+    fn test_diff_build_metafiles() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(
+            temp_dir.path().join("extra.js"),
+            r##"export const extra = "<EXTRA>";"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["page.js"], "outdir": "{}", "environment": "production", "hashNames": true, "absWorkingDir": "{}"}}"##,
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let build = |contents: &str| {
+            fs::write(&entrypoint_path, contents).unwrap();
+            json_object_value(&bundle_all(&options).unwrap(), "metafile")
+        };
+
+        let previous = build(r##"console.log("<SMALL>");"##);
+        let current =
+            build(r##"import { extra } from "./extra.js"; console.log("<LARGER>", extra);"##);
+
+        // Hashed names differ between the builds but are matched by entrypoint
+        let diff = diff_build_metafiles(&previous, &current).unwrap();
+        assert!(diff.contains(r##""name":"page.js.js","previousBytes":35,"currentBytes":79,"delta":44,"status":"grown""##));
+        assert!(diff.contains(r##""name":"page.js.js.map","##));
+        assert!(!diff.contains(r##""status":"added""##));
+        assert!(diff.contains(r##""addedInputs":["extra.js"],"removedInputs":[]"##));
+
+        let total_delta = |diff: &str| -> i64 {
+            let start = diff.find(r##""totalDelta":"##).unwrap() + r##""totalDelta":"##.len();
+            let end = start + diff[start..].find(',').unwrap();
+            diff[start..end].parse().unwrap()
+        };
+        assert!(total_delta(&diff) > 0);
+        let reversed = diff_build_metafiles(&current, &previous).unwrap();
+        assert_eq!(total_delta(&reversed), -total_delta(&diff));
+
+        assert!(diff_build_metafiles("not json", &current)
+            .unwrap_err()
+            .starts_with("Previous metafile"));
+    }
+
+    #[test]
+    fn test_bundle_all_with_entrypoints() {
+        let temp_dir = tempdir().unwrap();
+        let components_path = temp_dir.path().join("app/components");
+        fs::create_dir_all(&components_path).unwrap();
+        fs::write(
+            components_path.join("Home.jsx"),
+            r##"export const Home = () => <main>{"<HOME>"}</main>;"##,
+        )
+        .unwrap();
+        fs::write(
+            components_path.join("About.jsx"),
+            r##"export const About = () => <main>{"<ABOUT>"}</main>;"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": [], "outdir": "dist", "environment": "production", "emitManifest": true, "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        );
+        // A generated router, and a page entry whose imports resolve from its own directory
+        let entrypoints = r##"[
+            {"name": "routes/index.jsx", "contents": "import { Home } from './app/components/Home.jsx';\nimport { About } from './app/components/About.jsx';\nexport const routes = { '/': Home, '/about': About };"},
+            {"name": "routes/about.js", "contents": "import { About } from './About.jsx'; console.log(About);", "resolveDir": "app/components"}
+        ]"##;
+        let result = bundle_all_with_entrypoints(&options, entrypoints).unwrap();
+
+        let router = fs::read_to_string(temp_dir.path().join("dist/routes/index.js")).unwrap();
+        assert!(router.contains("<HOME>"));
+        assert!(temp_dir.path().join("dist/routes/about.js").exists());
+        let manifest = json_object_value(&result, "manifest");
+        assert!(manifest.contains(
+            r##"{"source":"virtual-entry:routes/index.jsx","output":"dist/routes/index.js"}"##
+        ));
+
+        // Unresolvable imports are reported against the virtual entry
+        let error = bundle_all_with_entrypoints(
+            &options,
+            r##"[{"name": "broken.js", "contents": "import './missing.js';"}]"##,
+        )
+        .unwrap_err();
+        assert!(error.contains("virtual-entry:broken.js"));
+        assert!(error.contains("./missing.js"));
+
+        let error = bundle_all_with_entrypoints(
+            &options,
+            r##"[{"name": "../outside.js", "contents": ""}]"##,
+        )
+        .unwrap_err();
+        assert!(error.contains(r##"Invalid virtual entrypoint "../outside.js""##));
+
+        let error = bundle_all_with_entrypoints(
+            &options,
+            r##"[{"name": "page.js", "contents": "import './Page.jsx';", "resolveDir": "app/pages"}]"##,
+        )
+        .unwrap_err();
+        assert!(error.contains(
+            r##"Resolve directory of virtual entrypoint "page.js" not found: app/pages"##
+        ));
+
+        let error = bundle_all_with_entrypoints(&options, "[]").unwrap_err();
+        assert!(error.contains("No entrypoints provided"));
+    }
+
+    #[test]
+    fn test_bundle_all_vendor_packages() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        for (pkg, marker) in [
+            ("left", "<LEFT>"),
+            ("right", "<RIGHT>"),
+            ("other", "<OTHER>"),
+        ] {
+            fs::create_dir_all(node_modules_path.join(pkg)).unwrap();
+            fs::write(
+                node_modules_path.join(pkg).join("index.js"),
+                format!(r##"export const {} = () => "{}";"##, pkg, marker),
+            )
+            .unwrap();
+        }
+        fs::write(
+            temp_dir.path().join("shared.js"),
+            r##"export const shared = () => "<SHARED>";"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("home.js"),
+            r##"import { left } from "left"; import { right } from "right"; import { shared } from "./shared.js"; console.log(left(), right(), shared());"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("about.js"),
+            r##"import { left } from "left"; import { right } from "right"; import { other } from "other"; import { shared } from "./shared.js"; console.log(left(), right(), other(), shared());"##,
+        )
+        .unwrap();
+
+        let options = |vendor_packages: &str| {
+            format!(
+                r##"{{"entrypoints": ["home.js", "about.js"], "outdir": "dist", "environment": "production", "emitManifest": true, "vendorPackages": {}, "absWorkingDir": "{}"}}"##,
+                vendor_packages,
+                temp_dir.path().to_str().unwrap()
+            )
+        };
+        let result = bundle_all(&options(r##"["left", "right"]"##)).unwrap();
+        let manifest = json_object_value(&result, "manifest");
+        let vendor_start = manifest.find(r##""vendor":["##).unwrap() + r##""vendor":["##.len();
+        let vendor: Vec<&str> = manifest
+            [vendor_start..vendor_start + manifest[vendor_start..].find(']').unwrap()]
+            .split(',')
+            .map(|path| path.trim_matches('"'))
+            .collect();
+
+        // Both packages share a single chunk, apart from the app's shared code
+        let vendor_chunks: Vec<&&str> = vendor
+            .iter()
+            .filter(|path| path.starts_with("dist/chunk-"))
+            .collect();
+        assert_eq!(vendor_chunks.len(), 1);
+        let vendor_chunk = fs::read_to_string(temp_dir.path().join(vendor_chunks[0])).unwrap();
+        assert!(vendor_chunk.contains("<LEFT>") && vendor_chunk.contains("<RIGHT>"));
+        assert!(!vendor_chunk.contains("<SHARED>") && !vendor_chunk.contains("<OTHER>"));
+        assert!(manifest.contains(r##""source":"virtual-entry:vendor.js""##));
+
+        let app_chunk = fs::read_dir(temp_dir.path().join("dist"))
+            .unwrap()
+            .map(|entry| entry.unwrap().path())
+            .filter(|path| path.extension().unwrap() == "js")
+            .map(|path| fs::read_to_string(path).unwrap())
+            .find(|contents| contents.contains("<SHARED>") && !contents.contains("console.log"))
+            .unwrap();
+        assert!(!app_chunk.contains("<LEFT>"));
+
+        let error = bundle_all(&options(r##"["./left"]"##)).unwrap_err();
+        assert!(error.contains(r##"Invalid vendor package "./left""##));
+    }
+
+    #[test]
+    fn test_bundle_all_browserslist() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"export const name = window.user?.name ?? "<ANONYMOUS>";"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("package.json"),
+            r##"{"name": "app", "browserslist": {
+                "production": ["chrome >= 100", "and_chr >= 110", "safari 15.4", "ios_saf 15.2-15.4", "firefox > 102", "not dead"],
+                "defaults": ["chrome >= 120"]
+            }}"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join(".browserslistrc"),
+            "# Supported browsers\n[production]\nchrome >= 60, safari >= 12\n",
+        )
+        .unwrap();
+
+        let options = |environment: &str, extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["page.js"], "outdir": "dist", "environment": "{}", "useBrowserslist": true, "absWorkingDir": "{}"{}}}"##,
+                environment,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            )
+        };
+
+        // package.json takes precedence, with the oldest version of each engine
+        let resolved = resolve_bundle_config(&options("production", "")).unwrap();
+        assert!(resolved.contains(r##""target":"chrome100,firefox102,ios15.2,safari15.4""##));
+        let resolved = resolve_bundle_config(&options("development", "")).unwrap();
+        assert!(resolved.contains(r##""target":"chrome120""##));
+
+        // Old enough engines get modern syntax lowered
+        bundle_all(&options(
+            "production",
+            r##", "browserslistPath": ".browserslistrc""##,
+        ))
+        .unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("dist/page.js")).unwrap();
+        assert!(!output.contains("?.") && !output.contains("??"));
+        bundle_all(&options("production", "")).unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("dist/page.js")).unwrap();
+        assert!(output.contains("?.") && output.contains("??"));
+
+        fs::write(
+            temp_dir.path().join(".browserslistrc"),
+            "> 0.5%, last 2 versions\n",
+        )
+        .unwrap();
+        let error = bundle_all(&options(
+            "production",
+            r##", "browserslistPath": ".browserslistrc""##,
+        ))
+        .unwrap_err();
+        assert!(error.contains(r##"Unsupported browserslist query "> 0.5%""##));
+
+        let error = bundle_all(&options("production", r##", "target": "es2020""##)).unwrap_err();
+        assert!(error.contains("target and useBrowserslist can't both be set"));
+
+        // A project config's target is replaced rather than conflicting
+        fs::write(
+            temp_dir.path().join("esbuild.config.json"),
+            r##"{"target": "es2019"}"##,
+        )
+        .unwrap();
+        let config_option = format!(
+            r##", "configFile": "{}""##,
+            temp_dir
+                .path()
+                .join("esbuild.config.json")
+                .to_str()
+                .unwrap()
+        );
+        let resolved = resolve_bundle_config(&options("development", &config_option)).unwrap();
+        assert!(resolved.contains(r##""target":"chrome120""##));
+        bundle_all(&options("production", &config_option)).unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("dist/page.js")).unwrap();
+        assert!(output.contains("?.") && output.contains("??"));
+    }
+
+    #[test]
+    fn test_bundle_all_iife_widget() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("widget.js"),
+            r##"
+            export const mounted = [];
+            export function init() {
+                mounted.push("<CHAT>");
+            }
+            "##,
+        )
+        .unwrap();
+
+        let options = |extra: &str| {
+            format!(
+                r##"{{"entrypoints": ["widget.js"], "outdir": "dist", "environment": "production", "format": "iife", "absWorkingDir": "{}"{}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            )
+        };
+        bundle_all(&options(
+            r##", "globalName": "Acme.chat", "autoInit": "init""##,
+        ))
+        .unwrap();
+
+        // The exports are assigned to the global before the footer calls init through it
+        let output = fs::read_to_string(temp_dir.path().join("dist/widget.js")).unwrap();
+        assert!(output.starts_with("var Acme;\n(Acme ||= {}).chat = (() => {"));
+        assert!(output.ends_with("})();\nAcme.chat.init();\n"));
+
+        bundle_all(&options(r##", "globalName": "AcmeChat""##)).unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("dist/widget.js")).unwrap();
+        assert!(output.starts_with("var AcmeChat = (() => {"));
+        assert!(output.ends_with("})();\n"));
+
+        let error = bundle_all(&options(r##", "autoInit": "init""##)).unwrap_err();
+        assert!(error.contains("autoInit needs a globalName"));
+        let error = bundle_all(&options(
+            r##", "globalName": "AcmeChat", "autoInit": "init()""##,
+        ))
+        .unwrap_err();
+        assert!(error.contains(r##"Invalid autoInit "init()""##));
+        let error =
+            bundle_all(&options(r##", "globalName": "AcmeChat", "format": "esm""##)).unwrap_err();
+        assert!(error.contains("globalName needs the iife format"));
+    }
+
+    #[test]
+    fn test_bundle_all_top_level_await() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("config.js"),
+            r##"const response = await fetch("/config.json"); console.log("<TLA>", response);"##,
+        )
+        .unwrap();
+
+        let build = |extra: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["config.js"], "outdir": "dist", "absWorkingDir": "{}"{}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            ))
+        };
+
+        build(r##", "format": "esm""##).unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("dist/config.js")).unwrap();
+        assert!(output.contains("await fetch"));
+
+        let error = build(r##", "format": "iife""##).unwrap_err();
+        assert!(error.contains(r##"not supported with the "iife" output format"##));
+        assert!(error.contains("top-level await requires format esm and a compatible target"));
+        assert!(error.contains(r##"build with format "esm""##));
+
+        // Marking the feature unsupported fails the same way an old target does
+        for extra in [
+            r##", "format": "esm", "supported": {"top-level-await": false}"##,
+            r##", "format": "esm", "target": "es2020""##,
+        ] {
+            let error = build(extra).unwrap_err();
+            assert!(error.contains("not available in the configured target environment"));
+            assert!(error.contains("top-level await requires format esm and a compatible target"));
+            assert!(error.contains("raise the target to es2022"));
+        }
+
+        // Other errors are left alone
+        let error = build(r##", "supported": {"top-level-awaits": false}"##).unwrap_err();
+        assert!(error.contains("is not a valid feature name"));
+        assert!(!error.contains("top-level await requires"));
+    }
+
+    #[test]
+    fn test_bundle_all_mangle_cache() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("counter.js");
+        let build = |mangle_cache: &str| {
+            let result = bundle_all(&format!(
+                r##"{{"entrypoints": ["counter.js"], "outdir": "dist", "minify": true, "mangleProps": "^_", "absWorkingDir": "{}"{}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                mangle_cache
+            ))
+            .unwrap();
+            let output = fs::read_to_string(temp_dir.path().join("dist/counter.js")).unwrap();
+            (json_object_value(&result, "mangleCache"), output)
+        };
+
+        fs::write(
+            &entrypoint_path,
+            r##"export const counter = { _count: 0, _label: "clicks" }; console.log(counter._count, counter._label);"##,
+        )
+        .unwrap();
+        let (first_cache, _) = build("");
+        let count_name = json_string_value(&first_cache, "_count");
+        let label_name = json_string_value(&first_cache, "_label");
+
+        // A new, more frequent property would take the shortest name
+        fs::write(
+            &entrypoint_path,
+            r##"export const counter = { _step: 1, _count: 0, _label: "clicks" }; counter._count += counter._step * counter._step * counter._step; console.log(counter._count, counter._label, counter._step);"##,
+        )
+        .unwrap();
+        let (_, uncached_output) = build("");
+        let (second_cache, output) = build(&format!(r##", "mangleCache": {}"##, first_cache));
+        assert_eq!(json_string_value(&second_cache, "_count"), count_name);
+        assert_eq!(json_string_value(&second_cache, "_label"), label_name);
+        assert!(!json_string_value(&second_cache, "_step").is_empty());
+        assert!(output.contains(&format!(".{}", count_name)));
+        assert_ne!(output, uncached_output);
+
+        // The same cache gives the same names every time
+        let (_, repeated_output) = build(&format!(r##", "mangleCache": {}"##, first_cache));
+        assert_eq!(repeated_output, output);
+
+        // Off unless mangleProps is set
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": ["counter.js"], "outdir": "dist", "mangleCache": {}, "absWorkingDir": "{}"}}"##,
+            first_cache,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert!(error.contains("mangleCache needs mangleProps"));
+        let result = bundle_all(&format!(
+            r##"{{"entrypoints": ["counter.js"], "outdir": "dist", "minify": true, "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap();
+        assert!(!result.contains("mangleCache"));
+    }
+
+    #[test]
+    fn test_diff_options() {
+        let differences = diff_options(
+            r##"{"entrypoints": ["page.js"], "target": "es2020", "minify": true, "defines": {"API_URL": "\"https://a.example\"", "STRIPE_SECRET": "\"one\"", "SHARED": "1"}}"##,
+            r##"{"entrypoints": ["page.js"], "target": "es2022", "minify": true, "defines": {"API_URL": "\"https://b.example\"", "STRIPE_SECRET": "\"two\"", "SHARED": "1", "DEBUG": "true"}}"##,
+        )
+        .unwrap();
+        assert_eq!(
+            differences,
+            concat!(
+                r##"[{"option":"defines.API_URL","a":"\"https://a.example\"","b":"\"https://b.example\""},"##,
+                r##"{"option":"defines.DEBUG","a":null,"b":"true"},"##,
+                r##"{"option":"defines.STRIPE_SECRET","a":"[redacted]","b":"[redacted]"},"##,
+                r##"{"option":"target","a":"es2020","b":"es2022"}]"##
+            )
+        );
+
+        // Compared after the production preset, so spelling out its defaults is no change
+        let differences = diff_options(
+            r##"{"entrypoints": ["page.js"], "production": true}"##,
+            r##"{"entrypoints": ["page.js"], "production": true, "minify": true, "charset": "utf8"}"##,
+        )
+        .unwrap();
+        assert_eq!(differences, "[]");
+        let differences = diff_options(
+            r##"{"entrypoints": ["page.js"], "production": true}"##,
+            r##"{"entrypoints": ["page.js"], "production": true, "minify": false}"##,
+        )
+        .unwrap();
+        assert_eq!(differences, r##"[{"option":"minify","a":true,"b":false}]"##);
+
+        assert!(diff_options("{}", "not json")
+            .unwrap_err()
+            .contains("Invalid bundle options"));
+    }
+
+    #[test]
+    fn test_bundle_all_missing_peer_dependency() {
+        let temp_dir = tempdir().unwrap();
+        let package_dir = temp_dir.path().join("node_modules/chart-lib");
+        fs::create_dir_all(&package_dir).unwrap();
+        fs::write(
+            package_dir.join("package.json"),
+            r##"{"name": "chart-lib", "version": "2.1.0", "main": "index.js", "peerDependencies": {"react": "^18.0.0"}}"##,
+        )
+        .unwrap();
+        fs::write(
+            package_dir.join("index.js"),
+            r##"import { createElement } from "react"; import format from "date-fns/format"; export const Chart = () => createElement("svg", null, format(new Date()));"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"import { Chart } from "chart-lib"; import "missing-in-app"; console.log(Chart);"##,
+        )
+        .unwrap();
+
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": ["page.js"], "outdir": "dist", "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert!(error.contains(
+            r##"Could not resolve "react". Note: package chart-lib requires peer react@^18.0.0, which is not installed"##
+        ));
+
+        // Only peers get the note: not other missing dependencies, nor the app's own imports
+        assert!(error.contains(r##"Could not resolve "date-fns/format""##));
+        assert!(error.contains(r##"Could not resolve "missing-in-app""##));
+        assert_eq!(error.matches("requires peer").count(), 1);
+    }
+
+    #[test]
+    fn test_bundle_all_banner_line_endings() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("widget.css"),
+            ".widget { color: red; }",
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("widget.js"),
+            r##"import "./widget.css"; export function init() { console.log("<WIDGET>"); }"##,
+        )
+        .unwrap();
+
+        let build = |extra: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["widget.js"], "outdir": "dist", "absWorkingDir": "{}"{}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            ))
+        };
+        build(
+            r##", "format": "iife", "globalName": "AcmeWidget", "autoInit": "init", "banner": {"js": "/**\r\n * Acme widget\r\n */", "css": "/* Acme\r\n   widget */"}, "footer": {"js": "// end\r\n// of widget\r"}"##,
+        )
+        .unwrap();
+
+        let js = fs::read_to_string(temp_dir.path().join("dist/widget.js")).unwrap();
+        let css = fs::read_to_string(temp_dir.path().join("dist/widget.css")).unwrap();
+        assert!(!js.contains('\r') && !css.contains('\r'));
+        assert!(js.starts_with("/**\n * Acme widget\n */\n"));
+        assert!(css.starts_with("/* Acme\n   widget */\n"));
+        // The host's footer comes before the autoInit call
+        assert!(js.contains("// end\n// of widget\n\nAcmeWidget.init();"));
+
+        assert!(build(r##", "banner": {"html": "<!-- Acme -->"}"##)
+            .unwrap_err()
+            .contains(r##"Invalid banner type "html""##));
+    }
+
+    #[test]
+    fn test_bundle_all_incremental() {
+        let temp_dir = tempdir().unwrap();
+        for (name, contents) in [
+            (
+                "home.js",
+                r##"import { greeting } from "./home_copy.js"; console.log(greeting);"##,
+            ),
+            ("home_copy.js", r##"export const greeting = "<HOME V1>";"##),
+            (
+                "about.js",
+                r##"import { title } from "./about_copy.js"; console.log(title);"##,
+            ),
+            ("about_copy.js", r##"export const title = "<ABOUT V1>";"##),
+        ] {
+            fs::write(temp_dir.path().join(name), contents).unwrap();
+        }
+
+        let options = format!(
+            r##"{{"entrypoints": ["home.js", "about.js"], "outdir": "dist", "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        );
+        let build = |prior_metafile: Option<&str>| {
+            let result = bundle_all_incremental(&options, true, prior_metafile).unwrap();
+            let metafile = json_object_value(&result, "metafile").to_string();
+            (result, metafile)
+        };
+
+        // Without a previous build, everything is built
+        let (first, metafile) = build(None);
+        assert!(!first.contains(r##""reused""##));
+
+        // Past the filesystem's timestamp granularity, so the edit is newer
+        thread::sleep(std::time::Duration::from_millis(50));
+        fs::write(
+            temp_dir.path().join("home_copy.js"),
+            r##"export const greeting = "<HOME V2>";"##,
+        )
+        .unwrap();
+
+        let (second, metafile) = build(Some(&metafile));
+        assert!(second.contains(r##""reused":["about.js"]"##));
+        let home = fs::read_to_string(temp_dir.path().join("dist/home.js")).unwrap();
+        assert!(home.contains("<HOME V2>"));
+        // The reused entrypoint's outputs are still part of the result
+        assert!(second.contains("about.js.map"));
+        assert!(metafile.contains(r##""dist/about.js""##));
+        assert!(metafile.contains(r##""about_copy.js""##));
+
+        // Nothing changed since, so nothing is built
+        let (third, _) = build(Some(&metafile));
+        assert!(third.contains(r##""reused":["home.js","about.js"]"##));
+
+        // Switched off, the prior metafile is ignored
+        let full = bundle_all_incremental(&options, false, Some(&metafile)).unwrap();
+        assert!(!full.contains(r##""reused""##));
+    }
+
+    #[test]
+    fn test_bundle_all_charset() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("messages.js"),
+            r##"export const messages = { ja: "ようこそ", ru: "Добро пожаловать", emoji: "👋🌍" }; console.log(messages);"##,
+        )
+        .unwrap();
+
+        let build = |extra: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["messages.js"], "outdir": "dist", "environment": "production", "hashNames": false, "absWorkingDir": "{}"{}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                extra
+            ))
+            .unwrap();
+            fs::read_to_string(temp_dir.path().join("dist/messages.js")).unwrap()
+        };
+
+        // Production keeps the text as UTF-8 unless told otherwise
+        let utf8_output = build(r##", "production": true"##);
+        assert!(utf8_output.contains("ようこそ") && utf8_output.contains("👋🌍"));
+        let ascii_output = build(r##", "production": true, "charset": "ascii""##);
+        assert!(ascii_output.is_ascii());
+        assert!(ascii_output.contains("\\u3088\\u3046\\u3053\\u305D"));
+        assert!(utf8_output.len() < ascii_output.len());
+        assert!(build("").is_ascii());
+
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": ["messages.js"], "outdir": "dist", "charset": "latin1", "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert!(error.contains(r##"Unknown charset "latin1""##));
+    }
+
+    #[test]
+    fn test_bundle_all_precache_manifest() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(temp_dir.path().join("logo.png"), b"\x89PNG<LOGO>").unwrap();
+        fs::write(temp_dir.path().join("inter.woff2"), b"wOF2<FONT>").unwrap();
+        fs::write(
+            temp_dir.path().join("app.css"),
+            r##"@font-face { font-family: Inter; src: url("./inter.woff2"); } body { font-family: Inter; }"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("app.js"),
+            r##"import "./app.css"; import logo from "./logo.png"; console.log(logo);"##,
+        )
+        .unwrap();
+
+        let result = bundle_all(&format!(
+            r##"{{"entrypoints": ["app.js"], "outdir": "dist", "hashNames": true, "absWorkingDir": "{}", "loaders": {{".png": "file", ".woff2": "file"}}, "assetNames": "assets/[name]-[hash]", "emitPrecacheManifest": true}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap();
+        let manifest_path = outdir_path.join("precache-manifest.json");
+        assert_eq!(
+            json_string_value(&result, "precacheManifest"),
+            manifest_path.to_str().unwrap()
+        );
+        let manifest = fs::read_to_string(&manifest_path).unwrap();
+
+        // Every output but sourcemaps and the manifest itself, under its hashed name
+        let mut outputs = Vec::new();
+        for directory in [outdir_path.clone(), outdir_path.join("assets")] {
+            for entry in fs::read_dir(&directory).unwrap() {
+                let path = entry.unwrap().path();
+                if path.is_file() {
+                    let url = path
+                        .strip_prefix(&outdir_path)
+                        .unwrap()
+                        .to_str()
+                        .unwrap()
+                        .to_string();
+                    outputs.push(url);
+                }
+            }
+        }
+        let precached: Vec<&String> = outputs
+            .iter()
+            .filter(|url| !url.ends_with(".map") && *url != "precache-manifest.json")
+            .collect();
+        assert_eq!(precached.len(), 4);
+        for url in &precached {
+            assert!(url.contains('-'), "{} has no hash", url);
+            assert!(
+                manifest.contains(&format!(r##""url": "{}""##, url)),
+                "{} missing",
+                url
+            );
+        }
+        assert_eq!(manifest.matches(r##""url":"##).count(), precached.len());
+        assert!(!manifest.contains(".map\""));
+        assert!(!manifest.contains("precache-manifest.json"));
+
+        let revision = json_string_value(&manifest.replace(": ", ":"), "revision");
+        assert_eq!(revision.len(), 16);
+        assert!(revision.chars().all(|c| c.is_ascii_hexdigit()));
+    }
+
+    #[test]
+    fn test_bundle_all_timing() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("page.js"),
+            r##"console.log("<TIMED>");"##,
+        )
+        .unwrap();
+
+        let result = bundle_all(&format!(
+            r##"{{"entrypoints": ["page.js"], "outdir": "dist", "environment": "production", "absWorkingDir": "{}"}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap();
+
+        let timing = json_object_value(&result, "timing");
+        let milliseconds = |key: &str| -> f64 {
+            let prefix = format!("\"{}\":", key);
+            let start = timing.find(&prefix).unwrap() + prefix.len();
+            let end = start + timing[start..].find([',', '}']).unwrap();
+            timing[start..end].parse().unwrap()
+        };
+        let (total, build, write) = (
+            milliseconds("totalMs"),
+            milliseconds("buildMs"),
+            milliseconds("writeMs"),
+        );
+        assert!(build > 0.0);
+        assert!(write > 0.0);
+        assert!(total >= build + write);
+    }
+
+    #[test]
+    fn test_bundle_all_io_concurrency() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoints: Vec<String> = (0..16)
+            .map(|index| {
+                let name = format!("page{}.js", index);
+                fs::write(
+                    temp_dir.path().join(&name),
+                    format!(r##"console.log("<PAGE {}>");"##, index),
+                )
+                .unwrap();
+                format!(r##""{}""##, name)
+            })
+            .collect();
+
+        let io_workers = |extra: &str| -> usize {
+            let result = bundle_all(&format!(
+                r##"{{"entrypoints": [{}], "outdir": "dist", "absWorkingDir": "{}"{}}}"##,
+                entrypoints.join(", "),
+                temp_dir.path().to_str().unwrap(),
+                extra
+            ))
+            .unwrap();
+            assert_eq!(
+                fs::read_dir(temp_dir.path().join("dist")).unwrap().count(),
+                32
+            );
+            let timing = json_object_value(&result, "timing");
+            let start = timing.find(r##""ioWorkers":"##).unwrap() + r##""ioWorkers":"##.len();
+            let end = start + timing[start..].find([',', '}']).unwrap();
+            timing[start..end].parse().unwrap()
+        };
+
+        assert_eq!(io_workers(r##", "ioConcurrency": 1"##), 1);
+        let workers = io_workers(r##", "ioConcurrency": 3"##);
+        assert!((1..=3).contains(&workers));
+        // Gzipping outputs for budgets shares the cap
+        let workers =
+            io_workers(r##", "ioConcurrency": 2, "maxOutputBytes": 100000, "budgetGzip": true"##);
+        assert!((1..=2).contains(&workers));
+        // GOMAXPROCS by default
+        let cpus = std::thread::available_parallelism().unwrap().get();
+        assert!((1..=cpus).contains(&io_workers("")));
+
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": [{}], "outdir": "dist", "ioConcurrency": -1, "absWorkingDir": "{}"}}"##,
+            entrypoints.join(", "),
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert!(error.contains("ioConcurrency can't be negative"));
+    }
+
+    #[test]
+    fn test_bundle_all_clean_stale() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::create_dir_all(&outdir_path).unwrap();
+        fs::write(outdir_path.join("robots.txt"), "User-agent: *").unwrap();
+
+        let build = |contents: &str, clean_stale: bool| {
+            fs::write(&entrypoint_path, contents).unwrap();
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["page.js"], "outdir": "{}", "environment": "production", "hashNames": true, "absWorkingDir": "{}", "cleanStale": {}}}"##,
+                outdir_path.to_str().unwrap(),
+                temp_dir.path().to_str().unwrap(),
+                clean_stale
+            ))
+            .unwrap()
+        };
+        let outdir_files = || {
+            let mut files: Vec<String> = fs::read_dir(&outdir_path)
+                .unwrap()
+                .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+                .collect();
+            files.sort();
+            files
+        };
+
+        build(r##"console.log("<FIRST>");"##, false);
+        let first_files = outdir_files();
+        assert_eq!(first_files.len(), 3);
+
+        // Without the flag the previous hash is left behind
+        build(r##"console.log("<SECOND>");"##, false);
+        assert_eq!(outdir_files().len(), 5);
+
+        let result = build(r##"console.log("<THIRD>");"##, true);
+        let files = outdir_files();
+        assert_eq!(files.len(), 3);
+        assert!(files.contains(&"robots.txt".to_string()));
+        assert!(!files
+            .iter()
+            .any(|file| first_files.contains(file) && file != "robots.txt"));
+        // Both earlier builds' script and sourcemap
+        let removed_start = result.find(r##""removed":["##).unwrap();
+        let removed =
+            &result[removed_start..removed_start + result[removed_start..].find(']').unwrap()];
+        assert_eq!(removed.matches(".js").count(), 4);
+        for file in &first_files {
+            if file != "robots.txt" {
+                assert!(removed.contains(outdir_path.join(file).to_str().unwrap()));
+            }
+        }
+
+        // Nothing is stale on an unchanged rebuild
+        let result = build(r##"console.log("<THIRD>");"##, true);
+        assert!(!result.contains(r##""removed""##));
+        assert_eq!(outdir_files(), files);
+    }
+
+    #[test]
+    fn test_bundle_all_import_attributes() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(temp_dir.path().join("data.txt"), r##"{"key": "<DATA>"}"##).unwrap();
+        fs::write(temp_dir.path().join("theme.css"), ".theme { color: red; }").unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import data from "./data.txt" with { type: "json" };
+import text from "./data.txt";
+import sheet from "./theme.css" with { type: "css" };
+import "./theme.css";
+document.adoptedStyleSheets = [sheet];
+console.log(data.key, text);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "production"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        // The attribute picks the loader, and the plain imports keep the extension's
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains(r##"key: "<DATA>""##));
+        assert!(output.contains(r##"'{"key": "<DATA>"}'"##));
+        assert!(output.contains(r##"sheet.replaceSync(".theme { color: red; }")"##));
+
+        let stylesheet = fs::read_to_string(outdir_path.join("page.css")).unwrap();
+        assert!(stylesheet.contains(".theme {"));
+
+        fs::write(
+            &entrypoint_path,
+            r##"import data from "./data.txt" with { type: "text" };"##,
+        )
+        .unwrap();
+        let error = bundle_all(&options).unwrap_err();
+        assert!(error.contains(r##"Importing with a type attribute of "text" is not supported"##));
+    }
+
+    #[test]
+    fn test_analyze_unused_code() {
+        let metafile = r##"{
+            "inputs": {
+                "page.js": {"bytes": 120, "imports": [{"path": "dead.js", "kind": "import-statement"}]},
+                "dead.js": {"bytes": 40, "imports": []}
+            },
+            "outputs": {
+                "dist/page.js": {
+                    "bytes": 80,
+                    "inputs": {"page.js": {"bytesInOutput": 60}, "dead.js": {"bytesInOutput": 0}},
+                    "imports": [],
+                    "exports": ["Page"],
+                    "entryPoint": "page.js"
+                }
+            }
+        }"##;
+
+        let report = analyze_unused_code(metafile).unwrap();
+        assert_eq!(
+            report,
+            r##"{"eliminatedInputs":["dead.js"],"unusedExports":{"page.js":["Page"]}}"##
+        );
+    }
+
+    #[test]
+    fn test_bundle_all_notices() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        let licensed_path = node_modules_path.join("licensed");
+        fs::create_dir_all(&licensed_path).unwrap();
+        fs::write(
+            licensed_path.join("package.json"),
+            r##"{"name": "licensed", "version": "1.0.0", "license": "MIT", "main": "index.js"}"##,
+        )
+        .unwrap();
+        fs::write(licensed_path.join("LICENSE"), "<LICENSE TEXT>").unwrap();
+        fs::write(licensed_path.join("index.js"), "export const a = 'a';").unwrap();
+
+        let unlicensed_path = node_modules_path.join("unlicensed");
+        fs::create_dir_all(&unlicensed_path).unwrap();
+        fs::write(
+            unlicensed_path.join("package.json"),
+            r##"{"name": "unlicensed", "version": "2.0.0", "main": "index.js"}"##,
+        )
+        .unwrap();
+        fs::write(unlicensed_path.join("index.js"), "export const b = 'b';").unwrap();
+
+        fs::write(
+            &entrypoint_path,
+            r##"import { a } from "licensed"; import { b } from "unlicensed"; console.log(a, b);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "nodeModulesPath": "{}", "emitNotices": true}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            node_modules_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        let notices = fs::read_to_string(outdir_path.join("NOTICES.txt")).unwrap();
+        assert!(notices.contains("licensed@1.0.0\nLicense: MIT\n\n<LICENSE TEXT>"));
+        assert!(notices.contains("unlicensed@2.0.0\nLicense: unknown"));
+    }
+
+    #[test]
+    fn test_bundle_all_drop_console_in_prod() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        fs::write(
+            &entrypoint_path,
+            r##"console.log("<CONSOLE>"); export const value = "<VALUE>";"##,
+        )
+        .unwrap();
+
+        for (environment, expect_console) in [("development", true), ("production", false)] {
+            let outdir_path = temp_dir.path().join(environment);
+            let options = format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "environment": "{}", "dropConsoleInProd": true}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                environment
+            );
+            bundle_all(&options).unwrap();
+
+            let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+            assert!(output.contains("<VALUE>"));
+            assert_eq!(
+                output.contains("<CONSOLE>"),
+                expect_console,
+                "Unexpected console handling for {}",
+                environment
+            );
+        }
+    }
+
+    #[test]
+    fn test_bundle_all_tsconfig_extends() {
+        let temp_dir = tempdir().unwrap();
+        let project_path = temp_dir.path().join("project");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::create_dir_all(project_path.join("config")).unwrap();
+        fs::create_dir_all(project_path.join("src/lib")).unwrap();
+
+        // The alias is only defined in the base config, relative to its own directory
+        fs::write(
+            project_path.join("config/tsconfig.base.json"),
+            r##"{"compilerOptions": {"baseUrl": "..", "paths": {"@lib/*": ["src/lib/*"]}}}"##,
+        )
+        .unwrap();
+        fs::write(
+            project_path.join("tsconfig.json"),
+            r##"{"extends": "./config/tsconfig.base.json"}"##,
+        )
+        .unwrap();
+        fs::write(
+            project_path.join("src/lib/value.ts"),
+            r##"export const value: string = "<ALIASED>";"##,
+        )
+        .unwrap();
+        fs::write(
+            project_path.join("src/page.ts"),
+            r##"import { value } from "@lib/value"; console.log(value);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "tsconfig": "{}"}}"##,
+            project_path.join("src/page.ts").to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            project_path.join("tsconfig.json").to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<ALIASED>"));
+    }
+
+    #[test]
+    fn test_remove_context_twice() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        fs::write(
+            &js_file_path,
+            r##"export const Index = () => "<INITIAL>";"##,
+        )
+        .unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+
+        assert!(remove_context(context_id));
+        assert!(!remove_context(context_id));
+    }
+
+    #[test]
+    fn test_bundle_all_exclude_sources_content() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        fs::write(&entrypoint_path, r##"console.log("<SOURCE>");"##).unwrap();
+
+        for exclude in [false, true] {
+            let outdir_path = temp_dir.path().join(format!("dist-{}", exclude));
+            let options = format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "excludeSourcesContent": {}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                exclude
+            );
+            bundle_all(&options).unwrap();
+
+            // esbuild omits the sourcesContent field entirely when excluded
+            let map = fs::read_to_string(outdir_path.join("page.js.map")).unwrap();
+            assert_eq!(map.contains("\"sourcesContent\""), !exclude);
+            assert_eq!(map.contains("<SOURCE>"), !exclude);
+        }
+    }
+
+    #[test]
+    fn test_build_context_aliases() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        let output_file_path = temp_dir.path().join("ssr.js.out");
+        let node_modules_path = temp_dir.path().join("node_modules");
+
+        for (package, marker) in [("heavy", "<HEAVY>"), ("light", "<LIGHT>")] {
+            fs::create_dir_all(node_modules_path.join(package)).unwrap();
+            fs::write(
+                node_modules_path.join(package).join("index.js"),
+                format!("export const name = \"{}\";", marker),
+            )
+            .unwrap();
+        }
+
+        fs::write(
+            &js_file_path,
+            r##"import { name } from "heavy"; export const Index = () => name;"##,
+        )
+        .unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            node_modules_path.to_str().unwrap(),
+            "development",
+            0,
+            "",
+            true,
+            r##"[["heavy", "light"]]"##,
+        )
+        .unwrap();
+
+        rebuild_context(context_id).unwrap();
+        let output = fs::read_to_string(&output_file_path).unwrap();
+        assert!(output.contains("<LIGHT>"));
+        assert!(!output.contains("<HEAVY>"));
+
+        let conflict = get_build_context(
+            &temp_dir.path().join("other.js").to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            r##"[["heavy", "light"], ["heavy", "other"]]"##,
+        );
+        assert!(conflict.unwrap_err().contains("Conflicting aliases"));
+    }
+
+    #[test]
+    fn test_bundle_all_build_hash() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+
+        let build = |contents: &str, outdir: &str| {
+            fs::write(&entrypoint_path, contents).unwrap();
+            let options = format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}"}}"##,
+                entrypoint_path.to_str().unwrap(),
+                temp_dir.path().join(outdir).to_str().unwrap()
+            );
+            json_string_value(&bundle_all(&options).unwrap(), "buildHash")
+        };
+
+        let first_hash = build(r##"console.log("<INITIAL>");"##, "dist-a");
+        let second_hash = build(r##"console.log("<INITIAL>");"##, "dist-b");
+        let updated_hash = build(r##"console.log("<UPDATED>");"##, "dist-c");
+
+        assert_eq!(first_hash, second_hash);
+        assert_ne!(first_hash, updated_hash);
+    }
+
+    #[test]
+    fn test_bundle_all_svg_imports() {
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::create_dir_all(node_modules_path.join("react")).unwrap();
+        fs::write(
+            node_modules_path.join("react/index.js"),
+            "export const createElement = (...args) => args;",
+        )
+        .unwrap();
+        fs::create_dir_all(node_modules_path.join("icons")).unwrap();
+        fs::write(
+            node_modules_path.join("icons/check.svg"),
+            r##"<svg id="<CHECK>"></svg>"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("icon.svg"),
+            r##"<svg id="<ICON>"></svg>"##,
+        )
+        .unwrap();
+        fs::write(
+            &entrypoint_path,
+            r##"import iconUrl from "./icon.svg"; import Icon from "./icon.svg?react"; import Check from "icons/check.svg?react"; console.log(iconUrl, Icon, Check);"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "nodeModulesPath": "{}"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            node_modules_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        // The plain import is emitted as a file and referenced by URL, while the
+        // ?react import inlines the markup into a component
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("dangerouslySetInnerHTML"));
+        assert!(output.contains("<ICON>"));
+        assert!(fs::read_dir(&outdir_path).unwrap().any(|entry| entry
+            .unwrap()
+            .file_name()
+            .to_str()
+            .unwrap()
+            .ends_with(".svg")));
+
+        // Package paths resolve through node_modules rather than falling back
+        // to a plain import of the file
+        assert!(output.contains("<CHECK>"));
+        assert_eq!(output.matches("dangerouslySetInnerHTML").count(), 2);
+
+        fs::write(
+            &entrypoint_path,
+            r##"import Missing from "icons/missing.svg?react"; console.log(Missing);"##,
+        )
+        .unwrap();
+        let error = bundle_all(&options).unwrap_err();
+        assert!(error.contains(r##"Could not resolve "icons/missing.svg""##));
+    }
+
+    #[test]
+    fn test_bundle_all_streaming() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(&entrypoint_path, r##"console.log("<STREAMED>");"##).unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}"}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+
+        let mut files: HashMap<String, Vec<u8>> = HashMap::new();
+        let mut completed = Vec::new();
+        bundle_all_streaming(&options, 8, |path, chunk, is_last| {
+            assert!(chunk.len() <= 8);
+            assert!(!completed.contains(&path.to_string()));
+            files
+                .entry(path.to_string())
+                .or_default()
+                .extend_from_slice(chunk);
+            if is_last {
+                completed.push(path.to_string());
+            }
+        })
+        .unwrap();
+
+        let output_path = outdir_path.join("page.js");
+        let output = String::from_utf8(files[output_path.to_str().unwrap()].clone()).unwrap();
+        assert!(output.contains("<STREAMED>"));
+        assert_eq!(completed.len(), files.len());
+        assert!(!output_path.exists());
+
+        let error = bundle_all_streaming(
+            &options.replace(r##""outdir""##, r##""cleanStale": true, "outdir""##),
+            8,
+            |_, _, _| {},
+        )
+        .unwrap_err();
+        assert!(error.contains("cleanStale only applies to builds written to the outdir"));
+
+        let error = bundle_all_streaming(
+            &options.replace(r##""outdir""##, r##""emitNotices": true, "outdir""##),
+            8,
+            |_, _, _| {},
+        )
+        .unwrap_err();
+        assert!(error.contains("emitNotices only applies to builds written to the outdir"));
+        assert!(!outdir_path.exists());
+    }
+
+    #[test]
+    fn test_bundle_all_production_preset() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        fs::write(
+            &entrypoint_path,
+            r##"console.log("<CONSOLE>"); export const longVariableName = "<VALUE>";"##,
+        )
+        .unwrap();
+
+        let build = |outdir: &str, overrides: &str| {
+            let outdir_path = temp_dir.path().join(outdir);
+            let options = format!(
+                r##"{{"entrypoints": ["{}"], "outdir": "{}", "production": true{}}}"##,
+                entrypoint_path.to_str().unwrap(),
+                outdir_path.to_str().unwrap(),
+                overrides
+            );
+            bundle_all(&options).unwrap();
+
+            // Entrypoints are hashed, so find the script by its extension
+            let script_path = fs::read_dir(&outdir_path)
+                .unwrap()
+                .map(|entry| entry.unwrap().path())
+                .find(|path| path.extension().unwrap() == "js")
+                .unwrap();
+            assert_ne!(script_path.file_name().unwrap(), "page.js");
+            fs::read_to_string(script_path).unwrap()
+        };
+
+        let output = build("dist-preset", "");
+        assert!(!output.contains("<CONSOLE>"));
+        assert!(!output.contains("longVariableName = "));
+
+        let overridden_output = build("dist-override", r##", "minify": false"##);
+        assert!(!overridden_output.contains("<CONSOLE>"));
+        assert!(overridden_output.contains("longVariableName = "));
+    }
+
+    #[test]
+    fn test_bundle_all_project_config() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        let config_path = temp_dir.path().join("esbuild.config.json");
+
+        fs::write(&entrypoint_path, r##"console.log(__FIRST__, __SECOND__);"##).unwrap();
+        fs::write(
+            &config_path,
+            r##"{
+                "defines": {"__FIRST__": "\"<CONFIG_FIRST>\"", "__SECOND__": "\"<CONFIG_SECOND>\""},
+                "plugins": []
+            }"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "configFile": "{}", "defines": {{"__SECOND__": "\"<CALL_SECOND>\""}}}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap(),
+            config_path.to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+        assert!(result.contains(r##"Ignoring unknown key \"plugins\""##));
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<CONFIG_FIRST>"));
+        assert!(output.contains("<CALL_SECOND>"));
+        assert!(!output.contains("<CONFIG_SECOND>"));
+    }
+
+    #[test]
+    fn test_bundle_all_detect_cycles() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+
+        fs::write(
+            temp_dir.path().join("a.js"),
+            r##"import { b } from "./b.js"; export const a = () => b;"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("b.js"),
+            r##"import { a } from "./a.js"; export const b = () => a;"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["a.js"], "outdir": "{}", "absWorkingDir": "{}", "detectCycles": true}}"##,
+            outdir_path.to_str().unwrap(),
+            temp_dir.path().to_str().unwrap()
+        );
+        let result = bundle_all(&options).unwrap();
+        assert!(result.contains(r##""cycles":[["a.js","b.js"]]"##));
+    }
+
+    #[test]
+    fn test_set_context_fail_fast() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("broken.js");
+        fs::write(
+            &js_file_path,
+            r##"import a from "./missing-a"; import b from "./missing-b"; import c from "./missing-c"; console.log(a, b, c);"##,
+        )
+        .unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+
+        // Every error is formatted by default
+        let error = rebuild_context(context_id).unwrap_err();
+        assert_eq!(error.matches("Could not resolve").count(), 3);
+        assert!(!error.contains("more errors not shown"));
+
+        set_context_fail_fast(context_id, true).unwrap();
+        assert!(describe_context(context_id)
+            .unwrap()
+            .contains(r##""failFast":true"##));
+        let error = rebuild_context(context_id).unwrap_err();
+        assert_eq!(error.matches("Could not resolve").count(), 1);
+        assert!(error.contains("./missing-a"));
+        assert!(error.ends_with("(2 more errors not shown)\n"));
+
+        // The last result still has all of them
+        let last_result = get_last_result(context_id).unwrap();
+        assert_eq!(last_result.matches("Could not resolve").count(), 3);
+
+        set_context_fail_fast(context_id, false).unwrap();
+        let error = rebuild_context(context_id).unwrap_err();
+        assert_eq!(error.matches("Could not resolve").count(), 3);
+
+        assert!(set_context_fail_fast(-1, true)
+            .unwrap_err()
+            .contains("Context with ID -1 does not exist"));
+        remove_context(context_id);
+    }
+
+    #[test]
+    fn test_max_errors() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let imports: String = (0..200)
+            .map(|index| format!("import \"./missing-{}\";\n", index))
+            .collect();
+        let js_file_path = temp_dir.path().join("cascade.js");
+        fs::write(&js_file_path, imports).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+
+        set_context_max_errors(context_id, 5).unwrap();
+        assert!(describe_context(context_id)
+            .unwrap()
+            .contains(r##""maxErrors":5"##));
+        let error = rebuild_context(context_id).unwrap_err();
+        assert_eq!(error.matches("Could not resolve").count(), 5);
+        assert!(error.contains("./missing-4"));
+        assert!(error.ends_with("(195 more errors not shown)\n"));
+
+        // Fail fast wins over a higher cap
+        set_context_fail_fast(context_id, true).unwrap();
+        let error = rebuild_context(context_id).unwrap_err();
+        assert!(error.ends_with("(199 more errors not shown)\n"));
+
+        set_context_fail_fast(context_id, false).unwrap();
+        set_context_max_errors(context_id, 0).unwrap();
+        let error = rebuild_context(context_id).unwrap_err();
+        assert_eq!(error.matches("Could not resolve").count(), 200);
+        assert!(!error.contains("more errors not shown"));
+        assert!(set_context_max_errors(context_id, -1)
+            .unwrap_err()
+            .contains("maxErrors can't be negative"));
+        remove_context(context_id);
+
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": ["cascade.js"], "outdir": "dist", "absWorkingDir": "{}", "maxErrors": 10}}"##,
+            temp_dir.path().to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert_eq!(error.matches("Could not resolve").count(), 10);
+        assert!(error.ends_with("(190 more errors not shown)\n"));
+    }
+
+    #[test]
+    fn test_get_output_content_type() {
+        for (path, content_type) in [
+            ("dist/page-ABCD1234.js", "text/javascript; charset=utf-8"),
+            ("dist/page.mjs", "text/javascript; charset=utf-8"),
+            ("dist/page.cjs", "text/javascript; charset=utf-8"),
+            ("dist/page.css", "text/css; charset=utf-8"),
+            ("dist/page.js.map", "application/json"),
+            ("dist/page.css.map", "application/json"),
+            ("dist/assets/math-ABCD1234.wasm", "application/wasm"),
+            ("dist/assets/logo.svg", "image/svg+xml"),
+            ("dist/assets/logo.PNG", "image/png"),
+            ("dist/assets/inter.woff2", "font/woff2"),
+            ("dist/index.html", "text/html; charset=utf-8"),
+            (".json", "application/json"),
+            ("dist/LICENSE", "application/octet-stream"),
+            ("dist/data.bin", "application/octet-stream"),
+        ] {
+            assert_eq!(get_output_content_type(path), content_type, "{}", path);
+        }
+    }
+
+    #[test]
+    fn test_get_context_output() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        let output_file_path = temp_dir.path().join("ssr.js.out");
+        fs::write(
+            &js_file_path,
+            r##"export const Index = () => "<IN_MEMORY>";"##,
+        )
+        .unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+
+        // Nothing is retained until the context opts in
+        rebuild_context(context_id).unwrap();
+        assert!(get_context_output(context_id, output_file_path.to_str().unwrap()).is_err());
+
+        set_context_keep_outputs(context_id, true).unwrap();
+        rebuild_context(context_id).unwrap();
+
+        let output = get_context_output(context_id, output_file_path.to_str().unwrap()).unwrap();
+        assert!(String::from_utf8(output).unwrap().contains("<IN_MEMORY>"));
+    }
+
+    #[test]
+    fn test_get_build_contexts_targets() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let ssr_path = temp_dir.path().join("ssr.js");
+        let client_path = temp_dir.path().join("client.js");
+        let source = r##"export const pick = (a, b) => a ?? b;"##;
+        fs::write(&ssr_path, source).unwrap();
+        fs::write(&client_path, source).unwrap();
+
+        let specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "target": "es2019"}}, {{"path": "{}", "isSSR": false, "target": "esnext"}}]"##,
+            ssr_path.to_str().unwrap(),
+            client_path.to_str().unwrap()
+        );
+        let ids = get_build_contexts(&specs, "", "development", 0, "").unwrap();
+        assert_eq!(ids.len(), 2);
+
+        for id in &ids {
+            rebuild_context(*id).unwrap();
+        }
+
+        // Nullish coalescing is only lowered for the older target
+        let ssr_output = fs::read_to_string(temp_dir.path().join("ssr.js.out")).unwrap();
+        let client_output = fs::read_to_string(temp_dir.path().join("client.js.out")).unwrap();
+        assert!(!ssr_output.contains("??"));
+        assert!(client_output.contains("??"));
+    }
+
+    #[test]
+    fn test_get_build_contexts_global_names() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let layout_path = temp_dir.path().join("layout.js");
+        let page_path = temp_dir.path().join("page.js");
+        let default_path = temp_dir.path().join("default.js");
+        fs::write(&layout_path, r##"export const Index = () => "<LAYOUT>";"##).unwrap();
+        fs::write(&page_path, r##"export const Index = () => "<PAGE>";"##).unwrap();
+        fs::write(
+            &default_path,
+            r##"export const Index = () => "<DEFAULT>";"##,
+        )
+        .unwrap();
+
+        let specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "globalName": "SSRLayout"}}, {{"path": "{}", "isSSR": true, "globalName": "SSRPage"}}, {{"path": "{}", "isSSR": true}}]"##,
+            layout_path.to_str().unwrap(),
+            page_path.to_str().unwrap(),
+            default_path.to_str().unwrap()
+        );
+        let ids = get_build_contexts(&specs, "", "development", 0, "").unwrap();
+        for id in &ids {
+            rebuild_context(*id).unwrap();
+        }
+
+        // Each bundle only assigns its own global, so loading both into one context keeps
+        // both around
+        let layout_output = fs::read_to_string(temp_dir.path().join("layout.js.out")).unwrap();
+        let page_output = fs::read_to_string(temp_dir.path().join("page.js.out")).unwrap();
+        assert!(layout_output.starts_with("var SSRLayout = "));
+        assert!(!layout_output.contains("SSRPage"));
+        assert!(page_output.starts_with("var SSRPage = "));
+        assert!(!page_output.contains("SSRLayout"));
+
+        let default_output = fs::read_to_string(temp_dir.path().join("default.js.out")).unwrap();
+        assert!(default_output.starts_with("var SSR = "));
+
+        let invalid_specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "globalName": "ssr-page"}}]"##,
+            page_path.to_str().unwrap()
+        );
+        let error = get_build_contexts(&invalid_specs, "", "development", 0, "").unwrap_err();
+        assert!(error.contains("Invalid global name \"ssr-page\""));
+
+        let client_specs = format!(
+            r##"[{{"path": "{}", "isSSR": false, "globalName": "Client"}}]"##,
+            page_path.to_str().unwrap()
+        );
+        let error = get_build_contexts(&client_specs, "", "development", 0, "").unwrap_err();
+        assert!(error.contains("only SSR bundles are assigned to a global"));
+    }
+
+    #[test]
+    fn test_get_build_contexts_edge() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+
+        // A package with a Workers build, and one that only declares "main"
+        let runtime_path = node_modules_path.join("runtime");
+        fs::create_dir_all(&runtime_path).unwrap();
+        fs::write(
+            runtime_path.join("package.json"),
+            r##"{"name": "runtime", "exports": {"workerd": "./workerd.js", "edge-light": "./vercel.js", "default": "./default.js"}}"##,
+        )
+        .unwrap();
+        for (file, name) in [
+            ("workerd.js", "<WORKERD>"),
+            ("vercel.js", "<VERCEL>"),
+            ("default.js", "<DEFAULT>"),
+        ] {
+            fs::write(
+                runtime_path.join(file),
+                format!(r##"export const runtime = "{}";"##, name),
+            )
+            .unwrap();
+        }
+        let legacy_path = node_modules_path.join("legacy");
+        fs::create_dir_all(&legacy_path).unwrap();
+        fs::write(
+            legacy_path.join("package.json"),
+            r##"{"name": "legacy", "main": "./index.js"}"##,
+        )
+        .unwrap();
+        fs::write(
+            legacy_path.join("index.js"),
+            r##"export const legacy = "<LEGACY>";"##,
+        )
+        .unwrap();
+
+        let handler = r##"import { runtime } from "runtime"; import { legacy } from "legacy"; export const handle = () => [runtime, legacy, typeof global];"##;
+        let paths: Vec<_> = ["workers.js", "vercel.js", "node.js"]
+            .iter()
+            .map(|file| temp_dir.path().join(file))
+            .collect();
+        for path in &paths {
+            fs::write(path, handler).unwrap();
+        }
+
+        let specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "edge": true}}, {{"path": "{}", "isSSR": true, "edge": true, "conditions": ["edge-light", "worker", "browser"]}}, {{"path": "{}", "isSSR": true}}]"##,
+            paths[0].to_str().unwrap(),
+            paths[1].to_str().unwrap(),
+            paths[2].to_str().unwrap()
+        );
+        let ids = get_build_contexts(
+            &specs,
+            node_modules_path.to_str().unwrap(),
+            "production",
+            0,
+            "",
+        )
+        .unwrap();
+        for id in &ids {
+            rebuild_context(*id).unwrap();
+        }
+
+        let output = |file: &str| fs::read_to_string(temp_dir.path().join(file)).unwrap();
+        let workers_output = output("workers.js.out");
+        assert!(workers_output.contains("<WORKERD>"));
+        assert!(workers_output.contains("<LEGACY>"));
+        assert!(workers_output.contains("typeof global"));
+        assert!(!workers_output.contains("window"));
+
+        let description = describe_context(ids[0]).unwrap();
+        assert!(description
+            .contains(r##""platform":"neutral","conditions":["workerd","worker","browser"]"##));
+
+        let vercel_output = output("vercel.js.out");
+        assert!(vercel_output.contains("<VERCEL>"));
+        assert!(!vercel_output.contains("<WORKERD>"));
+
+        // Regular SSR bundles keep the browser defaults
+        let node_output = output("node.js.out");
+        assert!(node_output.contains("<DEFAULT>"));
+        assert!(node_output.contains("typeof window"));
+
+        let client_specs = format!(
+            r##"[{{"path": "{}", "isSSR": false, "edge": true}}]"##,
+            paths[0].to_str().unwrap()
+        );
+        let error = get_build_contexts(&client_specs, "", "production", 0, "").unwrap_err();
+        assert!(error.contains("only SSR bundles run on the edge"));
+
+        let empty_specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "edge": true, "conditions": [""]}}]"##,
+            paths[0].to_str().unwrap()
+        );
+        let error = get_build_contexts(&empty_specs, "", "production", 0, "").unwrap_err();
+        assert!(error.contains("condition names can't be empty"));
+    }
+
+    #[test]
+    fn test_get_build_contexts_automatic_jsx() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let ssr_path = temp_dir.path().join("ssr.jsx");
+
+        // Stand-in for react/jsx-runtime that renders straight to a string
+        fs::create_dir_all(node_modules_path.join("react")).unwrap();
+        fs::write(
+            node_modules_path.join("react/jsx-runtime.js"),
+            r##"
+            const render = (type, props) => typeof type === "function"
+                ? type(props)
+                : `<${type}>${[].concat(props.children).join("")}</${type}>`;
+            exports.jsx = render;
+            exports.jsxs = render;
+            "##,
+        )
+        .unwrap();
+        fs::write(
+            &ssr_path,
+            r##"
+            const Title = ({ children }) => <h1>{children}</h1>;
+            export const Index = () => <Title>{"<AUTOMATIC>"}</Title>;
+            "##,
+        )
+        .unwrap();
+
+        let specs = format!(
+            r##"[{{"path": "{}", "isSSR": true, "jsx": "automatic"}}]"##,
+            ssr_path.to_str().unwrap()
+        );
+        let ids = get_build_contexts(
+            &specs,
+            node_modules_path.to_str().unwrap(),
+            "production",
+            0,
+            "",
+        )
+        .unwrap();
+        rebuild_context(ids[0]).unwrap();
+
+        // The runtime is bundled into the IIFE rather than left as an import
+        let output = fs::read_to_string(temp_dir.path().join("ssr.jsx.out")).unwrap();
+        assert!(output.starts_with("var SSR = "));
+        assert!(output.contains("typeof type === \"function\""));
+        assert!(!output.contains("React.createElement"));
+        assert!(!output.contains("require(\"react/jsx-runtime\")"));
+
+        let invalid_specs = specs.replace("automatic", "classic");
+        let error = get_build_contexts(&invalid_specs, "", "production", 0, "").unwrap_err();
+        assert!(error.contains("Unknown JSX mode"));
+    }
+
+    #[test]
+    fn test_get_build_contexts_jsx_dev() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let page_path = temp_dir.path().join("page.jsx");
+
+        // Stand-in for react/jsx-dev-runtime that echoes each element's source location
+        fs::create_dir_all(node_modules_path.join("react")).unwrap();
+        fs::write(
+            node_modules_path.join("react/jsx-dev-runtime.js"),
+            r##"exports.jsxDEV = (type, props, key, isStaticChildren, source) => `${type}@${source.lineNumber}`;"##,
+        )
+        .unwrap();
+        fs::write(
+            &page_path,
+            "export const Index = () => (\n  <main>{\"<DEV>\"}</main>\n);\n",
+        )
+        .unwrap();
+
+        let specs = format!(
+            r##"[{{"path": "{}", "jsx": "automatic", "jsxDev": true}}]"##,
+            page_path.to_str().unwrap()
+        );
+        let ids = get_build_contexts(
+            &specs,
+            node_modules_path.to_str().unwrap(),
+            "development",
+            0,
+            "",
+        )
+        .unwrap();
+        rebuild_context(ids[0]).unwrap();
+
+        let output = fs::read_to_string(temp_dir.path().join("page.jsx.out")).unwrap();
+        assert!(output.contains("node_modules/react/jsx-dev-runtime.js"));
+        assert!(output.contains("import_jsx_dev_runtime.jsxDEV)"));
+        assert!(output.contains("lineNumber: 2"));
+        assert!(output.contains("page.jsx"));
+        assert!(!output.contains("jsx-runtime.js"));
+        assert!(describe_context(ids[0])
+            .unwrap()
+            .contains(r##""jsxDev":true"##));
+
+        let transform_specs = specs.replace(r##""jsx": "automatic", "##, "");
+        let error = get_build_contexts(&transform_specs, "", "development", 0, "").unwrap_err();
+        assert!(error.contains("jsxDev needs the automatic JSX runtime"));
+
+        let error = bundle_all(&format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "dist", "jsxDev": true}}"##,
+            page_path.to_str().unwrap()
+        ))
+        .unwrap_err();
+        assert!(error.contains("jsxDev needs the automatic JSX runtime"));
+    }
+
+    #[test]
+    fn test_bundle_all_output_collisions() {
+        let temp_dir = tempdir().unwrap();
+        let outdir_path = temp_dir.path().join("dist");
+
+        let mut entrypoints = Vec::new();
+        for folder in ["home", "settings"] {
+            let entrypoint_path = temp_dir.path().join(folder).join("index.tsx");
+            fs::create_dir_all(entrypoint_path.parent().unwrap()).unwrap();
+            fs::write(&entrypoint_path, format!("console.log(\"{}\");", folder)).unwrap();
+            entrypoints.push(format!("\"{}\"", entrypoint_path.to_str().unwrap()));
+        }
+
+        let build = |entry_names: &str| {
+            let options = format!(
+                r##"{{"entrypoints": [{}], "outdir": "{}", "entryNames": "{}"}}"##,
+                entrypoints.join(", "),
+                outdir_path.to_str().unwrap(),
+                entry_names
+            );
+            bundle_all(&options)
+        };
+
+        // The folder structure keeps the outputs apart by default
+        build("[dir]/[name]").unwrap();
+
+        let error = build("[name]").unwrap_err();
+        assert!(error.contains("Output path collision"));
+        assert!(error.contains("home/index.tsx"));
+        assert!(error.contains("settings/index.tsx"));
+        assert!(error.contains("would all be written to index.js"));
+
+        // A script and a stylesheet with the same name have different extensions
+        let script_path = temp_dir.path().join("app.tsx");
+        let stylesheet_path = temp_dir.path().join("app.css");
+        fs::write(&script_path, r##"console.log("<APP>");"##).unwrap();
+        fs::write(&stylesheet_path, "body { color: red; }").unwrap();
+        bundle_all(&format!(
+            r##"{{"entrypoints": ["{}", "{}"], "outdir": "{}"}}"##,
+            script_path.to_str().unwrap(),
+            stylesheet_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        ))
+        .unwrap();
+        assert!(outdir_path.join("app.js").exists());
+        assert!(outdir_path.join("app.css").exists());
+    }
+
+    #[test]
+    fn test_bundle_all_entry_names() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("shared.js"),
+            r##"export const shared = "<SHARED>";"##,
+        )
+        .unwrap();
+        fs::write(temp_dir.path().join("home.css"), "body { color: red; }").unwrap();
+        fs::write(
+            temp_dir.path().join("home.js"),
+            r##"import "./home.css"; import { shared } from "./shared.js"; console.log(shared);"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("about.js"),
+            r##"import { shared } from "./shared.js"; console.log(shared);"##,
+        )
+        .unwrap();
+
+        let build = |entry_names: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["home.js", "about.js"], "outdir": "dist", "environment": "production", "emitManifest": true, "entryNames": "{}", "absWorkingDir": "{}"}}"##,
+                entry_names,
+                temp_dir.path().to_str().unwrap()
+            ))
+        };
+
+        // Entries move under pages/ while the shared chunk stays where chunkNames puts it
+        let result = build("pages/[name]").unwrap();
+        let manifest = json_object_value(&result, "manifest");
+        assert!(manifest.contains(
+            r##"{"source":"home.js","output":"dist/pages/home.js","css":"dist/pages/home.css"}"##
+        ));
+        assert!(manifest.contains(r##"{"source":"about.js","output":"dist/pages/about.js"}"##));
+        let shared_chunk = fs::read_dir(temp_dir.path().join("dist"))
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().into_string().unwrap())
+            .find(|name| name.starts_with("chunk-"));
+        assert!(shared_chunk.is_some());
+
+        let error = build("pages/[nme]").unwrap_err();
+        assert!(error.contains(r##"Invalid entryNames "pages/[nme]": unknown placeholder [nme]"##));
+
+        let error = build("/pages/[name]").unwrap_err();
+        assert!(error.contains("templates are relative to the outdir"));
+    }
+
+    #[test]
+    fn test_bundle_all_chained_defines() {
+        let temp_dir = tempdir().unwrap();
+        fs::write(
+            temp_dir.path().join("config.js"),
+            r##"console.log(API_URL, BUILD_LABEL, SERVER);"##,
+        )
+        .unwrap();
+
+        let build = |defines: &str| {
+            bundle_all(&format!(
+                r##"{{"entrypoints": ["config.js"], "outdir": "dist", "environment": "production", "absWorkingDir": "{}", "defines": {}}}"##,
+                temp_dir.path().to_str().unwrap(),
+                defines
+            ))
+        };
+
+        // String references splice in the text, others the expression, and the
+        // built-in defines can be referenced too
+        build(
+            r##"{"HOST": "\"localhost\"", "PORT": "3000", "API_URL": "\"http://${HOST}:${PORT}/api\"", "BUILD_LABEL": "\"${process.env.NODE_ENV}-${API_URL}\"", "SERVER": "{\"host\": ${HOST}, \"port\": ${PORT}}"}"##,
+        )
+        .unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("dist/config.js")).unwrap();
+        assert!(output.contains(r##""http://localhost:3000/api""##));
+        assert!(output.contains(r##""production-http://localhost:3000/api""##));
+        assert!(output.contains(r##"{ host: "localhost", port: 3e3 }"##));
+
+        let cycle = r##"{"API_URL": "\"${HOST}/api\"", "HOST": "\"${ORIGIN}\"", "ORIGIN": "\"${API_URL}\"", "BUILD_LABEL": "1", "SERVER": "2"}"##;
+        let error = build(cycle).unwrap_err();
+        assert!(
+            error.contains("Define references form a cycle: API_URL -> HOST -> ORIGIN -> API_URL")
+        );
+        let problems = validate_options(&format!(
+            r##"{{"entrypoints": ["config.js"], "defines": {}}}"##,
+            cycle
+        ))
+        .unwrap();
+        assert!(problems.contains("Define references form a cycle"));
+    }
+
+    #[test]
+    fn test_bundle_all_feature_flags() {
+        let temp_dir = tempdir().unwrap();
+        let entrypoint_path = temp_dir.path().join("page.js");
+        let outdir_path = temp_dir.path().join("dist");
+        fs::write(
+            &entrypoint_path,
+            r##"if (FEATURE_X) { console.log("<ENABLED>"); } else { console.log("<DISABLED>"); }"##,
+        )
+        .unwrap();
+
+        let options = format!(
+            r##"{{"entrypoints": ["{}"], "outdir": "{}", "featureFlags": {{"FEATURE_X": false}}}}"##,
+            entrypoint_path.to_str().unwrap(),
+            outdir_path.to_str().unwrap()
+        );
+        bundle_all(&options).unwrap();
+
+        let output = fs::read_to_string(outdir_path.join("page.js")).unwrap();
+        assert!(output.contains("<DISABLED>"));
+        assert!(!output.contains("<ENABLED>"));
+    }
+
+    #[test]
+    fn test_transform_cache() {
+        let _transform_cache = TRANSFORM_CACHE
+            .write()
+            .unwrap_or_else(|error| error.into_inner());
+
+        // Disabled by default, so nothing is counted or stored
+        let stats = get_transform_cache_stats();
+        assert!(stats.contains(r#""entries":0,"capacity":0,"hits":0,"misses":0"#));
+
+        set_transform_cache_size(2);
+        let (code, _) =
+            transform_with_source_map("const a: number = 1;", "a.ts", "ts", "").unwrap();
+        let (cached_code, _) =
+            transform_with_source_map("const a: number = 1;", "a.ts", "ts", "").unwrap();
+        assert_eq!(code, cached_code);
+        assert!(
+            get_transform_cache_stats().contains(r#""entries":1,"capacity":2,"hits":1,"misses":1"#)
+        );
+
+        // Different options are a different entry, and the oldest entry is evicted past
+        // the cap
+        transform_with_source_map("const a: number = 1;", "b.ts", "ts", "").unwrap();
+        transform_many(&["const b: number = 2;"], &["ts"]).unwrap();
+        transform_with_source_map("const a: number = 1;", "a.ts", "ts", "").unwrap();
+        assert!(
+            get_transform_cache_stats().contains(r#""entries":2,"capacity":2,"hits":1,"misses":4"#)
+        );
+
+        // Failures aren't cached
+        transform_many(&["const = ;"], &["js"]).unwrap();
+        transform_many(&["const = ;"], &["js"]).unwrap();
+        assert!(get_transform_cache_stats().contains(r#""hits":1,"misses":6"#));
+
+        clear_transform_cache();
+        assert!(
+            get_transform_cache_stats().contains(r#""entries":0,"capacity":2,"hits":0,"misses":0"#)
+        );
+
+        set_transform_cache_size(0);
+    }
+
+    #[test]
+    fn test_transform_with_source_map() {
+        let _transform_cache = shared_transform_cache();
+        // The generated line maps back to the third line of the original file
+        let input_source_map =
+            r##"{"version":3,"sources":["original.ts"],"names":[],"mappings":"AAEA"}"##;
+
+        let (code, source_map) = transform_with_source_map(
+            "const value: number = 1;",
+            "generated.ts",
+            "ts",
+            input_source_map,
+        )
+        .unwrap();
+
+        assert!(code.contains("const value = 1;"));
+        assert!(source_map.contains("original.ts"));
+        assert!(!source_map.contains("generated.ts"));
+        assert!(source_map.contains("AAEA"));
+    }
+
+    #[test]
+    fn test_get_context_output_changes() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("client.js");
+        let output_prefix = js_file_path.to_str().unwrap();
+        fs::write(temp_dir.path().join("style.css"), "body { color: red; }").unwrap();
+        fs::write(&js_file_path, "").unwrap();
+
+        let context_id =
+            get_build_context(output_prefix, "", "development", 0, "", false, "").unwrap();
+
+        let rebuild = |contents: &str| {
+            fs::write(&js_file_path, contents).unwrap();
+            rebuild_context(context_id).unwrap();
+            get_context_output_changes(context_id).unwrap()
+        };
+
+        // Importing a stylesheet adds a CSS output alongside the script
+        let changes = rebuild(r##"console.log("<INITIAL>");"##);
+        assert!(changes.contains(&format!(r##""added":["{}.out","##, output_prefix)));
+
+        let changes = rebuild(r##"import "./style.css"; console.log("<UPDATED>");"##);
+        assert!(changes.contains(&format!(r##""added":["{}.css","##, output_prefix)));
+        assert!(changes.contains(&format!(r##""changed":["{}.out","##, output_prefix)));
+
+        let changes = rebuild(r##"console.log("<UPDATED>");"##);
+        assert!(changes.contains(&format!(r##""removed":["{}.css","##, output_prefix)));
+    }
+
+    #[test]
+    fn test_get_last_result() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("client.js");
+        fs::write(&js_file_path, r##"console.log("<VALID>");"##).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+        assert_eq!(get_last_result(context_id).unwrap(), "null");
+
+        rebuild_context(context_id).unwrap();
+        let last_result = get_last_result(context_id).unwrap();
+        assert!(last_result.contains(r##""success":true,"errors":[],"warnings":[]"##));
+
+        fs::write(&js_file_path, r##"console.log("<BROKEN>""##).unwrap();
+        rebuild_context(context_id).unwrap_err();
+        let last_result = get_last_result(context_id).unwrap();
+        assert!(last_result.contains(r##""success":false"##));
+        assert!(last_result.contains("client.js:1:"));
+
+        // Reading it again doesn't rebuild, so the result stays the same even after a fix
+        fs::write(&js_file_path, r##"console.log("<FIXED>");"##).unwrap();
+        assert_eq!(get_last_result(context_id).unwrap(), last_result);
+
+        rebuild_context(context_id).unwrap();
+        assert!(get_last_result(context_id)
+            .unwrap()
+            .contains(r##""success":true"##));
+
+        assert!(get_last_result(-1).is_err());
+    }
+
+    #[test]
+    fn test_recreate_on_internal_error() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("client.js");
+        fs::write(&js_file_path, r##"console.log("<HEALTHY>");"##).unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+
+        // Only affects internal failures, so turning it on for the whole process is safe
+        set_recreate_on_internal_error(true);
+
+        rebuild_context(context_id).unwrap();
+        assert!(get_last_result(context_id)
+            .unwrap()
+            .contains(r##""recreated":false"##));
+
+        // Errors in the code itself aren't retried
+        fs::write(&js_file_path, r##"console.log("<BROKEN>""##).unwrap();
+        let error = rebuild_context(context_id).unwrap_err();
+        assert_eq!(error.matches("Error in file").count(), 1);
+        let last_result = get_last_result(context_id).unwrap();
+        assert!(last_result.contains(r##""success":false"##));
+        assert!(last_result.contains(r##""recreated":false"##));
+
+        fs::write(&js_file_path, r##"console.log("<HEALED>");"##).unwrap();
+        rebuild_context(context_id).unwrap();
+        let output = fs::read_to_string(temp_dir.path().join("client.js.out")).unwrap();
+        assert!(output.contains("<HEALED>"));
+
+        set_recreate_on_internal_error(false);
+    }
+
+    #[test]
+    fn test_get_context_inputs() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let node_modules_path = temp_dir.path().join("node_modules");
+        let js_file_path = temp_dir.path().join("client.js");
+
+        fs::create_dir_all(node_modules_path.join("left-pad")).unwrap();
+        fs::write(
+            node_modules_path.join("left-pad/index.js"),
+            r##"module.exports = (value) => " " + value;"##,
+        )
+        .unwrap();
+        fs::create_dir(temp_dir.path().join("components")).unwrap();
+        fs::write(
+            temp_dir.path().join("components/button.js"),
+            r##"import "./button.css"; export const label = "<BUTTON>";"##,
+        )
+        .unwrap();
+        fs::write(
+            temp_dir.path().join("components/button.css"),
+            ".button { color: red; }",
+        )
+        .unwrap();
+        fs::write(
+            &js_file_path,
+            r##"import pad from "left-pad"; import { label } from "./components/button.js"; console.log(pad(label));"##,
+        )
+        .unwrap();
+
+        let context_id = get_build_context(
+            js_file_path.to_str().unwrap(),
+            node_modules_path.to_str().unwrap(),
+            "development",
+            0,
+            "",
+            false,
+            "",
+        )
+        .unwrap();
+        assert_eq!(get_context_inputs(context_id, false).unwrap(), "[]");
+        rebuild_context(context_id).unwrap();
+
+        // Transitive imports are included, each once, as absolute paths
+        let path = |relative: &str| {
+            format!(
+                r##""{}""##,
+                temp_dir.path().join(relative).to_str().unwrap()
+            )
+        };
+        let inputs = get_context_inputs(context_id, false).unwrap();
+        for relative in [
+            "client.js",
+            "components/button.js",
+            "components/button.css",
+            "node_modules/left-pad/index.js",
+        ] {
+            assert_eq!(inputs.matches(&path(relative)).count(), 1);
+        }
+        assert!(!inputs.contains("<runtime>"));
+
+        let inputs = get_context_inputs(context_id, true).unwrap();
+        assert!(inputs.contains(&path("components/button.js")));
+        assert!(!inputs.contains("node_modules"));
+
+        assert!(get_context_inputs(-1, false).is_err());
+    }
+
+    #[test]
+    fn test_get_build_context_stats() {
+        let _contexts = shared_contexts();
+        let temp_dir = tempdir().unwrap();
+        let js_file_path = temp_dir.path().join("ssr.js");
+        fs::write(&js_file_path, r##"export const Index = () => "<STATS>";"##).unwrap();
+
+        let context_id = get_build_context(
+            &js_file_path.to_str().unwrap(),
+            "",
+            "development",
+            0,
+            "",
+            true,
+            "",
+        )
+        .unwrap();
+        set_context_keep_outputs(context_id, true).unwrap();
+        rebuild_context(context_id).unwrap();
+
+        // Other tests create contexts concurrently, so only check lower bounds
+        let stats = get_build_context_stats().unwrap();
+        let number = |key: &str| -> usize {
+            let prefix = format!("\"{}\":", key);
+            let start = stats.find(&prefix).expect("Key not found") + prefix.len();
+            let end = start + stats[start..].find(|c: char| !c.is_ascii_digit()).unwrap();
+            stats[start..end].parse().unwrap()
+        };
+        assert!(number("contexts") >= 1);
+        assert!(number("keepingOutputs") >= 1);
+        assert!(number("retainedOutputBytes") > "<STATS>".len());
+        assert!(number("goroutines") >= 1);
+
+        remove_context(context_id);
+    }
+
+    #[test]
+    fn test_dispose_contexts_older_than() {
+        // Disposing by age can take other tests' contexts with it
+        let _contexts = CONTEXT_CAP
+            .write()
+            .unwrap_or_else(|error| error.into_inner());
+        let temp_dir = tempdir().unwrap();
+
+        let create = |name: &str| {
+            let js_file_path = temp_dir.path().join(name);
+            fs::write(&js_file_path, r##"console.log("<AGE>");"##).unwrap();
+            let context_id = get_build_context(
+                js_file_path.to_str().unwrap(),
+                "",
+                "development",
+                0,
+                "",
+                false,
+                "",
+            )
+            .unwrap();
+            (context_id, js_file_path.to_str().unwrap().to_string())
+        };
+        let (stale, stale_path) = create("stale.js");
+        let (fresh, fresh_path) = create("fresh.js");
+
+        // Nothing is an hour old yet
+        let (_, filenames) = dispose_contexts_older_than(3600);
+        assert!(!filenames.contains(&stale_path) && !filenames.contains(&fresh_path));
+
+        // Rebuilding resets a context's age
+        std::thread::sleep(std::time::Duration::from_millis(1200));
+        rebuild_context(fresh).unwrap();
+
+        let (count, filenames) = dispose_contexts_older_than(1);
+        assert!(count >= 1);
+        assert!(filenames.contains(&format!("\"{}\"", stale_path)));
+        assert!(!filenames.contains(&fresh_path));
+
+        assert!(!remove_context(stale));
+        assert!(remove_context(fresh));
+    }
+
+    #[test]
+    fn test_set_max_contexts() {
+        let _contexts = CONTEXT_CAP
+            .write()
+            .unwrap_or_else(|error| error.into_inner());
+        let temp_dir = tempdir().unwrap();
+
+        let create = |name: &str| {
+            let js_file_path = temp_dir.path().join(name);
+            fs::write(&js_file_path, r##"export const Index = () => "<EVICT>";"##).unwrap();
+            get_build_context(
+                js_file_path.to_str().unwrap(),
+                "",
+                "development",
+                0,
+                "",
+                true,
+                "",
+            )
+            .unwrap()
+        };
+
+        let first = create("first.js");
+        let second = create("second.js");
+        let third = create("third.js");
+
+        // Rebuilding the first context makes the second the least recently used
+        rebuild_context(first).unwrap();
+
+        // Contexts left over from other tests are all older than these, so they're
+        // evicted first. Only the order of this test's contexts is checked.
+        let evicted = Arc::new(Mutex::new(Vec::new()));
+        let evicted_clone = evicted.clone();
+        set_max_contexts(
+            2,
+            Some(Arc::new(Box::new(move |id| {
+                evicted_clone.lock().unwrap().push(id)
+            }))),
+        );
+        let fourth = create("fourth.js");
+        set_max_contexts(0, None);
+
+        let ours = [first, second, third, fourth];
+        let evicted: Vec<c_int> = evicted
+            .lock()
+            .unwrap()
+            .iter()
+            .copied()
+            .filter(|id| ours.contains(id))
+            .collect();
+        assert_eq!(evicted, vec![second, third]);
+        assert!(!remove_context(second));
+
+        for id in [first, fourth] {
+            assert!(remove_context(id));
+        }
     }
 }